@@ -0,0 +1,233 @@
+package paygent
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultModelAliases maps provider-native model IDs to the SDK's model
+// constants. Keys are compared after aliasKey, so "claude-sonnet-4.5" and
+// "claude-sonnet-4-5" are the same alias. Dated snapshots, Bedrock and Vertex
+// decorations are stripped before lookup, so only base IDs are listed.
+var defaultModelAliases = map[string]string{
+	// OpenAI on Azure
+	"gpt-35-turbo":          GPT35Turbo,
+	"gpt-35-turbo-instruct": GPT35TurboInstruct,
+	"gpt-35-turbo-16k":      GPT35Turbo16K0613,
+
+	// Anthropic
+	"claude-sonnet-4-5": Sonnet45,
+	"claude-haiku-4-5":  Haiku45,
+	"claude-opus-4-1":   Opus41,
+	"claude-sonnet-4":   Sonnet4,
+	"claude-sonnet-4-0": Sonnet4,
+	"claude-opus-4":     Opus4,
+	"claude-opus-4-0":   Opus4,
+	"claude-3-7-sonnet": Sonnet37,
+	"claude-3-5-haiku":  Haiku35,
+	"claude-3-opus":     Opus3,
+	"claude-3-haiku":    Haiku3,
+
+	// Google
+	"gemini-2.5-pro":                        Gemini25Pro,
+	"gemini-2.5-flash":                      Gemini25Flash,
+	"gemini-2.5-flash-preview":              Gemini25FlashPreview,
+	"gemini-2.5-flash-lite":                 Gemini25FlashLite,
+	"gemini-2.5-flash-lite-preview":         Gemini25FlashLitePreview,
+	"gemini-2.5-flash-native-audio":         Gemini25FlashNativeAudio,
+	"gemini-2.5-flash-native-audio-preview": Gemini25FlashNativeAudio,
+	"gemini-2.5-flash-image":                Gemini25FlashImage,
+	"gemini-2.5-flash-image-preview":        Gemini25FlashImage,
+	"gemini-2.5-flash-preview-tts":          Gemini25FlashPreviewTTS,
+	"gemini-2.5-pro-preview-tts":            Gemini25ProPreviewTTS,
+	"gemini-2.5-computer-use-preview":       Gemini25ComputerUsePreview,
+
+	// Meta, as named by Together AI and Bedrock
+	"llama-4-maverick":                       Llama4Maverick,
+	"llama-4-maverick-17b-128e-instruct":     Llama4Maverick,
+	"llama-4-maverick-17b-128e-instruct-fp8": Llama4Maverick,
+	"llama4-maverick-17b-instruct":           Llama4Maverick,
+	"llama-4-scout":                          Llama4Scout,
+	"llama-4-scout-17b-16e-instruct":         Llama4Scout,
+	"llama4-scout-17b-instruct":              Llama4Scout,
+	"llama-3.3-70b-instruct-turbo":           Llama3370BInstructTurbo,
+	"llama3-3-70b-instruct":                  Llama3370BInstructTurbo,
+	"llama-3.2-3b-instruct-turbo":            Llama323BInstructTurbo,
+	"llama3-2-3b-instruct":                   Llama323BInstructTurbo,
+	"meta-llama-3.1-405b-instruct-turbo":     Llama31405BInstructTurbo,
+	"llama3-1-405b-instruct":                 Llama31405BInstructTurbo,
+	"meta-llama-3.1-70b-instruct-turbo":      Llama3170BInstructTurbo,
+	"llama3-1-70b-instruct":                  Llama3170BInstructTurbo,
+	"meta-llama-3.1-8b-instruct-turbo":       Llama318BInstructTurbo,
+	"llama3-1-8b-instruct":                   Llama318BInstructTurbo,
+	"meta-llama-3-70b-instruct-turbo":        Llama370BInstructTurbo,
+	"llama3-70b-instruct":                    Llama370BInstructTurbo,
+	"llama-3-70b-chat":                       Llama370BInstructReference,
+	"meta-llama-3-8b-instruct-lite":          Llama38BInstructLite,
+	"llama3-8b-instruct":                     Llama38BInstructLite,
+	"llama-2-70b-chat":                       LLaMA2,
+	"llama-guard-4-12b":                      LlamaGuard412B,
+	"llama-guard-3-11b-vision-turbo":         LlamaGuard311BVisionTurbo,
+	"meta-llama-guard-3-8b":                  LlamaGuard38B,
+	"llamaguard-2-8b":                        LlamaGuard28B,
+	"llama-rank-v1":                          SalesforceLlamaRankV18B,
+
+	// Amazon
+	"nova-micro": AmazonNovaMicro,
+	"nova-lite":  AmazonNovaLite,
+	"nova-pro":   AmazonNovaPro,
+
+	// Mistral AI
+	"mistral-7b-instruct": Mistral7BInstruct,
+	"open-mistral-7b":     Mistral7BInstruct,
+	"mistral-large":       MistralLarge,
+	"mistral-small":       MistralSmall,
+	"mistral-medium":      MistralMedium,
+
+	// Cohere
+	"command-r7b":          CommandR7B,
+	"command-r":            CommandR,
+	"command-r-plus":       CommandRPlus,
+	"command-a":            CommandA,
+	"c4ai-aya-expanse-8b":  AyaExpanse8B32B,
+	"c4ai-aya-expanse-32b": AyaExpanse8B32B,
+
+	// DeepSeek
+	"deepseek-chat":     DeepSeekChat,
+	"deepseek-reasoner": DeepSeekReasoner,
+	"deepseek-r1":       DeepSeekR1Global,
+	"deepseek-v3.2-exp": DeepSeekV32Exp,
+}
+
+var (
+	// bedrockRegionPrefix matches the cross-region inference profile of a Bedrock ID, e.g. "us."
+	bedrockRegionPrefix = regexp.MustCompile(`^(us|us-gov|eu|apac|jp|au|ca|global)\.`)
+	// bedrockVendorPrefix matches the vendor of a Bedrock ID, e.g. "anthropic."
+	bedrockVendorPrefix = regexp.MustCompile(`^(anthropic|amazon|meta|mistral|cohere|deepseek)\.`)
+	// bedrockVersionSuffix matches the version of a Bedrock ID, e.g. "-v1:0"
+	bedrockVersionSuffix = regexp.MustCompile(`(-v\d+)?:\d+$|-v\d+$`)
+	// snapshotSuffix matches a dated or rolling snapshot, e.g. "-20250929",
+	// "-2024-08-06", "-09-2025", "-001" or "-latest"
+	snapshotSuffix = regexp.MustCompile(`-(\d{8}|\d{4}-\d{2}-\d{2}|\d{2}-\d{4}|\d{3}|latest)$`)
+)
+
+// modelAliases maps model IDs to the SDK's model names
+type modelAliases struct {
+	mu      sync.RWMutex
+	aliases map[string]string
+}
+
+func newModelAliases(aliases map[string]string) *modelAliases {
+	a := &modelAliases{aliases: make(map[string]string, len(aliases))}
+	for alias, model := range aliases {
+		a.aliases[aliasKey(alias)] = model
+	}
+	return a
+}
+
+// lookup returns the model an ID is an alias of
+func (a *modelAliases) lookup(id string) (string, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	model, ok := a.aliases[aliasKey(id)]
+	return model, ok
+}
+
+// set adds or replaces an alias
+func (a *modelAliases) set(alias, model string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.aliases[aliasKey(alias)] = model
+}
+
+// builtinAliases resolves the SDK's provider-native IDs and the model names
+// themselves, so "sonnet 4.5" is Sonnet45
+var builtinAliases = func() *modelAliases {
+	aliases := newModelAliases(defaultModelAliases)
+	for model := range defaultModelPricing {
+		aliases.aliases[aliasKey(model)] = model
+	}
+	return aliases
+}()
+
+// aliasSeparators replaces the separators aliasKey treats as dashes
+var aliasSeparators = strings.NewReplacer(".", "-", "_", "-", " ", "-")
+
+// aliasKey folds the spellings of a model ID: case, and dots, underscores and
+// spaces against dashes
+func aliasKey(id string) string {
+	return aliasSeparators.Replace(strings.ToLower(strings.TrimSpace(id)))
+}
+
+// modelIDCandidates returns the forms of a provider-native model ID to look
+// up, from the most to the least specific: as given, without its namespace
+// ("publishers/google/models/", "anthropic/"), without Bedrock region, vendor
+// and version, without a Vertex "@version", then without snapshot suffixes
+func modelIDCandidates(id string) []string {
+	candidates := []string{id}
+	add := func(candidate string) {
+		if candidate != "" && candidate != candidates[len(candidates)-1] {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	name := strings.ToLower(strings.TrimSpace(id))
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	add(name)
+
+	name = bedrockVendorPrefix.ReplaceAllString(bedrockRegionPrefix.ReplaceAllString(name, ""), "")
+	name = bedrockVersionSuffix.ReplaceAllString(name, "")
+	add(name)
+
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+		add(name)
+	}
+
+	for snapshotSuffix.MatchString(name) {
+		name = snapshotSuffix.ReplaceAllString(name, "")
+		add(name)
+	}
+	return candidates
+}
+
+// RegisterModelAlias maps a model ID, such as an Azure deployment name or a
+// fine-tune ID, onto a model the client has a price for. Caller aliases take
+// precedence over the SDK's built-in aliases.
+func (c *Client) RegisterModelAlias(alias, model string) {
+	c.aliases.set(alias, model)
+}
+
+// CanonicalModel returns the model name the client prices id as, resolving
+// provider-native IDs, dated snapshots and Bedrock, Vertex and Azure names,
+// e.g. "us.anthropic.claude-sonnet-4-5-20250929-v1:0" is Sonnet45. It returns
+// id unchanged when it is not an alias of a model with a price.
+func (c *Client) CanonicalModel(id string) string {
+	if model, ok := c.canonicalModel(id, time.Now()); ok {
+		return model
+	}
+	return id
+}
+
+// canonicalModel resolves id to a model with a price at the given time
+func (c *Client) canonicalModel(id string, at time.Time) (string, bool) {
+	for _, candidate := range modelIDCandidates(id) {
+		for _, aliases := range []*modelAliases{c.aliases, builtinAliases} {
+			if model, ok := aliases.lookup(candidate); ok {
+				if _, _, priced := c.pricing.Lookup(model, at); priced {
+					return model, true
+				}
+			}
+		}
+		if _, _, priced := c.pricing.Lookup(candidate, at); priced {
+			return candidate, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,106 @@
+package paygent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCanonicalModel(t *testing.T) {
+	client := NewClient("test-api-key")
+
+	tests := []struct {
+		id       string
+		expected string
+	}{
+		{id: "claude-sonnet-4-5-20250929", expected: Sonnet45},
+		{id: "us.anthropic.claude-sonnet-4-5-20250929-v1:0", expected: Sonnet45},
+		{id: "claude-sonnet-4-5@20250929", expected: Sonnet45},
+		{id: "anthropic/claude-sonnet-4.5", expected: Sonnet45},
+		{id: "claude-3-5-haiku-latest", expected: Haiku35},
+		{id: "sonnet 4.5", expected: Sonnet45},
+		{id: "gemini-2.5-pro", expected: Gemini25Pro},
+		{id: "publishers/google/models/gemini-2.5-flash-lite-preview-09-2025", expected: Gemini25FlashLitePreview},
+		{id: "us.amazon.nova-lite-v1:0", expected: AmazonNovaLite},
+		{id: "meta.llama3-3-70b-instruct-v1:0", expected: Llama3370BInstructTurbo},
+		{id: "meta-llama/Llama-4-Maverick-17B-128E-Instruct-FP8", expected: Llama4Maverick},
+		{id: "mistral.mistral-7b-instruct-v0:2", expected: Mistral7BInstruct},
+		{id: "command-r-plus-08-2024", expected: CommandRPlus},
+		{id: "gpt-4o-2024-08-06", expected: GPT4O},
+		{id: "gpt-4o-2024-05-13", expected: GPT4O20240513},
+		{id: "gpt-35-turbo", expected: GPT35Turbo},
+		{id: "my-unknown-model", expected: "my-unknown-model"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			if got := client.CanonicalModel(tt.id); got != tt.expected {
+				t.Errorf("CanonicalModel(%q) = %q, want %q", tt.id, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBuiltinAliasesResolve(t *testing.T) {
+	for alias, model := range defaultModelAliases {
+		if _, ok := defaultModelPricing[model]; !ok {
+			t.Errorf("Alias %q maps to %q, which has no default price", alias, model)
+		}
+	}
+
+	// Folding model names must not make two of them collide
+	seen := make(map[string]string)
+	for model := range defaultModelPricing {
+		if other, ok := seen[aliasKey(model)]; ok {
+			t.Errorf("Models %q and %q fold to the same alias", model, other)
+		}
+		seen[aliasKey(model)] = model
+	}
+}
+
+func TestRegisterModelAlias(t *testing.T) {
+	client := NewClient("test-api-key")
+	client.RegisterModelAlias("prod-gpt4o-eastus", GPT4O)
+
+	request, err := client.BuildUsageRequest("agent", "customer", "indicator", UsageData{Model: "prod-gpt4o-eastus", PromptTokens: 1000})
+	if err != nil {
+		t.Fatalf("BuildUsageRequest() error = %v", err)
+	}
+	if request.Amount != MoneyFromFloat(0.0025) || request.PricedAsModel != GPT4O {
+		t.Errorf("Expected deployment priced as %s at 0.0025, got %s as %q", GPT4O, request.Amount, request.PricedAsModel)
+	}
+
+	// Caller aliases take precedence over built-in ones
+	client.RegisterModelAlias("claude-sonnet-4-5", Opus41)
+	if got := client.CanonicalModel("claude-sonnet-4-5-20250929"); got != Opus41 {
+		t.Errorf("Expected caller alias to win, got %q", got)
+	}
+}
+
+func TestAliasesShareRulesAndVolume(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client := NewClientWithURL("test-api-key", server.URL)
+	if err := client.SetBillingRule(BillingRule{Name: "gpt-4o-markup", Model: GPT4O, MarkupPercent: 10}); err != nil {
+		t.Fatalf("SetBillingRule() error = %v", err)
+	}
+
+	usageData := UsageData{Model: "gpt-4o-2024-08-06", PromptTokens: 1000, CompletionTokens: 500}
+	request, err := client.BuildUsageRequest("agent", "customer", "indicator", usageData)
+	if err != nil {
+		t.Fatalf("BuildUsageRequest() error = %v", err)
+	}
+	if request.BillingRule != "gpt-4o-markup" {
+		t.Errorf("Expected the canonical model's rule to apply to its snapshot, got %q", request.BillingRule)
+	}
+
+	if err := client.SendUsage("agent", "customer", "indicator", usageData); err != nil {
+		t.Fatalf("SendUsage() error = %v", err)
+	}
+	for _, model := range []string{GPT4O, "gpt-4o-2024-08-06"} {
+		if volume := client.TokenVolume(model); volume != 1500 {
+			t.Errorf("TokenVolume(%q) = %d, want 1500", model, volume)
+		}
+	}
+}
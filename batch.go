@@ -0,0 +1,229 @@
+package paygent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// defaultMaxBatchEvents is the default maximum number of events per batch request
+	defaultMaxBatchEvents = 500
+	// defaultMaxBatchBytes is the default maximum encoded size of a batch request body
+	defaultMaxBatchBytes = 1 << 20
+)
+
+// batchRequest is the request body posted to /api/v1/usage/batch
+type batchRequest struct {
+	Events []json.RawMessage `json:"events"`
+}
+
+// batchEvent is an event of a batch request. IdempotencyKey is the event ID,
+// the key /api/v1/usage receives in its Idempotency-Key header, so the API
+// bills an event once whichever endpoint and batch it arrives in.
+type batchEvent struct {
+	APIRequest
+	IdempotencyKey string `json:"idempotencyKey"`
+}
+
+// batchResponse is the response body returned by /api/v1/usage/batch.
+// Result indices are relative to the events of that request.
+type batchResponse struct {
+	Results []BatchItemResult `json:"results"`
+}
+
+// ErrEventRejected is wrapped by per-event errors the API reports in a batch response
+var ErrEventRejected = errors.New("paygent: usage event rejected")
+
+// BatchItemResult represents the outcome of a single event in a batch
+type BatchItemResult struct {
+	Index   int    `json:"index"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	// Err is the error behind a failed event. It wraps ErrEventRejected when the API
+	// rejected the event, or the transport/context error when the whole request failed.
+	Err error `json:"-"`
+}
+
+// BatchResult represents the outcome of a SendUsageBatch call.
+// Results holds one entry per submitted record, in submission order.
+type BatchResult struct {
+	Results   []BatchItemResult
+	Succeeded int
+	Failed    int
+}
+
+// SetBatchLimits sets the maximum number of events and the maximum encoded body
+// size in bytes of a single batch request. Larger batches are split automatically.
+// Non-positive values keep the current limit.
+func (c *Client) SetBatchLimits(maxEvents, maxBytes int) {
+	if maxEvents > 0 {
+		c.maxBatchEvents = maxEvents
+	}
+	if maxBytes > 0 {
+		c.maxBatchBytes = maxBytes
+	}
+}
+
+// SendUsageBatch submits many usage records to the Paygent API, splitting them into
+// as many batch requests as needed to respect the configured count and size limits.
+// The returned BatchResult reports success or failure per record. A non-nil error is
+// returned if any batch request failed as a whole; the affected records are marked
+// as failed in the result.
+func (c *Client) SendUsageBatch(ctx context.Context, records []APIRequest) (*BatchResult, error) {
+	c.logger.Infof("Starting sendUsageBatch with %d records", len(records))
+
+	result := &BatchResult{Results: make([]BatchItemResult, len(records))}
+	if len(records) == 0 {
+		return result, nil
+	}
+
+	encoded := make([]json.RawMessage, len(records))
+	eventIDs := make([]string, len(records))
+	for i, record := range records {
+		record.EventID = eventIDOrNew(record.EventID)
+		eventIDs[i] = record.EventID
+		body, err := json.Marshal(batchEvent{APIRequest: record, IdempotencyKey: record.EventID})
+		if err != nil {
+			c.logger.Errorf("Failed to marshal record %d: %v", i, err)
+			return nil, fmt.Errorf("failed to marshal record %d: %w", i, err)
+		}
+		encoded[i] = body
+	}
+
+	spooled := make([]bool, len(records))
+	for i, record := range records {
+		record.EventID = eventIDs[i]
+		spooled[i] = c.spoolAppend(record)
+	}
+
+	var errs []error
+	for _, chunk := range c.splitBatch(encoded) {
+		if err := c.sendBatchChunk(ctx, encoded[chunk.start:chunk.end], eventIDs[chunk.start:chunk.end], result.Results[chunk.start:chunk.end], chunk.start); err != nil {
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				// Don't keep hammering the API once the caller has given up
+				for i := chunk.end; i < len(records); i++ {
+					result.Results[i] = BatchItemResult{Index: i, Error: err.Error(), Err: err}
+				}
+				break
+			}
+		}
+	}
+
+	var delivered []string
+	for i, item := range result.Results {
+		if item.Success {
+			result.Succeeded++
+		} else {
+			result.Failed++
+		}
+		if item.Success || isPermanentFailure(item.Err) {
+			delivered = append(delivered, eventIDs[i])
+		}
+		record := records[i]
+		record.EventID = eventIDs[i]
+		c.settleUsage(record, item.Err, spooled[i])
+	}
+	c.spoolAck(delivered...)
+
+	c.logger.Infof("Finished sendUsageBatch: succeeded=%d, failed=%d", result.Succeeded, result.Failed)
+
+	return result, errors.Join(errs...)
+}
+
+// batchChunk is a half-open range of records sent in one batch request
+type batchChunk struct {
+	start, end int
+}
+
+// splitBatch groups encoded records into chunks that respect the count and byte limits.
+// A record that is larger than the byte limit on its own is sent in a chunk by itself.
+func (c *Client) splitBatch(encoded []json.RawMessage) []batchChunk {
+	// Size of the `{"events":[]}` envelope
+	const envelope = len(`{"events":[]}`)
+
+	var chunks []batchChunk
+	start, size := 0, envelope
+	for i, record := range encoded {
+		recordSize := len(record)
+		if i > start {
+			recordSize++ // separating comma
+		}
+		if i > start && (i-start >= c.maxBatchEvents || size+recordSize > c.maxBatchBytes) {
+			chunks = append(chunks, batchChunk{start: start, end: i})
+			start, size = i, envelope
+			recordSize = len(record)
+		}
+		size += recordSize
+	}
+	return append(chunks, batchChunk{start: start, end: len(encoded)})
+}
+
+// batchIdempotencyKey derives a stable idempotency key for a batch request from
+// its event IDs. It only deduplicates retries of that request; events are
+// deduplicated by their own key whatever batch they are sent in.
+func batchIdempotencyKey(eventIDs []string) string {
+	hash := sha256.New()
+	for _, id := range eventIDs {
+		hash.Write([]byte(id))
+		hash.Write([]byte{0})
+	}
+	return "batch-" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// sendBatchChunk posts one batch request and fills in results for its records.
+// offset is the index of the chunk's first record in the caller's slice.
+func (c *Client) sendBatchChunk(ctx context.Context, events []json.RawMessage, eventIDs []string, results []BatchItemResult, offset int) error {
+	fail := func(err error) error {
+		for i := range results {
+			results[i] = BatchItemResult{Index: offset + i, Error: err.Error(), Err: err}
+		}
+		return err
+	}
+
+	requestBody, err := json.Marshal(batchRequest{Events: events})
+	if err != nil {
+		c.logger.Errorf("Failed to marshal batch request body: %v", err)
+		return fail(fmt.Errorf("failed to marshal batch request body: %w", err))
+	}
+
+	c.logger.Debugf("Sending batch of %d events (%d bytes)", len(events), len(requestBody))
+
+	responseBody, err := c.doPost(ctx, "/api/v1/usage/batch", requestBody, batchIdempotencyKey(eventIDs))
+	if err != nil {
+		return fail(err)
+	}
+
+	// Every record is accepted unless the server reports otherwise
+	for i := range results {
+		results[i] = BatchItemResult{Index: offset + i, Success: true}
+	}
+
+	if len(responseBody) == 0 {
+		return nil
+	}
+
+	var response batchResponse
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		c.logger.Warnf("Failed to parse batch response, assuming all events accepted: %v", err)
+		return nil
+	}
+
+	for _, item := range response.Results {
+		if item.Index < 0 || item.Index >= len(results) {
+			c.logger.Warnf("Ignoring batch result with out-of-range index %d", item.Index)
+			continue
+		}
+		result := BatchItemResult{Index: offset + item.Index, Success: item.Success, Error: item.Error}
+		if !item.Success {
+			result.Err = fmt.Errorf("%w: %s", ErrEventRejected, item.Error)
+		}
+		results[item.Index] = result
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package paygent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestSendUsageBatch(t *testing.T) {
+	var mu sync.Mutex
+	var batchSizes []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/usage/batch" {
+			t.Errorf("Expected path '/api/v1/usage/batch', got '%s'", r.URL.Path)
+		}
+		var body struct {
+			Events []APIRequest `json:"events"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		batchSizes = append(batchSizes, len(body.Events))
+		mu.Unlock()
+
+		// Reject every event for the customer named "reject"
+		var response batchResponse
+		for i, event := range body.Events {
+			item := BatchItemResult{Index: i, Success: event.CustomerID != "reject"}
+			if !item.Success {
+				item.Error = "invalid customer"
+			}
+			response.Results = append(response.Results, item)
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	client := NewClientWithURL("test-api-key", server.URL)
+	client.SetBatchLimits(3, 0)
+
+	records := make([]APIRequest, 7)
+	for i := range records {
+		records[i] = APIRequest{AgentID: "agent", CustomerID: "customer", Model: GPT4O}
+	}
+	records[4].CustomerID = "reject"
+
+	result, err := client.SendUsageBatch(context.Background(), records)
+	if err != nil {
+		t.Fatalf("SendUsageBatch() error = %v", err)
+	}
+
+	if want := []int{3, 3, 1}; len(batchSizes) != len(want) || batchSizes[0] != 3 || batchSizes[1] != 3 || batchSizes[2] != 1 {
+		t.Errorf("Expected batch sizes %v, got %v", want, batchSizes)
+	}
+	if result.Succeeded != 6 || result.Failed != 1 {
+		t.Errorf("Expected 6 succeeded and 1 failed, got %d and %d", result.Succeeded, result.Failed)
+	}
+	for i, item := range result.Results {
+		if item.Index != i {
+			t.Errorf("Expected result %d to have index %d, got %d", i, i, item.Index)
+		}
+	}
+	if item := result.Results[4]; item.Success || !errors.Is(item.Err, ErrEventRejected) {
+		t.Errorf("Expected record 4 to be rejected, got %+v", item)
+	}
+}
+
+func TestSendUsageBatchFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	client := NewClientWithURL("test-api-key", server.URL)
+	result, err := client.SendUsageBatch(context.Background(), []APIRequest{{AgentID: "a"}, {AgentID: "b"}})
+	if err == nil {
+		t.Fatal("Expected error for failed batch request")
+	}
+	if result.Failed != 2 {
+		t.Errorf("Expected 2 failed records, got %d", result.Failed)
+	}
+}
+
+func TestSplitBatch(t *testing.T) {
+	client := NewClient("test-api-key")
+
+	encoded := make([]json.RawMessage, 5)
+	for i := range encoded {
+		encoded[i] = json.RawMessage(`{"agentId":"0123456789"}`) // 24 bytes
+	}
+
+	tests := []struct {
+		name      string
+		maxEvents int
+		maxBytes  int
+		expected  []batchChunk
+	}{
+		{
+			name:      "Single chunk",
+			maxEvents: 10,
+			maxBytes:  1 << 20,
+			expected:  []batchChunk{{0, 5}},
+		},
+		{
+			name:      "Split by count",
+			maxEvents: 2,
+			maxBytes:  1 << 20,
+			expected:  []batchChunk{{0, 2}, {2, 4}, {4, 5}},
+		},
+		{
+			name:      "Split by bytes",
+			maxEvents: 10,
+			maxBytes:  13 + 24 + 1 + 24, // envelope plus two records
+			expected:  []batchChunk{{0, 2}, {2, 4}, {4, 5}},
+		},
+		{
+			name:      "Oversized record sent alone",
+			maxEvents: 10,
+			maxBytes:  10,
+			expected:  []batchChunk{{0, 1}, {1, 2}, {2, 3}, {3, 4}, {4, 5}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client.maxBatchEvents = tt.maxEvents
+			client.maxBatchBytes = tt.maxBytes
+
+			chunks := client.splitBatch(encoded)
+			if len(chunks) != len(tt.expected) {
+				t.Fatalf("splitBatch() = %v, want %v", chunks, tt.expected)
+			}
+			for i := range chunks {
+				if chunks[i] != tt.expected[i] {
+					t.Errorf("splitBatch() = %v, want %v", chunks, tt.expected)
+					break
+				}
+			}
+		})
+	}
+}
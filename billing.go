@@ -0,0 +1,288 @@
+package paygent
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sync"
+)
+
+// BillingRule turns the provider cost of usage into the price billed to the
+// customer. A rule applies to usage matching all of its non-empty match fields;
+// when several rules match, the most specific one (the one with the most match
+// fields set) is applied, and the first one added among equally specific rules.
+//
+// The price is computed from the cost in the billing currency in this order:
+// markup or margin, discount, fixed fee, minimum, then free allowance.
+type BillingRule struct {
+	// Name identifies the rule. Setting a rule with an existing name replaces it.
+	Name string
+
+	// Match fields, empty matches any value. Model is matched against the
+	// canonical model usage is priced as, e.g. GPT4O for "gpt-4o-2024-08-06".
+	CustomerID string
+	AgentID    string
+	Indicator  string
+	Model      string
+
+	// MarkupPercent adds a percentage of the cost, e.g. 20 bills cost * 1.2
+	MarkupPercent float64
+	// MarginPercent prices so that the margin is a percentage of the price,
+	// e.g. 20 bills cost / 0.8. It cannot be combined with MarkupPercent.
+	MarginPercent float64
+	// DiscountPercent takes a percentage off the marked up price
+	DiscountPercent float64
+	// FixedFee is added to every event, in the billing currency
+	FixedFee Money
+	// MinimumAmount is the least billed for an event, in the billing currency
+	MinimumAmount Money
+	// FreeAllowance is an amount per customer, in the billing currency, that is
+	// not billed. It is consumed event by event as events are priced, until
+	// ResetBillingAllowances; an event that is dropped or permanently rejected
+	// gives back what it used.
+	FreeAllowance Money
+}
+
+// specificity returns the number of match fields set
+func (r BillingRule) specificity() int {
+	n := 0
+	for _, field := range []string{r.CustomerID, r.AgentID, r.Indicator, r.Model} {
+		if field != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// matches reports whether the rule applies to usage
+func (r BillingRule) matches(agentID, customerID, indicator, model string) bool {
+	return matchField(r.CustomerID, customerID) && matchField(r.AgentID, agentID) &&
+		matchField(r.Indicator, indicator) && matchField(r.Model, model)
+}
+
+// matchField reports whether a rule field matches a value, an empty field matching any
+func matchField(field, value string) bool {
+	return field == "" || field == value
+}
+
+// validate checks the rule's percentages and amounts
+func (r BillingRule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("billing rule name is required")
+	}
+	percents := []struct {
+		name  string
+		value float64
+	}{
+		{"MarkupPercent", r.MarkupPercent},
+		{"MarginPercent", r.MarginPercent},
+		{"DiscountPercent", r.DiscountPercent},
+	}
+	for _, p := range percents {
+		if math.IsNaN(p.value) || math.IsInf(p.value, 0) || p.value < 0 {
+			return fmt.Errorf("billing rule %q: %s must be a non-negative number, got %v", r.Name, p.name, p.value)
+		}
+	}
+	if r.MarginPercent >= 100 {
+		return fmt.Errorf("billing rule %q: MarginPercent must be less than 100", r.Name)
+	}
+	if r.DiscountPercent > 100 {
+		return fmt.Errorf("billing rule %q: DiscountPercent must not exceed 100", r.Name)
+	}
+	if r.MarkupPercent > 0 && r.MarginPercent > 0 {
+		return fmt.Errorf("billing rule %q: MarkupPercent and MarginPercent cannot both be set", r.Name)
+	}
+	if r.FixedFee.Nanos() < 0 || r.MinimumAmount.Nanos() < 0 || r.FreeAllowance.Nanos() < 0 {
+		return fmt.Errorf("billing rule %q: amounts must not be negative", r.Name)
+	}
+	return nil
+}
+
+// price applies the rule's markup or margin, discount, fixed fee and minimum to a cost
+func (r BillingRule) price(cost *big.Rat) *big.Rat {
+	price := new(big.Rat).Set(cost)
+	if r.MarginPercent > 0 {
+		price.Quo(price, new(big.Rat).Sub(big.NewRat(1, 1), percent(r.MarginPercent)))
+	} else {
+		price.Mul(price, new(big.Rat).Add(big.NewRat(1, 1), percent(r.MarkupPercent)))
+	}
+	price.Mul(price, new(big.Rat).Sub(big.NewRat(1, 1), percent(r.DiscountPercent)))
+	price.Add(price, r.FixedFee.Rat())
+	if minimum := r.MinimumAmount.Rat(); price.Cmp(minimum) < 0 {
+		price = minimum
+	}
+	return price
+}
+
+// percent returns p percent as an exact fraction
+func percent(p float64) *big.Rat {
+	r := ratFromFloat(p)
+	return r.Quo(r, big.NewRat(100, 1))
+}
+
+// billingRules holds the client's billing rules and the free allowance used per customer
+type billingRules struct {
+	mu    sync.Mutex
+	rules []BillingRule
+	// used is the free allowance consumed, keyed by rule name and customer ID
+	used map[[2]string]Money
+	// grants is the allowance each event built by this client consumed and
+	// that has not been settled yet, keyed by event ID
+	grants map[string]allowanceGrant
+}
+
+// allowanceGrant is the free allowance one event consumed from a rule
+type allowanceGrant struct {
+	key    [2]string
+	amount Money
+}
+
+func newBillingRules() *billingRules {
+	return &billingRules{used: make(map[[2]string]Money), grants: make(map[string]allowanceGrant)}
+}
+
+// SetBillingRule adds a billing rule, replacing any rule with the same name
+func (c *Client) SetBillingRule(rule BillingRule) error {
+	if err := rule.validate(); err != nil {
+		return err
+	}
+
+	c.rules.mu.Lock()
+	defer c.rules.mu.Unlock()
+
+	for i, existing := range c.rules.rules {
+		if existing.Name == rule.Name {
+			c.rules.rules[i] = rule
+			return nil
+		}
+	}
+	c.rules.rules = append(c.rules.rules, rule)
+	return nil
+}
+
+// RemoveBillingRule removes the billing rule with the given name, reporting whether it existed
+func (c *Client) RemoveBillingRule(name string) bool {
+	c.rules.mu.Lock()
+	defer c.rules.mu.Unlock()
+
+	for i, existing := range c.rules.rules {
+		if existing.Name == name {
+			c.rules.rules = append(c.rules.rules[:i], c.rules.rules[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ResetBillingAllowances restores every customer's free allowance, e.g. at the
+// start of a billing period
+func (c *Client) ResetBillingAllowances() {
+	c.rules.mu.Lock()
+	defer c.rules.mu.Unlock()
+
+	c.rules.used = make(map[[2]string]Money)
+	c.rules.grants = make(map[string]allowanceGrant)
+}
+
+// applyBillingRules prices a cost with the most specific matching billing rule.
+// Rules match the model the cost was priced as, so a rule for a canonical model
+// covers its provider-native IDs and snapshots. Without a matching rule the
+// cost is billed as is, and usage left for the server to price is never marked up.
+// Free allowance used by the event is remembered under eventID until it is settled.
+// A price out of the range of Money is an error and uses no allowance.
+func (c *Client) applyBillingRules(cost costBreakdown, eventID, agentID, customerID, indicator string) (costBreakdown, error) {
+	c.rules.mu.Lock()
+	defer c.rules.mu.Unlock()
+
+	var rule *BillingRule
+	for i := range c.rules.rules {
+		candidate := &c.rules.rules[i]
+		if candidate.matches(agentID, customerID, indicator, cost.PricedAs) &&
+			(rule == nil || candidate.specificity() > rule.specificity()) {
+			rule = candidate
+		}
+	}
+	if rule == nil || cost.Source == PricingSourceServer {
+		return cost, nil
+	}
+
+	costAmount := cost.Amount
+	price := rule.price(cost.converted(cost.Total))
+
+	// Free allowance covers as much of the price as remains. The allowance
+	// used never exceeds the rule's allowance, so the sums below cannot overflow.
+	var (
+		key  = [2]string{rule.Name, customerID}
+		used Money
+	)
+	if !rule.FreeAllowance.IsZero() {
+		remaining, _ := rule.FreeAllowance.Sub(c.rules.used[key])
+		covered := price
+		if remaining.Rat().Cmp(covered) < 0 {
+			covered = remaining.Rat()
+		}
+		if covered.Sign() > 0 {
+			used, _ = roundRat(covered, MoneyScale, RoundHalfEven)
+			price = new(big.Rat).Sub(price, covered)
+		}
+	}
+
+	amount, err := c.roundAmount(price)
+	if err != nil {
+		return costBreakdown{}, fmt.Errorf("billing rule '%s': %w", rule.Name, err)
+	}
+	if !used.IsZero() {
+		c.rules.used[key], _ = c.rules.used[key].Add(used)
+		grant := c.rules.grants[eventID]
+		grant.key = key
+		grant.amount, _ = grant.amount.Add(used)
+		c.rules.grants[eventID] = grant
+		cost.FreeAllowance = &used
+	}
+
+	cost.BillingRule = rule.Name
+	cost.CostAmount = &costAmount
+	cost.Amount = amount
+	c.logger.Debugf("Billing rule '%s' priced cost %s at %s %s", rule.Name, costAmount, cost.Amount, cost.Currency)
+	return cost, nil
+}
+
+// refundAllowance gives back the free allowance used by an event that will
+// never be delivered. Only allowance this client consumed is given back, so an
+// event priced by an earlier process and replayed from the spool refunds nothing.
+func (c *Client) refundAllowance(apiRequest APIRequest) {
+	c.rules.mu.Lock()
+	defer c.rules.mu.Unlock()
+
+	grant, ok := c.rules.grants[apiRequest.EventID]
+	if !ok {
+		return
+	}
+	delete(c.rules.grants, apiRequest.EventID)
+
+	// The grant is part of the allowance used, so this cannot overflow
+	if used, _ := c.rules.used[grant.key].Sub(grant.amount); used.Nanos() > 0 {
+		c.rules.used[grant.key] = used
+	} else {
+		delete(c.rules.used, grant.key)
+	}
+}
+
+// keepAllowance settles the free allowance used by a delivered event, which
+// stays used
+func (c *Client) keepAllowance(apiRequest APIRequest) {
+	c.rules.mu.Lock()
+	defer c.rules.mu.Unlock()
+
+	delete(c.rules.grants, apiRequest.EventID)
+}
+
+// billedReasoning returns the part of Amount billed for reasoning tokens: the
+// reasoning share of the cost, applied to the amount the billing rule priced
+func (b costBreakdown) billedReasoning() *big.Rat {
+	if b.BillingRule == "" || b.Total.Sign() == 0 {
+		return b.converted(b.ReasoningCost)
+	}
+	share := new(big.Rat).Quo(b.ReasoningCost, b.Total)
+	return share.Mul(share, b.Amount.Rat())
+}
@@ -0,0 +1,229 @@
+package paygent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBillingRules(t *testing.T) {
+	client := NewClient("test-api-key")
+	client.RegisterModelPricing("flat-model", ModelPricing{PromptTokensCost: 1, CompletionTokensCost: 1})
+
+	rules := []BillingRule{
+		{Name: "default-markup", MarkupPercent: 20},
+		{Name: "acme", CustomerID: "acme", MarginPercent: 20, DiscountPercent: 10},
+		{Name: "acme-support", CustomerID: "acme", Indicator: "support", FixedFee: MoneyFromFloat(0.5), MinimumAmount: MoneyFromFloat(5)},
+	}
+	for _, rule := range rules {
+		if err := client.SetBillingRule(rule); err != nil {
+			t.Fatalf("SetBillingRule(%s) error = %v", rule.Name, err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		customerID   string
+		indicator    string
+		tokens       int
+		expected     float64
+		expectedRule string
+	}{
+		{name: "Markup", customerID: "other", indicator: "chat", tokens: 1000, expected: 1.2, expectedRule: "default-markup"},
+		{name: "Margin and discount", customerID: "acme", indicator: "chat", tokens: 1000, expected: 1.125, expectedRule: "acme"},
+		{name: "Fixed fee", customerID: "acme", indicator: "support", tokens: 5000, expected: 5.5, expectedRule: "acme-support"},
+		{name: "Minimum", customerID: "acme", indicator: "support", tokens: 1000, expected: 5, expectedRule: "acme-support"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usageData := UsageData{Model: "flat-model", PromptTokens: tt.tokens}
+			request, err := client.BuildUsageRequest("agent", tt.customerID, tt.indicator, usageData)
+			if err != nil {
+				t.Fatalf("BuildUsageRequest() error = %v", err)
+			}
+			if request.Amount != MoneyFromFloat(tt.expected) || request.BillingRule != tt.expectedRule {
+				t.Errorf("Expected %v with rule %q, got %s with rule %q", tt.expected, tt.expectedRule, request.Amount, request.BillingRule)
+			}
+			if request.Cost == nil || *request.Cost != MoneyFromFloat(float64(tt.tokens)/1000) {
+				t.Errorf("Expected cost %v, got %v", float64(tt.tokens)/1000, request.Cost)
+			}
+		})
+	}
+
+	if !client.RemoveBillingRule("default-markup") {
+		t.Fatal("Expected RemoveBillingRule to find the rule")
+	}
+	request, err := client.BuildUsageRequest("agent", "other", "chat", UsageData{Model: "flat-model", PromptTokens: 1000})
+	if err != nil {
+		t.Fatalf("BuildUsageRequest() error = %v", err)
+	}
+	if request.Amount != MoneyFromFloat(1) || request.Cost != nil || request.BillingRule != "" {
+		t.Errorf("Expected cost billed as is without a rule, got %+v", request)
+	}
+}
+
+func TestBillingRuleFreeAllowance(t *testing.T) {
+	client := NewClient("test-api-key")
+	client.RegisterModelPricing("flat-model", ModelPricing{PromptTokensCost: 1, CompletionTokensCost: 1})
+	if err := client.SetBillingRule(BillingRule{Name: "trial", FreeAllowance: MoneyFromFloat(2.5)}); err != nil {
+		t.Fatalf("SetBillingRule() error = %v", err)
+	}
+
+	usageData := UsageData{Model: "flat-model", PromptTokens: 1000}
+	for i, want := range []float64{0, 0, 0.5, 1} {
+		request, err := client.BuildUsageRequest("agent", "customer", "indicator", usageData)
+		if err != nil {
+			t.Fatalf("BuildUsageRequest() error = %v", err)
+		}
+		if request.Amount != MoneyFromFloat(want) {
+			t.Errorf("Event %d: expected %v, got %s", i, want, request.Amount)
+		}
+	}
+
+	// Allowances are per customer
+	request, _ := client.BuildUsageRequest("agent", "new-customer", "indicator", usageData)
+	if !request.Amount.IsZero() {
+		t.Errorf("Expected a new customer's usage to be free, got %s", request.Amount)
+	}
+
+	client.ResetBillingAllowances()
+	request, _ = client.BuildUsageRequest("agent", "customer", "indicator", usageData)
+	if !request.Amount.IsZero() {
+		t.Errorf("Expected usage to be free after ResetBillingAllowances, got %s", request.Amount)
+	}
+}
+
+func TestBillingRuleFreeAllowanceRefund(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	client := NewClientWithURL("test-api-key", server.URL)
+	client.RegisterModelPricing("flat-model", ModelPricing{PromptTokensCost: 1, CompletionTokensCost: 1})
+	if err := client.SetBillingRule(BillingRule{Name: "trial", FreeAllowance: MoneyFromFloat(1.5)}); err != nil {
+		t.Fatalf("SetBillingRule() error = %v", err)
+	}
+
+	usageData := UsageData{Model: "flat-model", PromptTokens: 1000}
+	for i := 0; i < 3; i++ {
+		if err := client.SendUsage("agent", "customer", "indicator", usageData); err == nil {
+			t.Fatal("Expected SendUsage() to be rejected")
+		}
+	}
+
+	reporter := NewReporter(client, ReporterConfig{})
+	if err := reporter.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := reporter.Report("agent", "customer", "indicator", usageData); !errors.Is(err, ErrReporterClosed) {
+		t.Fatalf("Expected ErrReporterClosed, got %v", err)
+	}
+
+	// Rejected and dropped events gave their allowance back
+	request, err := client.BuildUsageRequest("agent", "customer", "indicator", usageData)
+	if err != nil {
+		t.Fatalf("BuildUsageRequest() error = %v", err)
+	}
+	if !request.Amount.IsZero() || request.FreeAllowance == nil || *request.FreeAllowance != MoneyFromFloat(1) {
+		t.Errorf("Expected the event to be covered by the allowance, got %s with %v covered", request.Amount, request.FreeAllowance)
+	}
+}
+
+func TestBillingRuleReplayedAllowanceNotRefunded(t *testing.T) {
+	rejecting := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rejecting {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	newClient := func(spool *Spool) *Client {
+		client := NewClientWithURL("test-api-key", server.URL)
+		client.RegisterModelPricing("flat-model", ModelPricing{PromptTokensCost: 1, CompletionTokensCost: 1})
+		if err := client.SetBillingRule(BillingRule{Name: "trial", FreeAllowance: MoneyFromFloat(1.5)}); err != nil {
+			t.Fatalf("SetBillingRule() error = %v", err)
+		}
+		client.SetSpool(spool)
+		return client
+	}
+	usageData := UsageData{Model: "flat-model", PromptTokens: 1000}
+
+	// A previous run spools an event covered by the allowance
+	dir := t.TempDir()
+	spool, err := OpenSpool(SpoolConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("OpenSpool() error = %v", err)
+	}
+	if err := newClient(spool).SendUsage("agent", "customer", "indicator", usageData); err == nil {
+		t.Fatal("Expected SendUsage() to fail while the API is down")
+	}
+	spool.Close()
+
+	spool, err = OpenSpool(SpoolConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("OpenSpool() error = %v", err)
+	}
+	defer spool.Close()
+	client := newClient(spool)
+	if _, err := client.BuildUsageRequest("agent", "customer", "indicator", usageData); err != nil {
+		t.Fatalf("BuildUsageRequest() error = %v", err)
+	}
+
+	// Rejecting the replayed event must not refund allowance this run never used
+	rejecting = true
+	if _, err := client.ReplaySpool(context.Background()); err == nil {
+		t.Fatal("Expected ReplaySpool() to be rejected")
+	}
+	request, err := client.BuildUsageRequest("agent", "customer", "indicator", usageData)
+	if err != nil {
+		t.Fatalf("BuildUsageRequest() error = %v", err)
+	}
+	if request.FreeAllowance == nil || *request.FreeAllowance != MoneyFromFloat(0.5) {
+		t.Errorf("Expected 0.5 of the allowance left, got %v covered", request.FreeAllowance)
+	}
+}
+
+func TestBillingRuleReasoningAmount(t *testing.T) {
+	client := NewClient("test-api-key")
+	client.RegisterModelPricing("flat-model", ModelPricing{PromptTokensCost: 1, CompletionTokensCost: 1})
+	if err := client.SetBillingRule(BillingRule{Name: "double", MarkupPercent: 100}); err != nil {
+		t.Fatalf("SetBillingRule() error = %v", err)
+	}
+
+	usageData := UsageData{Model: "flat-model", PromptTokens: 1000, CompletionTokens: 1000, ReasoningTokens: 500}
+	request, err := client.BuildUsageRequest("agent", "customer", "indicator", usageData)
+	if err != nil {
+		t.Fatalf("BuildUsageRequest() error = %v", err)
+	}
+	if request.Amount != MoneyFromFloat(4) {
+		t.Fatalf("Expected amount 4, got %s", request.Amount)
+	}
+	// Reasoning is a quarter of the cost, so a quarter of the billed amount
+	if request.ReasoningAmount == nil || *request.ReasoningAmount != MoneyFromFloat(1) {
+		t.Errorf("Expected reasoning amount 1, got %v", request.ReasoningAmount)
+	}
+}
+
+func TestSetBillingRuleValidation(t *testing.T) {
+	client := NewClient("test-api-key")
+
+	invalid := []BillingRule{
+		{MarkupPercent: 10},
+		{Name: "negative", MarkupPercent: -10},
+		{Name: "margin", MarginPercent: 100},
+		{Name: "discount", DiscountPercent: 150},
+		{Name: "both", MarkupPercent: 10, MarginPercent: 10},
+		{Name: "fee", FixedFee: MoneyFromFloat(-1)},
+	}
+	for _, rule := range invalid {
+		if err := client.SetBillingRule(rule); err == nil {
+			t.Errorf("Expected error for rule %+v", rule)
+		}
+	}
+}
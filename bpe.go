@@ -0,0 +1,171 @@
+package paygent
+
+import (
+	"container/heap"
+
+	"github.com/dlclark/regexp2"
+)
+
+// bpeMerge repeatedly merges the adjacent pair of symbols with the lowest
+// priority, leftmost first, until no pair can be merged. priority reports
+// false for pairs that do not merge. Candidate pairs are kept in a heap and
+// the symbols in a linked list, so a word of n symbols merges in O(n log n).
+func bpeMerge(symbols []string, priority func(left, right string) (float64, bool)) []string {
+	if len(symbols) < 2 {
+		return symbols
+	}
+
+	// next links each symbol to the one after it, -1 for the last. version
+	// changes whenever a symbol is merged, outdating its queued pairs.
+	next := make([]int, len(symbols))
+	prev := make([]int, len(symbols))
+	version := make([]int, len(symbols))
+	for i := range symbols {
+		prev[i], next[i] = i-1, i+1
+	}
+	next[len(symbols)-1] = -1
+
+	pairs := make(bpePairHeap, 0, len(symbols)-1)
+	candidate := func(left int) (bpePair, bool) {
+		right := next[left]
+		if right < 0 {
+			return bpePair{}, false
+		}
+		p, ok := priority(symbols[left], symbols[right])
+		return bpePair{priority: p, left: left, right: right, leftVersion: version[left], rightVersion: version[right]}, ok
+	}
+	for i := 0; i < len(symbols)-1; i++ {
+		if pair, ok := candidate(i); ok {
+			pairs = append(pairs, pair)
+		}
+	}
+	heap.Init(&pairs)
+
+	for pairs.Len() > 0 {
+		pair := heap.Pop(&pairs).(bpePair)
+		left, right := pair.left, pair.right
+		if next[left] != right || version[left] != pair.leftVersion || version[right] != pair.rightVersion {
+			continue
+		}
+
+		symbols[left] += symbols[right]
+		version[left]++
+		version[right]++
+		next[left] = next[right]
+		if next[left] >= 0 {
+			prev[next[left]] = left
+		}
+
+		if prev[left] >= 0 {
+			if pair, ok := candidate(prev[left]); ok {
+				heap.Push(&pairs, pair)
+			}
+		}
+		if pair, ok := candidate(left); ok {
+			heap.Push(&pairs, pair)
+		}
+	}
+
+	// The first symbol is never merged away
+	merged := make([]string, 0, len(symbols))
+	for i := 0; i >= 0; i = next[i] {
+		merged = append(merged, symbols[i])
+	}
+	return merged
+}
+
+// bpePair is a pair of adjacent symbols that can merge, with the versions of
+// the symbols when it was queued
+type bpePair struct {
+	priority                  float64
+	left, right               int
+	leftVersion, rightVersion int
+}
+
+// bpePairHeap orders pairs by priority, then position
+type bpePairHeap []bpePair
+
+func (h bpePairHeap) Len() int { return len(h) }
+
+func (h bpePairHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority < h[j].priority
+	}
+	return h[i].left < h[j].left
+}
+
+func (h bpePairHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *bpePairHeap) Push(x any) { *h = append(*h, x.(bpePair)) }
+
+func (h *bpePairHeap) Pop() any {
+	old := *h
+	pair := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return pair
+}
+
+// runeStrings splits s into one string per rune
+func runeStrings(s string) []string {
+	symbols := make([]string, 0, len(s))
+	for _, r := range s {
+		symbols = append(symbols, string(r))
+	}
+	return symbols
+}
+
+// byteLevelChars maps every byte to the printable character byte-level BPE
+// vocabularies (GPT-2, Llama 3, DeepSeek) use for it
+var byteLevelChars = func() [256]rune {
+	var chars [256]rune
+	next := rune(256)
+	for b := 0; b < 256; b++ {
+		if (b >= '!' && b <= '~') || (b >= 0xA1 && b <= 0xAC) || (b >= 0xAE && b <= 0xFF) {
+			chars[b] = rune(b)
+		} else {
+			chars[b] = next
+			next++
+		}
+	}
+	return chars
+}()
+
+// byteLevelSymbols returns the byte-level characters of the UTF-8 bytes of s
+func byteLevelSymbols(s string) []string {
+	symbols := make([]string, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		symbols = append(symbols, string(byteLevelChars[s[i]]))
+	}
+	return symbols
+}
+
+// regexSegment is a piece of text split by a regular expression
+type regexSegment struct {
+	text    string
+	isMatch bool
+}
+
+// splitRegex splits s into the matches of re and the text between them
+func splitRegex(re *regexp2.Regexp, s string) []regexSegment {
+	runes := []rune(s)
+	var segments []regexSegment
+	last := 0
+	match, _ := re.FindRunesMatch(runes)
+	for match != nil {
+		if match.Length == 0 {
+			// Skip empty matches so the scan always advances
+			match, _ = re.FindNextMatch(match)
+			continue
+		}
+		if match.Index > last {
+			segments = append(segments, regexSegment{text: string(runes[last:match.Index])})
+		}
+		segments = append(segments, regexSegment{text: string(runes[match.Index : match.Index+match.Length]), isMatch: true})
+		last = match.Index + match.Length
+		match, _ = re.FindNextMatch(match)
+	}
+	if last < len(runes) {
+		segments = append(segments, regexSegment{text: string(runes[last:])})
+	}
+	return segments
+}
@@ -0,0 +1,116 @@
+package paygent
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/dlclark/regexp2"
+)
+
+func TestBPEMerge(t *testing.T) {
+	ranks := map[string]float64{"a b": 1, "b c": 0, "a bc": 2}
+	priority := func(left, right string) (float64, bool) {
+		rank, ok := ranks[left+" "+right]
+		return rank, ok
+	}
+
+	if got := bpeMerge(runeStrings("abc"), priority); strings.Join(got, "|") != "abc" {
+		t.Errorf("Expected abc, got %q", got)
+	}
+	if got := bpeMerge(runeStrings("abd"), priority); strings.Join(got, "|") != "ab|d" {
+		t.Errorf("Expected ab|d, got %q", got)
+	}
+}
+
+// naiveBPEMerge rescans every pair after each merge, as bpeMerge once did
+func naiveBPEMerge(symbols []string, priority func(left, right string) (float64, bool)) []string {
+	for len(symbols) > 1 {
+		best, bestIndex := 0.0, -1
+		for i := 0; i < len(symbols)-1; i++ {
+			if p, ok := priority(symbols[i], symbols[i+1]); ok && (bestIndex < 0 || p < best) {
+				best, bestIndex = p, i
+			}
+		}
+		if bestIndex < 0 {
+			break
+		}
+		symbols[bestIndex] += symbols[bestIndex+1]
+		symbols = append(symbols[:bestIndex+1], symbols[bestIndex+2:]...)
+	}
+	return symbols
+}
+
+// randomMergeRanks ranks random merges of symbols over a small alphabet, with
+// many ties so that the leftmost-first rule is exercised
+func randomMergeRanks(rng *rand.Rand, alphabet string) func(left, right string) (float64, bool) {
+	ranks := make(map[string]float64)
+	pieces := runeStrings(alphabet)
+	for i := 0; i < 200; i++ {
+		left, right := pieces[rng.Intn(len(pieces))], pieces[rng.Intn(len(pieces))]
+		if _, ok := ranks[left+" "+right]; !ok {
+			ranks[left+" "+right] = float64(rng.Intn(50))
+			pieces = append(pieces, left+right)
+		}
+	}
+	return func(left, right string) (float64, bool) {
+		rank, ok := ranks[left+" "+right]
+		return rank, ok
+	}
+}
+
+func TestBPEMergeMatchesNaive(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		priority := randomMergeRanks(rng, "abcd")
+		word := make([]byte, rng.Intn(40))
+		for j := range word {
+			word[j] = "abcd"[rng.Intn(4)]
+		}
+
+		want := naiveBPEMerge(runeStrings(string(word)), priority)
+		if got := bpeMerge(runeStrings(string(word)), priority); strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Fatalf("bpeMerge(%q) = %q, want %q", word, got, want)
+		}
+	}
+}
+
+// BenchmarkBPEMergeLongWord merges 100 KB of text without pre-tokenization,
+// as a tokenizer.json with only normalizers does
+func BenchmarkBPEMergeLongWord(b *testing.B) {
+	priority := randomMergeRanks(rand.New(rand.NewSource(1)), "abcdefgh")
+	word := strings.Repeat("abcdefghhgfedcba", 100<<10/16)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bpeMerge(runeStrings(word), priority)
+	}
+}
+
+func TestByteLevelSymbols(t *testing.T) {
+	if got := strings.Join(byteLevelSymbols(" hi\n"), ""); got != "ĠhiĊ" {
+		t.Errorf("Expected ĠhiĊ, got %q", got)
+	}
+
+	seen := make(map[rune]bool)
+	for _, r := range byteLevelChars {
+		if seen[r] {
+			t.Fatalf("Character %q is mapped twice", r)
+		}
+		seen[r] = true
+	}
+}
+
+func TestSplitRegex(t *testing.T) {
+	re := regexp2.MustCompile(`\d+`, regexp2.None)
+	got := splitRegex(re, "ab12cd3")
+	expected := []regexSegment{{text: "ab"}, {text: "12", isMatch: true}, {text: "cd"}, {text: "3", isMatch: true}}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d segments, got %+v", len(expected), got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Segment %d: expected %+v, got %+v", i, expected[i], got[i])
+		}
+	}
+}
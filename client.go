@@ -2,14 +2,17 @@ package paygent
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/pkoukk/tiktoken-go"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
@@ -19,65 +22,195 @@ type Client struct {
 	baseURL    string
 	httpClient *http.Client
 	logger     *logrus.Logger
+
+	maxBatchEvents int
+	maxBatchBytes  int
+	retryPolicy    RetryPolicy
+	spool          *Spool
+	pricing        PricingRegistry
+	volume         *tokenVolume
+	roundingPlaces int
+	roundingMode   RoundingMode
+
+	fxRates            FXRateProvider
+	billingMu          sync.RWMutex
+	billingCurrency    string
+	customerCurrencies map[string]string
+	rules              *billingRules
+
+	unknownModelPolicy UnknownModelPolicy
+	fallbackPricing    ModelPricing
+	aliases            *modelAliases
+	tokenizers         *tokenizerRegistry
 }
 
 // UsageData represents the usage data structure
 type UsageData struct {
+	// EventID uniquely identifies the usage event. A UUID is generated when empty.
+	EventID          string `json:"event_id,omitempty"`
 	ServiceProvider  string `json:"service_provider"`
 	Model            string `json:"model"`
 	PromptTokens     int    `json:"prompt_tokens"`
 	CompletionTokens int    `json:"completion_tokens"`
 	TotalTokens      int    `json:"total_tokens"`
+	// CachedPromptTokens is the part of PromptTokens read from the provider's prompt cache
+	CachedPromptTokens int `json:"cached_prompt_tokens,omitempty"`
+	// CacheWriteTokens is the part of PromptTokens written to the provider's prompt cache
+	CacheWriteTokens int `json:"cache_write_tokens,omitempty"`
+	// ReasoningTokens is the part of CompletionTokens spent on hidden reasoning
+	ReasoningTokens int `json:"reasoning_tokens,omitempty"`
+	// AudioPromptTokens, ImagePromptTokens and VideoPromptTokens are the parts
+	// of PromptTokens spent on each media modality; the rest is text
+	AudioPromptTokens int `json:"audio_prompt_tokens,omitempty"`
+	ImagePromptTokens int `json:"image_prompt_tokens,omitempty"`
+	VideoPromptTokens int `json:"video_prompt_tokens,omitempty"`
+	// AudioCompletionTokens, ImageCompletionTokens and VideoCompletionTokens are
+	// the parts of CompletionTokens spent on each media modality; the rest is text
+	AudioCompletionTokens int `json:"audio_completion_tokens,omitempty"`
+	ImageCompletionTokens int `json:"image_completion_tokens,omitempty"`
+	VideoCompletionTokens int `json:"video_completion_tokens,omitempty"`
+	// OccurredAt is when the usage happened. It selects the price in force at
+	// that time and defaults to now.
+	OccurredAt time.Time `json:"occurred_at"`
 }
 
 // UsageDataWithStrings represents the usage data structure with prompt and output strings
 type UsageDataWithStrings struct {
+	// EventID uniquely identifies the usage event. A UUID is generated when empty.
+	EventID         string `json:"event_id,omitempty"`
 	ServiceProvider string `json:"service_provider"`
 	Model           string `json:"model"`
 	PromptString    string `json:"prompt_string"`
 	OutputString    string `json:"output_string"`
+	// Tools and ToolChoice are the tool definitions and tool choice sent with
+	// the prompt, and OutputToolCalls the tool calls of the output
+	Tools           []ToolDefinition `json:"tools,omitempty"`
+	ToolChoice      string           `json:"tool_choice,omitempty"`
+	OutputToolCalls []ToolCall       `json:"output_tool_calls,omitempty"`
+	// Images are the images sent with the prompt
+	Images []ImageInput `json:"images,omitempty"`
+	// OccurredAt is when the usage happened. It selects the price in force at
+	// that time and defaults to now.
+	OccurredAt time.Time `json:"occurred_at"`
 }
 
 // APIRequest represents the request body for the API call
 type APIRequest struct {
-	AgentID         string  `json:"agentId"`
-	CustomerID      string  `json:"customerId"`
-	Indicator       string  `json:"indicator"`
-	Amount          float64 `json:"amount"`
-	InputToken      int     `json:"inputToken"`
-	OutputToken     int     `json:"outputToken"`
-	Model           string  `json:"model"`
-	ServiceProvider string  `json:"serviceProvider"`
+	EventID    string `json:"eventId"`
+	AgentID    string `json:"agentId"`
+	CustomerID string `json:"customerId"`
+	Indicator  string `json:"indicator"`
+	Amount     Money  `json:"amount"`
+	// Currency is the ISO 4217 currency of Amount
+	Currency        string `json:"currency,omitempty"`
+	InputToken      int    `json:"inputToken"`
+	OutputToken     int    `json:"outputToken"`
+	Model           string `json:"model"`
+	ServiceProvider string `json:"serviceProvider"`
+	// OccurredAt is when the usage happened
+	OccurredAt time.Time `json:"occurredAt"`
+	// PricingVersion identifies the price version Amount was calculated with
+	PricingVersion string `json:"pricingVersion,omitempty"`
+	// PricingTier names the pricing tier Amount was calculated with, if any
+	PricingTier string `json:"pricingTier,omitempty"`
+	// CachedInputToken is the part of InputToken read from the prompt cache
+	CachedInputToken int `json:"cachedInputToken,omitempty"`
+	// CacheWriteToken is the part of InputToken written to the prompt cache
+	CacheWriteToken int `json:"cacheWriteToken,omitempty"`
+	// ReasoningToken is the part of OutputToken spent on hidden reasoning
+	ReasoningToken int `json:"reasoningToken,omitempty"`
+	// ReasoningAmount is the part of Amount billed for reasoning tokens. Under a
+	// billing rule it is their share of the cost, applied to Amount.
+	ReasoningAmount *Money `json:"reasoningAmount,omitempty"`
+	// The media parts of InputToken and OutputToken; the rest is text
+	AudioInputToken  int `json:"audioInputToken,omitempty"`
+	ImageInputToken  int `json:"imageInputToken,omitempty"`
+	VideoInputToken  int `json:"videoInputToken,omitempty"`
+	AudioOutputToken int `json:"audioOutputToken,omitempty"`
+	ImageOutputToken int `json:"imageOutputToken,omitempty"`
+	VideoOutputToken int `json:"videoOutputToken,omitempty"`
+	// ProviderAmount and ProviderCurrency are the cost in the currency the model
+	// is priced in, set when Amount was converted with FXRate
+	ProviderAmount   *Money  `json:"providerAmount,omitempty"`
+	ProviderCurrency string  `json:"providerCurrency,omitempty"`
+	FXRate           *FXRate `json:"fxRate,omitempty"`
+	// Cost is the cost of the usage in the billing currency and BillingRule the
+	// rule that priced Amount from it, set when a billing rule applied
+	Cost        *Money `json:"cost,omitempty"`
+	BillingRule string `json:"billingRule,omitempty"`
+	// FreeAllowance is the part of the price the billing rule's free allowance covered
+	FreeAllowance *Money `json:"freeAllowance,omitempty"`
+	// PricedAsModel is the known model an unknown model was priced as
+	PricedAsModel string `json:"pricedAsModel,omitempty"`
+	// ServerPricing asks the server to price usage for a model the SDK has no price for
+	ServerPricing bool `json:"serverPricing,omitempty"`
 }
 
 // ModelPricing represents pricing information for different models
 type ModelPricing struct {
 	PromptTokensCost     float64
 	CompletionTokensCost float64
+	// CachedPromptTokensCost is the price of prompt tokens read from cache
+	// (zero bills them at PromptTokensCost)
+	CachedPromptTokensCost float64
+	// CacheWriteTokensCost is the price of prompt tokens written to cache
+	// (zero bills them at PromptTokensCost)
+	CacheWriteTokensCost float64
+	// ReasoningTokensCost is the price of reasoning tokens
+	// (zero bills them at CompletionTokensCost)
+	ReasoningTokensCost float64
+	// Media prompt token prices (zero bills them at PromptTokensCost)
+	AudioPromptTokensCost float64
+	ImagePromptTokensCost float64
+	VideoPromptTokensCost float64
+	// Media completion token prices (zero bills them at CompletionTokensCost)
+	AudioCompletionTokensCost float64
+	ImageCompletionTokensCost float64
+	VideoCompletionTokensCost float64
+	// Tiers replace the rates above once the TierBasis measure reaches a threshold
+	Tiers     []PricingTier
+	TierBasis TierBasis
+	// Provider is the service provider of the model, when known
+	Provider string
+	// Currency is the ISO 4217 code the costs are expressed in (empty means USD)
+	Currency string
+	// EffectiveDate is when the price took effect (zero for built-in prices)
+	EffectiveDate time.Time
+	// EffectiveUntil is when the price stopped applying (zero while it is current)
+	EffectiveUntil time.Time
+	// VersionID identifies this price version in usage records. When empty
+	// an ID is derived, see PricingVersionID.
+	VersionID string
 }
 
-// Default model pricing (cost per 1000 tokens in USD)
-var modelPricing = map[string]ModelPricing{
+// Default model pricing (cost per 1000 tokens in USD).
+// It seeds every PricingRegistry created with NewPricingRegistry.
+var defaultModelPricing = map[string]ModelPricing{
 	// OpenAI Models (pricing per 1000 tokens)
 	GPT5: {
-		PromptTokensCost:     0.00125, // $0.00125 per 1000 tokens
-		CompletionTokensCost: 0.01,    // $0.01 per 1000 tokens
+		PromptTokensCost:       0.00125,  // $0.00125 per 1000 tokens
+		CompletionTokensCost:   0.01,     // $0.01 per 1000 tokens
+		CachedPromptTokensCost: 0.000125, // $0.000125 per 1000 tokens
 	},
 	GPT5Mini: {
-		PromptTokensCost:     0.00025, // $0.00025 per 1000 tokens
-		CompletionTokensCost: 0.002,   // $0.002 per 1000 tokens
+		PromptTokensCost:       0.00025,  // $0.00025 per 1000 tokens
+		CompletionTokensCost:   0.002,    // $0.002 per 1000 tokens
+		CachedPromptTokensCost: 0.000025, // $0.000025 per 1000 tokens
 	},
 	GPT5Nano: {
-		PromptTokensCost:     0.00005, // $0.00005 per 1000 tokens
-		CompletionTokensCost: 0.0004,  // $0.0004 per 1000 tokens
+		PromptTokensCost:       0.00005,  // $0.00005 per 1000 tokens
+		CompletionTokensCost:   0.0004,   // $0.0004 per 1000 tokens
+		CachedPromptTokensCost: 0.000005, // $0.000005 per 1000 tokens
 	},
 	GPT5ChatLatest: {
-		PromptTokensCost:     0.00125, // $0.00125 per 1000 tokens
-		CompletionTokensCost: 0.01,    // $0.01 per 1000 tokens
+		PromptTokensCost:       0.00125,  // $0.00125 per 1000 tokens
+		CompletionTokensCost:   0.01,     // $0.01 per 1000 tokens
+		CachedPromptTokensCost: 0.000125, // $0.000125 per 1000 tokens
 	},
 	GPT5Codex: {
-		PromptTokensCost:     0.00125, // $0.00125 per 1000 tokens
-		CompletionTokensCost: 0.01,    // $0.01 per 1000 tokens
+		PromptTokensCost:       0.00125,  // $0.00125 per 1000 tokens
+		CompletionTokensCost:   0.01,     // $0.01 per 1000 tokens
+		CachedPromptTokensCost: 0.000125, // $0.000125 per 1000 tokens
 	},
 	GPT5Pro: {
 		PromptTokensCost:     0.015, // $0.015 per 1000 tokens
@@ -88,64 +221,89 @@ var modelPricing = map[string]ModelPricing{
 		CompletionTokensCost: 0.01,    // $0.01 per 1000 tokens
 	},
 	GPT41: {
-		PromptTokensCost:     0.002, // $0.002 per 1000 tokens
-		CompletionTokensCost: 0.008, // $0.008 per 1000 tokens
+		PromptTokensCost:       0.002,  // $0.002 per 1000 tokens
+		CompletionTokensCost:   0.008,  // $0.008 per 1000 tokens
+		CachedPromptTokensCost: 0.0005, // $0.0005 per 1000 tokens
 	},
 	GPT41Mini: {
-		PromptTokensCost:     0.0004, // $0.0004 per 1000 tokens
-		CompletionTokensCost: 0.0016, // $0.0016 per 1000 tokens
+		PromptTokensCost:       0.0004, // $0.0004 per 1000 tokens
+		CompletionTokensCost:   0.0016, // $0.0016 per 1000 tokens
+		CachedPromptTokensCost: 0.0001, // $0.0001 per 1000 tokens
 	},
 	GPT41Nano: {
-		PromptTokensCost:     0.0001, // $0.0001 per 1000 tokens
-		CompletionTokensCost: 0.0004, // $0.0004 per 1000 tokens
+		PromptTokensCost:       0.0001,   // $0.0001 per 1000 tokens
+		CompletionTokensCost:   0.0004,   // $0.0004 per 1000 tokens
+		CachedPromptTokensCost: 0.000025, // $0.000025 per 1000 tokens
 	},
 	GPT4O: {
-		PromptTokensCost:     0.0025, // $0.0025 per 1000 tokens
-		CompletionTokensCost: 0.01,   // $0.01 per 1000 tokens
+		PromptTokensCost:       0.0025,  // $0.0025 per 1000 tokens
+		CompletionTokensCost:   0.01,    // $0.01 per 1000 tokens
+		CachedPromptTokensCost: 0.00125, // $0.00125 per 1000 tokens
 	},
 	GPT4O20240513: {
 		PromptTokensCost:     0.005, // $0.005 per 1000 tokens
 		CompletionTokensCost: 0.015, // $0.015 per 1000 tokens
 	},
 	GPT4OMini: {
-		PromptTokensCost:     0.00015, // $0.00015 per 1000 tokens
-		CompletionTokensCost: 0.0006,  // $0.0006 per 1000 tokens
+		PromptTokensCost:       0.00015,  // $0.00015 per 1000 tokens
+		CompletionTokensCost:   0.0006,   // $0.0006 per 1000 tokens
+		CachedPromptTokensCost: 0.000075, // $0.000075 per 1000 tokens
 	},
 	GPTRealtime: {
-		PromptTokensCost:     0.004, // $0.004 per 1000 tokens
-		CompletionTokensCost: 0.016, // $0.016 per 1000 tokens
+		PromptTokensCost:          0.004,  // $0.004 per 1000 tokens
+		CompletionTokensCost:      0.016,  // $0.016 per 1000 tokens
+		CachedPromptTokensCost:    0.0004, // $0.0004 per 1000 tokens
+		AudioPromptTokensCost:     0.032,  // $0.032 per 1000 tokens
+		AudioCompletionTokensCost: 0.064,  // $0.064 per 1000 tokens
+		ImagePromptTokensCost:     0.005,  // $0.005 per 1000 tokens
 	},
 	GPTRealtimeMini: {
-		PromptTokensCost:     0.0006, // $0.0006 per 1000 tokens
-		CompletionTokensCost: 0.0024, // $0.0024 per 1000 tokens
+		PromptTokensCost:          0.0006,  // $0.0006 per 1000 tokens
+		CompletionTokensCost:      0.0024,  // $0.0024 per 1000 tokens
+		CachedPromptTokensCost:    0.00006, // $0.00006 per 1000 tokens
+		AudioPromptTokensCost:     0.01,    // $0.01 per 1000 tokens
+		AudioCompletionTokensCost: 0.02,    // $0.02 per 1000 tokens
 	},
 	GPT4ORealtimePreview: {
-		PromptTokensCost:     0.005, // $0.005 per 1000 tokens
-		CompletionTokensCost: 0.02,  // $0.02 per 1000 tokens
+		PromptTokensCost:          0.005, // $0.005 per 1000 tokens
+		CompletionTokensCost:      0.02,  // $0.02 per 1000 tokens
+		AudioPromptTokensCost:     0.04,  // $0.04 per 1000 tokens
+		AudioCompletionTokensCost: 0.08,  // $0.08 per 1000 tokens
 	},
 	GPT4OMiniRealtimePreview: {
-		PromptTokensCost:     0.0006, // $0.0006 per 1000 tokens
-		CompletionTokensCost: 0.0024, // $0.0024 per 1000 tokens
+		PromptTokensCost:          0.0006, // $0.0006 per 1000 tokens
+		CompletionTokensCost:      0.0024, // $0.0024 per 1000 tokens
+		AudioPromptTokensCost:     0.01,   // $0.01 per 1000 tokens
+		AudioCompletionTokensCost: 0.02,   // $0.02 per 1000 tokens
 	},
 	GPTAudio: {
-		PromptTokensCost:     0.0025, // $0.0025 per 1000 tokens
-		CompletionTokensCost: 0.01,   // $0.01 per 1000 tokens
+		PromptTokensCost:          0.0025, // $0.0025 per 1000 tokens
+		CompletionTokensCost:      0.01,   // $0.01 per 1000 tokens
+		AudioPromptTokensCost:     0.032,  // $0.032 per 1000 tokens
+		AudioCompletionTokensCost: 0.064,  // $0.064 per 1000 tokens
 	},
 	GPTAudioMini: {
-		PromptTokensCost:     0.0006, // $0.0006 per 1000 tokens
-		CompletionTokensCost: 0.0024, // $0.0024 per 1000 tokens
+		PromptTokensCost:          0.0006, // $0.0006 per 1000 tokens
+		CompletionTokensCost:      0.0024, // $0.0024 per 1000 tokens
+		AudioPromptTokensCost:     0.01,   // $0.01 per 1000 tokens
+		AudioCompletionTokensCost: 0.02,   // $0.02 per 1000 tokens
 	},
 	GPT4OAudioPreview: {
-		PromptTokensCost:     0.0025, // $0.0025 per 1000 tokens
-		CompletionTokensCost: 0.01,   // $0.01 per 1000 tokens
+		PromptTokensCost:          0.0025, // $0.0025 per 1000 tokens
+		CompletionTokensCost:      0.01,   // $0.01 per 1000 tokens
+		AudioPromptTokensCost:     0.04,   // $0.04 per 1000 tokens
+		AudioCompletionTokensCost: 0.08,   // $0.08 per 1000 tokens
 	},
 	GPT4OMiniAudioPreview: {
-		PromptTokensCost:     0.00015, // $0.00015 per 1000 tokens
-		CompletionTokensCost: 0.0006,  // $0.0006 per 1000 tokens
+		PromptTokensCost:          0.00015, // $0.00015 per 1000 tokens
+		CompletionTokensCost:      0.0006,  // $0.0006 per 1000 tokens
+		AudioPromptTokensCost:     0.01,    // $0.01 per 1000 tokens
+		AudioCompletionTokensCost: 0.02,    // $0.02 per 1000 tokens
 	},
 	O1: {
-		PromptTokensCost:     0.015, // $0.015 per 1000 tokens
-		CompletionTokensCost: 0.06,  // $0.06 per 1000 tokens
+		PromptTokensCost:       0.015,  // $0.015 per 1000 tokens
+		CompletionTokensCost:   0.06,   // $0.06 per 1000 tokens
+		CachedPromptTokensCost: 0.0075, // $0.0075 per 1000 tokens
 	},
 	O1Pro: {
 		PromptTokensCost:     0.15, // $0.15 per 1000 tokens
@@ -156,32 +314,37 @@ var modelPricing = map[string]ModelPricing{
 		CompletionTokensCost: 0.08, // $0.08 per 1000 tokens
 	},
 	O3: {
-		PromptTokensCost:     0.002, // $0.002 per 1000 tokens
-		CompletionTokensCost: 0.008, // $0.008 per 1000 tokens
+		PromptTokensCost:       0.002,  // $0.002 per 1000 tokens
+		CompletionTokensCost:   0.008,  // $0.008 per 1000 tokens
+		CachedPromptTokensCost: 0.0005, // $0.0005 per 1000 tokens
 	},
 	O3DeepResearch: {
 		PromptTokensCost:     0.01, // $0.01 per 1000 tokens
 		CompletionTokensCost: 0.04, // $0.04 per 1000 tokens
 	},
 	O4Mini: {
-		PromptTokensCost:     0.0011, // $0.0011 per 1000 tokens
-		CompletionTokensCost: 0.0044, // $0.0044 per 1000 tokens
+		PromptTokensCost:       0.0011,   // $0.0011 per 1000 tokens
+		CompletionTokensCost:   0.0044,   // $0.0044 per 1000 tokens
+		CachedPromptTokensCost: 0.000275, // $0.000275 per 1000 tokens
 	},
 	O4MiniDeepResearch: {
 		PromptTokensCost:     0.002, // $0.002 per 1000 tokens
 		CompletionTokensCost: 0.008, // $0.008 per 1000 tokens
 	},
 	O3Mini: {
-		PromptTokensCost:     0.0011, // $0.0011 per 1000 tokens
-		CompletionTokensCost: 0.0044, // $0.0044 per 1000 tokens
+		PromptTokensCost:       0.0011,  // $0.0011 per 1000 tokens
+		CompletionTokensCost:   0.0044,  // $0.0044 per 1000 tokens
+		CachedPromptTokensCost: 0.00055, // $0.00055 per 1000 tokens
 	},
 	O1Mini: {
-		PromptTokensCost:     0.0011, // $0.0011 per 1000 tokens
-		CompletionTokensCost: 0.0044, // $0.0044 per 1000 tokens
+		PromptTokensCost:       0.0011,  // $0.0011 per 1000 tokens
+		CompletionTokensCost:   0.0044,  // $0.0044 per 1000 tokens
+		CachedPromptTokensCost: 0.00055, // $0.00055 per 1000 tokens
 	},
 	CodexMiniLatest: {
-		PromptTokensCost:     0.0015, // $0.0015 per 1000 tokens
-		CompletionTokensCost: 0.006,  // $0.006 per 1000 tokens
+		PromptTokensCost:       0.0015,   // $0.0015 per 1000 tokens
+		CompletionTokensCost:   0.006,    // $0.006 per 1000 tokens
+		CachedPromptTokensCost: 0.000375, // $0.000375 per 1000 tokens
 	},
 	GPT4OMiniSearchPreview: {
 		PromptTokensCost:     0.00015, // $0.00015 per 1000 tokens
@@ -266,70 +429,118 @@ var modelPricing = map[string]ModelPricing{
 
 	// Anthropic Models (pricing per 1000 tokens)
 	Sonnet45: {
-		PromptTokensCost:     0.003, // $0.003 per 1000 tokens
-		CompletionTokensCost: 0.015, // $0.015 per 1000 tokens
+		PromptTokensCost:       0.003,   // $0.003 per 1000 tokens
+		CompletionTokensCost:   0.015,   // $0.015 per 1000 tokens
+		CachedPromptTokensCost: 0.0003,  // $0.0003 per 1000 tokens
+		CacheWriteTokensCost:   0.00375, // $0.00375 per 1000 tokens
+		Tiers: []PricingTier{{
+			Name:                   "long-context",
+			MinTokens:              200001, // the long-context rate starts above 200k prompt tokens
+			PromptTokensCost:       0.006,  // $0.006 per 1000 tokens above 200k prompt tokens
+			CompletionTokensCost:   0.0225, // $0.0225 per 1000 tokens above 200k prompt tokens
+			CachedPromptTokensCost: 0.0006, // $0.0006 per 1000 tokens above 200k prompt tokens
+			CacheWriteTokensCost:   0.0075, // $0.0075 per 1000 tokens above 200k prompt tokens
+		}},
 	},
 	Haiku45: {
-		PromptTokensCost:     0.001, // $0.001 per 1000 tokens
-		CompletionTokensCost: 0.005, // $0.005 per 1000 tokens
+		PromptTokensCost:       0.001,   // $0.001 per 1000 tokens
+		CompletionTokensCost:   0.005,   // $0.005 per 1000 tokens
+		CachedPromptTokensCost: 0.0001,  // $0.0001 per 1000 tokens
+		CacheWriteTokensCost:   0.00125, // $0.00125 per 1000 tokens
 	},
 	Opus41: {
-		PromptTokensCost:     0.015, // $0.015 per 1000 tokens
-		CompletionTokensCost: 0.075, // $0.075 per 1000 tokens
+		PromptTokensCost:       0.015,   // $0.015 per 1000 tokens
+		CompletionTokensCost:   0.075,   // $0.075 per 1000 tokens
+		CachedPromptTokensCost: 0.0015,  // $0.0015 per 1000 tokens
+		CacheWriteTokensCost:   0.01875, // $0.01875 per 1000 tokens
 	},
 	Sonnet4: {
-		PromptTokensCost:     0.003, // $0.003 per 1000 tokens
-		CompletionTokensCost: 0.015, // $0.015 per 1000 tokens
+		PromptTokensCost:       0.003,   // $0.003 per 1000 tokens
+		CompletionTokensCost:   0.015,   // $0.015 per 1000 tokens
+		CachedPromptTokensCost: 0.0003,  // $0.0003 per 1000 tokens
+		CacheWriteTokensCost:   0.00375, // $0.00375 per 1000 tokens
+		Tiers: []PricingTier{{
+			Name:                   "long-context",
+			MinTokens:              200001, // the long-context rate starts above 200k prompt tokens
+			PromptTokensCost:       0.006,  // $0.006 per 1000 tokens above 200k prompt tokens
+			CompletionTokensCost:   0.0225, // $0.0225 per 1000 tokens above 200k prompt tokens
+			CachedPromptTokensCost: 0.0006, // $0.0006 per 1000 tokens above 200k prompt tokens
+			CacheWriteTokensCost:   0.0075, // $0.0075 per 1000 tokens above 200k prompt tokens
+		}},
 	},
 	Opus4: {
-		PromptTokensCost:     0.015, // $0.015 per 1000 tokens
-		CompletionTokensCost: 0.075, // $0.075 per 1000 tokens
+		PromptTokensCost:       0.015,   // $0.015 per 1000 tokens
+		CompletionTokensCost:   0.075,   // $0.075 per 1000 tokens
+		CachedPromptTokensCost: 0.0015,  // $0.0015 per 1000 tokens
+		CacheWriteTokensCost:   0.01875, // $0.01875 per 1000 tokens
 	},
 	Sonnet37: {
-		PromptTokensCost:     0.003, // $0.003 per 1000 tokens
-		CompletionTokensCost: 0.015, // $0.015 per 1000 tokens
+		PromptTokensCost:       0.003,   // $0.003 per 1000 tokens
+		CompletionTokensCost:   0.015,   // $0.015 per 1000 tokens
+		CachedPromptTokensCost: 0.0003,  // $0.0003 per 1000 tokens
+		CacheWriteTokensCost:   0.00375, // $0.00375 per 1000 tokens
 	},
 	Haiku35: {
-		PromptTokensCost:     0.0008, // $0.0008 per 1000 tokens
-		CompletionTokensCost: 0.004,  // $0.004 per 1000 tokens
+		PromptTokensCost:       0.0008,  // $0.0008 per 1000 tokens
+		CompletionTokensCost:   0.004,   // $0.004 per 1000 tokens
+		CachedPromptTokensCost: 0.00008, // $0.00008 per 1000 tokens
+		CacheWriteTokensCost:   0.001,   // $0.001 per 1000 tokens
 	},
 	Opus3: {
-		PromptTokensCost:     0.015, // $0.015 per 1000 tokens
-		CompletionTokensCost: 0.075, // $0.075 per 1000 tokens
+		PromptTokensCost:       0.015,   // $0.015 per 1000 tokens
+		CompletionTokensCost:   0.075,   // $0.075 per 1000 tokens
+		CachedPromptTokensCost: 0.0015,  // $0.0015 per 1000 tokens
+		CacheWriteTokensCost:   0.01875, // $0.01875 per 1000 tokens
 	},
 	Haiku3: {
-		PromptTokensCost:     0.00025, // $0.00025 per 1000 tokens
-		CompletionTokensCost: 0.00125, // $0.00125 per 1000 tokens
+		PromptTokensCost:       0.00025, // $0.00025 per 1000 tokens
+		CompletionTokensCost:   0.00125, // $0.00125 per 1000 tokens
+		CachedPromptTokensCost: 0.00003, // $0.00003 per 1000 tokens
+		CacheWriteTokensCost:   0.0003,  // $0.0003 per 1000 tokens
 	},
 
 	// Google DeepMind Models (pricing per 1000 tokens)
 	Gemini25Pro: {
-		PromptTokensCost:     0.00125, // $0.00125 per 1000 tokens
-		CompletionTokensCost: 0.01,    // $0.01 per 1000 tokens
+		PromptTokensCost:       0.00125,  // $0.00125 per 1000 tokens
+		CompletionTokensCost:   0.01,     // $0.01 per 1000 tokens
+		CachedPromptTokensCost: 0.000125, // $0.000125 per 1000 tokens
+		Tiers: []PricingTier{{
+			Name:                   "long-context",
+			MinTokens:              200001,  // the long-context rate starts above 200k prompt tokens
+			PromptTokensCost:       0.0025,  // $0.0025 per 1000 tokens above 200k prompt tokens
+			CompletionTokensCost:   0.015,   // $0.015 per 1000 tokens above 200k prompt tokens
+			CachedPromptTokensCost: 0.00025, // $0.00025 per 1000 tokens above 200k prompt tokens
+		}},
 	},
 	Gemini25Flash: {
-		PromptTokensCost:     0.00015, // $0.00015 per 1000 tokens
-		CompletionTokensCost: 0.0006,  // $0.0006 per 1000 tokens
+		PromptTokensCost:       0.00015,   // $0.00015 per 1000 tokens
+		CompletionTokensCost:   0.0006,    // $0.0006 per 1000 tokens
+		CachedPromptTokensCost: 0.0000375, // $0.0000375 per 1000 tokens
+		AudioPromptTokensCost:  0.001,     // $0.001 per 1000 tokens
 	},
 	Gemini25FlashPreview: {
 		PromptTokensCost:     0.3, // $0.30 per 1000 tokens
 		CompletionTokensCost: 2.5, // $2.50 per 1000 tokens
 	},
 	Gemini25FlashLite: {
-		PromptTokensCost:     0.0001, // $0.0001 per 1000 tokens
-		CompletionTokensCost: 0.0004, // $0.0004 per 1000 tokens
+		PromptTokensCost:       0.0001,   // $0.0001 per 1000 tokens
+		CompletionTokensCost:   0.0004,   // $0.0004 per 1000 tokens
+		CachedPromptTokensCost: 0.000025, // $0.000025 per 1000 tokens
 	},
 	Gemini25FlashLitePreview: {
 		PromptTokensCost:     0.0001, // $0.0001 per 1000 tokens
 		CompletionTokensCost: 0.0004, // $0.0004 per 1000 tokens
 	},
 	Gemini25FlashNativeAudio: {
-		PromptTokensCost:     0.0005, // $0.0005 per 1000 tokens
-		CompletionTokensCost: 0.002,  // $0.002 per 1000 tokens
+		PromptTokensCost:          0.0005, // $0.0005 per 1000 tokens
+		CompletionTokensCost:      0.002,  // $0.002 per 1000 tokens
+		AudioPromptTokensCost:     0.003,  // $0.003 per 1000 tokens
+		AudioCompletionTokensCost: 0.012,  // $0.012 per 1000 tokens
 	},
 	Gemini25FlashImage: {
-		PromptTokensCost:     0.0003, // $0.0003 per 1000 tokens
-		CompletionTokensCost: 0.03,   // $0.03 per 1000 tokens
+		PromptTokensCost:          0.0003, // $0.0003 per 1000 tokens
+		CompletionTokensCost:      0.03,   // $0.03 per 1000 tokens
+		ImageCompletionTokensCost: 0.03,   // $0.03 per 1000 tokens
 	},
 	Gemini25FlashPreviewTTS: {
 		PromptTokensCost:     0.0005, // $0.0005 per 1000 tokens
@@ -489,17 +700,7 @@ var modelPricing = map[string]ModelPricing{
 
 // NewClient creates a new Paygent SDK client
 func NewClient(apiKey string) *Client {
-	logger := logrus.New()
-	logger.SetLevel(logrus.InfoLevel)
-
-	return &Client{
-		apiKey:  apiKey,
-		baseURL: "http://13.201.118.45:8080",
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		logger: logger,
-	}
+	return NewClientWithURL(apiKey, "http://13.201.118.45:8080")
 }
 
 // NewClientWithURL creates a new Paygent SDK client with custom base URL
@@ -513,134 +714,51 @@ func NewClientWithURL(apiKey, baseURL string) *Client {
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
-		logger: logger,
+		logger:             logger,
+		maxBatchEvents:     defaultMaxBatchEvents,
+		maxBatchBytes:      defaultMaxBatchBytes,
+		retryPolicy:        RetryPolicy{MaxAttempts: 1},
+		pricing:            NewPricingRegistry(),
+		roundingPlaces:     MoneyScale,
+		roundingMode:       RoundHalfEven,
+		customerCurrencies: make(map[string]string),
+		rules:              newBillingRules(),
+		fallbackPricing:    fallbackModelPricing,
+		aliases:            newModelAliases(nil),
+		tokenizers:         newTokenizerRegistry(),
+		volume:             newTokenVolume(),
 	}
 }
 
 // calculateCost calculates the cost based on model and usage data
 func (c *Client) calculateCost(model string, usageData UsageData) (float64, error) {
-	pricing, exists := modelPricing[model]
-	if !exists {
-		c.logger.Warnf("Unknown model '%s', using default pricing", model)
-		// Use default pricing for unknown models (per 1000 tokens)
-		pricing = ModelPricing{
-			PromptTokensCost:     0.1, // $0.10 per 1000 tokens
-			CompletionTokensCost: 0.1, // $0.10 per 1000 tokens
-		}
-	}
-
-	// Calculate cost per 1000 tokens
-	promptCost := (float64(usageData.PromptTokens) / 1000.0) * pricing.PromptTokensCost
-	completionCost := (float64(usageData.CompletionTokens) / 1000.0) * pricing.CompletionTokensCost
-	totalCost := promptCost + completionCost
-
-	c.logger.Debugf("Cost calculation for model '%s': prompt_tokens=%d (%.6f), completion_tokens=%d (%.6f), total=%.6f",
-		model, usageData.PromptTokens, promptCost, usageData.CompletionTokens, completionCost, totalCost)
-
-	return totalCost, nil
+	cost, err := c.usageCost(model, usageData)
+	return cost.Amount.Float64(), err
 }
 
-// getTokenCount estimates tokens for a given model and text
-// Supports OpenAI, Anthropic, Google, Meta, AWS, Mistral, Cohere, DeepSeek
-func (c *Client) getTokenCount(model, text string) int {
-	if len(text) == 0 {
-		return 0
-	}
-
-	modelLower := strings.ToLower(model)
-
-	// OpenAI GPT models
-	if strings.HasPrefix(modelLower, "gpt-") {
-		encoding, err := tiktoken.EncodingForModel(model)
-		if err != nil {
-			c.logger.Warnf("Failed to get encoding for model %s, using cl100k_base: %v", model, err)
-			encoding, err = tiktoken.GetEncoding("cl100k_base")
-			if err != nil {
-				c.logger.Errorf("Failed to get cl100k_base encoding: %v", err)
-				return c.fallbackTokenCount(text)
-			}
-		}
-		return len(encoding.Encode(text, nil, nil))
-	}
-
-	// Anthropic Claude models
-	if strings.HasPrefix(modelLower, "claude-") {
-		encoding, err := tiktoken.GetEncoding("cl100k_base")
-		if err != nil {
-			c.logger.Errorf("Failed to get cl100k_base encoding for Claude: %v", err)
-			return c.fallbackTokenCount(text)
-		}
-		return len(encoding.Encode(text, nil, nil))
-	}
-
-	// Google DeepMind Gemini models
-	if strings.HasPrefix(modelLower, "gemini-") {
-		encoding, err := tiktoken.GetEncoding("cl100k_base")
-		if err != nil {
-			c.logger.Errorf("Failed to get cl100k_base encoding for Gemini: %v", err)
-			return c.fallbackTokenCount(text)
-		}
-		return len(encoding.Encode(text, nil, nil))
-	}
-
-	// Meta Llama models
-	if strings.HasPrefix(modelLower, "llama") {
-		// Use cl100k_base as approximation for Llama models
-		encoding, err := tiktoken.GetEncoding("cl100k_base")
-		if err != nil {
-			c.logger.Errorf("Failed to get cl100k_base encoding for Llama: %v", err)
-			return c.fallbackTokenCount(text)
-		}
-		return len(encoding.Encode(text, nil, nil))
-	}
-
-	// Mistral models
-	if strings.HasPrefix(modelLower, "mistral") {
-		// Use cl100k_base as approximation for Mistral models
-		encoding, err := tiktoken.GetEncoding("cl100k_base")
-		if err != nil {
-			c.logger.Errorf("Failed to get cl100k_base encoding for Mistral: %v", err)
-			return c.fallbackTokenCount(text)
-		}
-		return len(encoding.Encode(text, nil, nil))
-	}
-
-	// Cohere models
-	if strings.HasPrefix(modelLower, "command") {
-		// Use cl100k_base as approximation for Cohere models
-		encoding, err := tiktoken.GetEncoding("cl100k_base")
-		if err != nil {
-			c.logger.Errorf("Failed to get cl100k_base encoding for Cohere: %v", err)
-			return c.fallbackTokenCount(text)
-		}
-		return len(encoding.Encode(text, nil, nil))
+// usageCost prices usage data with the price in force when it occurred
+func (c *Client) usageCost(model string, usageData UsageData) (costBreakdown, error) {
+	cost, err := c.priceUsage(model, tokenCounts{
+		Prompt:       usageData.PromptTokens,
+		Completion:   usageData.CompletionTokens,
+		CachedPrompt: usageData.CachedPromptTokens,
+		CacheWrite:   usageData.CacheWriteTokens,
+		Reasoning:    usageData.ReasoningTokens,
+
+		AudioPrompt:     usageData.AudioPromptTokens,
+		ImagePrompt:     usageData.ImagePromptTokens,
+		VideoPrompt:     usageData.VideoPromptTokens,
+		AudioCompletion: usageData.AudioCompletionTokens,
+		ImageCompletion: usageData.ImageCompletionTokens,
+		VideoCompletion: usageData.VideoCompletionTokens,
+	}, occurredAtOrNow(usageData.OccurredAt))
+	if err != nil {
+		return costBreakdown{}, err
 	}
 
-	// DeepSeek models
-	if strings.HasPrefix(modelLower, "deepseek") {
-		// Use cl100k_base as approximation for DeepSeek models
-		encoding, err := tiktoken.GetEncoding("cl100k_base")
-		if err != nil {
-			c.logger.Errorf("Failed to get cl100k_base encoding for DeepSeek: %v", err)
-			return c.fallbackTokenCount(text)
-		}
-		return len(encoding.Encode(text, nil, nil))
-	}
+	c.logger.Debugf("Cost calculation for model '%s' (%s pricing, version %s%s): %s", model, cost.Source, cost.Version, cost.tierSuffix(), cost)
 
-	// AWS Titan models
-	if strings.HasPrefix(modelLower, "titan-") {
-		// Use cl100k_base as approximation for AWS Titan models
-		encoding, err := tiktoken.GetEncoding("cl100k_base")
-		if err != nil {
-			c.logger.Errorf("Failed to get cl100k_base encoding for Titan: %v", err)
-			return c.fallbackTokenCount(text)
-		}
-		return len(encoding.Encode(text, nil, nil))
-	}
-
-	// Fallback for unknown models
-	c.logger.Warnf("Unknown model '%s', using fallback token counting", model)
-	return c.fallbackTokenCount(text)
+	return cost, nil
 }
 
 // fallbackTokenCount provides a rough estimate when proper tokenization fails
@@ -658,139 +776,229 @@ func (c *Client) fallbackTokenCount(text string) int {
 
 // calculateCostFromStrings calculates the cost based on model and text strings
 func (c *Client) calculateCostFromStrings(model string, usageData UsageDataWithStrings) (float64, error) {
-	pricing, exists := modelPricing[model]
-	if !exists {
-		c.logger.Warnf("Unknown model '%s', using default pricing", model)
-		// Use default pricing for unknown models (per 1000 tokens)
-		pricing = ModelPricing{
-			PromptTokensCost:     0.1, // $0.10 per 1000 tokens
-			CompletionTokensCost: 0.1, // $0.10 per 1000 tokens
-		}
-	}
+	cost, err := c.stringsCost(model, usageData)
+	return cost.Amount.Float64(), err
+}
 
+// stringsCost tokenizes the prompt and output strings and prices them with the
+// price in force when the usage occurred
+func (c *Client) stringsCost(model string, usageData UsageDataWithStrings) (costBreakdown, error) {
 	// Count tokens from strings using proper tokenization
-	promptTokens := c.getTokenCount(usageData.Model, usageData.PromptString)
-	completionTokens := c.getTokenCount(usageData.Model, usageData.OutputString)
+	tokenizer := c.tokenizerFor(usageData.Model, usageData.ServiceProvider)
+	imageTokens := countImageTokens(tokenizer, usageData.Images)
+	promptTokens := c.countTokens(tokenizer, usageData.PromptString) + imageTokens +
+		c.countToolDefinitionTokens(tokenizer, usageData.Tools, usageData.ToolChoice)
+	completionTokens := c.countTokens(tokenizer, usageData.OutputString) +
+		c.countToolCallTokens(tokenizer, usageData.OutputToolCalls)
+
+	tokens := tokenCounts{Prompt: promptTokens, Completion: completionTokens, ImagePrompt: imageTokens}
+	cost, err := c.priceUsage(model, tokens, occurredAtOrNow(usageData.OccurredAt))
+	if err != nil {
+		return costBreakdown{}, err
+	}
 
-	// Calculate cost per 1000 tokens
-	promptCost := (float64(promptTokens) / 1000.0) * pricing.PromptTokensCost
-	completionCost := (float64(completionTokens) / 1000.0) * pricing.CompletionTokensCost
-	totalCost := promptCost + completionCost
+	c.logger.Debugf("Cost calculation for model '%s' from strings (%s pricing, version %s%s): %s", model, cost.Source, cost.Version, cost.tierSuffix(), cost)
 
-	c.logger.Debugf("Cost calculation for model '%s' from strings: prompt_tokens=%d (%.6f), completion_tokens=%d (%.6f), total=%.6f",
-		model, promptTokens, promptCost, completionTokens, completionCost, totalCost)
+	return cost, nil
+}
 
-	return totalCost, nil
+// occurredAtOrNow returns t, or the current time when t is zero
+func occurredAtOrNow(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Now()
+	}
+	return t
 }
 
 // SendUsage sends usage data to the Paygent API
 func (c *Client) SendUsage(agentID, customerID, indicator string, usageData UsageData) error {
+	return c.SendUsageContext(context.Background(), agentID, customerID, indicator, usageData)
+}
+
+// SendUsageContext sends usage data to the Paygent API, honouring cancellation and
+// deadlines carried by ctx. Context errors are wrapped so callers can match them
+// with errors.Is(err, context.Canceled) or errors.Is(err, context.DeadlineExceeded).
+func (c *Client) SendUsageContext(ctx context.Context, agentID, customerID, indicator string, usageData UsageData) error {
 	c.logger.Infof("Starting sendUsage for agentID=%s, customerID=%s, indicator=%s, model=%s",
 		agentID, customerID, indicator, usageData.Model)
 
-	// Calculate cost
-	cost, err := c.calculateCost(usageData.Model, usageData)
+	apiRequest, err := c.BuildUsageRequest(agentID, customerID, indicator, usageData)
 	if err != nil {
-		c.logger.Errorf("Failed to calculate cost: %v", err)
-		return fmt.Errorf("failed to calculate cost: %w", err)
+		return err
 	}
 
-	c.logger.Infof("Calculated cost: %.6f for model %s", cost, usageData.Model)
-
-	// Prepare API request
-	apiRequest := APIRequest{
-		AgentID:         agentID,
-		CustomerID:      customerID,
-		Indicator:       indicator,
-		Amount:          cost,
-		InputToken:      usageData.PromptTokens,
-		OutputToken:     usageData.CompletionTokens,
-		Model:           usageData.Model,
-		ServiceProvider: usageData.ServiceProvider,
-	}
-
-	// Marshal request body
-	requestBody, err := json.Marshal(apiRequest)
-	if err != nil {
-		c.logger.Errorf("Failed to marshal request body: %v", err)
-		return fmt.Errorf("failed to marshal request body: %w", err)
+	if err := c.postUsage(ctx, apiRequest); err != nil {
+		return err
 	}
 
-	c.logger.Debugf("API request body: %s", string(requestBody))
+	c.logger.Infof("Successfully sent usage data for agentID=%s, customerID=%s, cost=%s",
+		agentID, customerID, apiRequest.Amount)
+	return nil
+}
 
-	// Create HTTP request
-	url := fmt.Sprintf("%s/api/v1/usage", c.baseURL)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+// BuildUsageRequest prices usage data and builds the API request for it without sending it
+func (c *Client) BuildUsageRequest(agentID, customerID, indicator string, usageData UsageData) (APIRequest, error) {
+	// Calculate cost with the price in force when the usage occurred
+	usageData.OccurredAt = occurredAtOrNow(usageData.OccurredAt)
+	cost, err := c.usageCost(usageData.Model, usageData)
 	if err != nil {
-		c.logger.Errorf("Failed to create HTTP request: %v", err)
-		return fmt.Errorf("failed to create HTTP request: %w", err)
+		c.logger.Errorf("Failed to calculate cost: %v", err)
+		return APIRequest{}, fmt.Errorf("failed to calculate cost: %w", err)
 	}
-
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("paygent-api-key", c.apiKey)
-
-	c.logger.Debugf("Making HTTP POST request to: %s", url)
-
-	// Make HTTP request
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		c.logger.Errorf("HTTP request failed: %v", err)
-		return fmt.Errorf("HTTP request failed: %w", err)
+	if cost, err = c.convertCost(cost, customerID, usageData.OccurredAt); err != nil {
+		c.logger.Errorf("Failed to convert cost: %v", err)
+		return APIRequest{}, fmt.Errorf("failed to convert cost: %w", err)
 	}
-	defer resp.Body.Close()
-
-	// Read response body
-	responseBody, err := io.ReadAll(resp.Body)
+	eventID := eventIDOrNew(usageData.EventID)
+	if cost, err = c.applyBillingRules(cost, eventID, agentID, customerID, indicator); err != nil {
+		c.logger.Errorf("Failed to apply billing rules: %v", err)
+		return APIRequest{}, fmt.Errorf("failed to apply billing rules: %w", err)
+	}
+	reasoningAmount, err := c.optionalAmount(cost.billedReasoning())
 	if err != nil {
-		c.logger.Errorf("Failed to read response body: %v", err)
-		return fmt.Errorf("failed to read response body: %w", err)
+		c.refundAllowance(APIRequest{EventID: eventID})
+		c.logger.Errorf("Failed to calculate reasoning cost: %v", err)
+		return APIRequest{}, fmt.Errorf("failed to calculate reasoning cost: %w", err)
 	}
 
-	c.logger.Debugf("API response status: %d, body: %s", resp.StatusCode, string(responseBody))
+	c.logger.Infof("Calculated cost: %s %s for model %s", cost.Amount, cost.Currency, usageData.Model)
 
-	// Check response status
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		c.logger.Infof("Successfully sent usage data for agentID=%s, customerID=%s, cost=%.6f",
-			agentID, customerID, cost)
-		return nil
-	}
-
-	// Handle error response
-	c.logger.Errorf("API request failed with status %d: %s", resp.StatusCode, string(responseBody))
-	return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(responseBody))
+	// Prepare API request
+	return APIRequest{
+		EventID:          eventID,
+		AgentID:          agentID,
+		CustomerID:       customerID,
+		Indicator:        indicator,
+		Amount:           cost.Amount,
+		Currency:         cost.Currency,
+		InputToken:       usageData.PromptTokens,
+		OutputToken:      usageData.CompletionTokens,
+		CachedInputToken: usageData.CachedPromptTokens,
+		CacheWriteToken:  usageData.CacheWriteTokens,
+		ReasoningToken:   usageData.ReasoningTokens,
+		ReasoningAmount:  reasoningAmount,
+		AudioInputToken:  usageData.AudioPromptTokens,
+		ImageInputToken:  usageData.ImagePromptTokens,
+		VideoInputToken:  usageData.VideoPromptTokens,
+		AudioOutputToken: usageData.AudioCompletionTokens,
+		ImageOutputToken: usageData.ImageCompletionTokens,
+		VideoOutputToken: usageData.VideoCompletionTokens,
+		Model:            usageData.Model,
+		ServiceProvider:  usageData.ServiceProvider,
+		OccurredAt:       usageData.OccurredAt,
+		PricingVersion:   cost.Version,
+		PricingTier:      cost.Tier,
+		ProviderCurrency: cost.providerCurrencyIfConverted(),
+		ProviderAmount:   cost.ProviderAmount,
+		FXRate:           cost.FX,
+		Cost:             cost.CostAmount,
+		BillingRule:      cost.BillingRule,
+		FreeAllowance:    cost.FreeAllowance,
+		PricedAsModel:    cost.pricedAsModel(usageData.Model),
+		ServerPricing:    cost.Source == PricingSourceServer,
+	}, nil
 }
 
 // SendUsageWithTokenString sends usage data to the Paygent API using prompt and output strings
 func (c *Client) SendUsageWithTokenString(agentID, customerID, indicator string, usageData UsageDataWithStrings) error {
+	return c.SendUsageWithTokenStringContext(context.Background(), agentID, customerID, indicator, usageData)
+}
+
+// SendUsageWithTokenStringContext sends usage data to the Paygent API using prompt and
+// output strings, honouring cancellation and deadlines carried by ctx.
+func (c *Client) SendUsageWithTokenStringContext(ctx context.Context, agentID, customerID, indicator string, usageData UsageDataWithStrings) error {
 	c.logger.Infof("Starting sendUsageWithTokenString for agentID=%s, customerID=%s, indicator=%s, serviceProvider=%s, model=%s",
 		agentID, customerID, indicator, usageData.ServiceProvider, usageData.Model)
 
-	// Calculate cost from strings
-	cost, err := c.calculateCostFromStrings(usageData.Model, usageData)
+	apiRequest, err := c.BuildUsageRequestFromStrings(agentID, customerID, indicator, usageData)
 	if err != nil {
-		c.logger.Errorf("Failed to calculate cost from strings: %v", err)
-		return fmt.Errorf("failed to calculate cost from strings: %w", err)
+		return err
 	}
 
-	c.logger.Infof("Calculated cost: %.6f for model %s from strings", cost, usageData.Model)
+	if err := c.postUsage(ctx, apiRequest); err != nil {
+		return err
+	}
 
-	// Count tokens from strings using proper tokenization
-	promptTokens := c.getTokenCount(usageData.Model, usageData.PromptString)
-	completionTokens := c.getTokenCount(usageData.Model, usageData.OutputString)
+	c.logger.Infof("Successfully sent usage data from strings for agentID=%s, customerID=%s, cost=%s",
+		agentID, customerID, apiRequest.Amount)
+	return nil
+}
 
-	// Prepare API request
-	apiRequest := APIRequest{
-		AgentID:         agentID,
-		CustomerID:      customerID,
-		Indicator:       indicator,
-		Amount:          cost,
-		InputToken:      promptTokens,
-		OutputToken:     completionTokens,
-		Model:           usageData.Model,
+// BuildUsageRequestFromStrings tokenizes and prices string usage data and builds the API request for it without sending it
+func (c *Client) BuildUsageRequestFromStrings(agentID, customerID, indicator string, usageData UsageDataWithStrings) (APIRequest, error) {
+	// Calculate cost from strings with the price in force when the usage occurred
+	usageData.OccurredAt = occurredAtOrNow(usageData.OccurredAt)
+	cost, err := c.stringsCost(usageData.Model, usageData)
+	if err != nil {
+		c.logger.Errorf("Failed to calculate cost from strings: %v", err)
+		return APIRequest{}, fmt.Errorf("failed to calculate cost from strings: %w", err)
+	}
+	return c.countedUsageRequest(agentID, customerID, indicator, countedUsage{
+		EventID:         usageData.EventID,
 		ServiceProvider: usageData.ServiceProvider,
+		Model:           usageData.Model,
+		OccurredAt:      usageData.OccurredAt,
+		Source:          "strings",
+	}, cost)
+}
+
+// countedUsage describes usage whose tokens the SDK counted
+type countedUsage struct {
+	EventID         string
+	ServiceProvider string
+	Model           string
+	OccurredAt      time.Time
+	// Source names what the tokens were counted from, for logging
+	Source string
+}
+
+// countedUsageRequest converts and bills the cost of counted usage and builds its API request
+func (c *Client) countedUsageRequest(agentID, customerID, indicator string, usage countedUsage, cost costBreakdown) (APIRequest, error) {
+	cost, err := c.convertCost(cost, customerID, usage.OccurredAt)
+	if err != nil {
+		c.logger.Errorf("Failed to convert cost: %v", err)
+		return APIRequest{}, fmt.Errorf("failed to convert cost: %w", err)
+	}
+	eventID := eventIDOrNew(usage.EventID)
+	if cost, err = c.applyBillingRules(cost, eventID, agentID, customerID, indicator); err != nil {
+		c.logger.Errorf("Failed to apply billing rules: %v", err)
+		return APIRequest{}, fmt.Errorf("failed to apply billing rules: %w", err)
 	}
 
+	c.logger.Infof("Calculated cost: %s %s for model %s from %s", cost.Amount, cost.Currency, usage.Model, usage.Source)
+
+	// Prepare API request
+	return APIRequest{
+		EventID:          eventID,
+		AgentID:          agentID,
+		CustomerID:       customerID,
+		Indicator:        indicator,
+		Amount:           cost.Amount,
+		Currency:         cost.Currency,
+		InputToken:       cost.Tokens.Prompt,
+		OutputToken:      cost.Tokens.Completion,
+		ImageInputToken:  cost.Tokens.ImagePrompt,
+		Model:            usage.Model,
+		ServiceProvider:  usage.ServiceProvider,
+		OccurredAt:       usage.OccurredAt,
+		PricingVersion:   cost.Version,
+		PricingTier:      cost.Tier,
+		ProviderCurrency: cost.providerCurrencyIfConverted(),
+		ProviderAmount:   cost.ProviderAmount,
+		FXRate:           cost.FX,
+		Cost:             cost.CostAmount,
+		BillingRule:      cost.BillingRule,
+		FreeAllowance:    cost.FreeAllowance,
+		PricedAsModel:    cost.pricedAsModel(usage.Model),
+		ServerPricing:    cost.Source == PricingSourceServer,
+	}, nil
+}
+
+// postUsage posts a single usage record to the usage endpoint,
+// using its event ID as the idempotency key
+func (c *Client) postUsage(ctx context.Context, apiRequest APIRequest) error {
+	apiRequest.EventID = eventIDOrNew(apiRequest.EventID)
+
 	// Marshal request body
 	requestBody, err := json.Marshal(apiRequest)
 	if err != nil {
@@ -800,17 +1008,110 @@ func (c *Client) SendUsageWithTokenString(agentID, customerID, indicator string,
 
 	c.logger.Debugf("API request body: %s", string(requestBody))
 
+	spooled := c.spoolAppend(apiRequest)
+
+	_, err = c.doPost(ctx, "/api/v1/usage", requestBody, apiRequest.EventID)
+	if err == nil || isPermanentFailure(err) {
+		c.spoolAck(apiRequest.EventID)
+	}
+	c.settleUsage(apiRequest, err, spooled)
+	return err
+}
+
+// settleUsage accounts for the outcome of sending an event: a delivered event
+// counts towards its model's volume, and one that will never be delivered gives
+// back the free allowance it used. Failed events that made it into the spool
+// are sent later; the rest are lost.
+func (c *Client) settleUsage(apiRequest APIRequest, err error, spooled bool) {
+	switch {
+	case err == nil:
+		c.recordVolume(apiRequest)
+		c.keepAllowance(apiRequest)
+	case isPermanentFailure(err) || !spooled:
+		c.refundAllowance(apiRequest)
+	}
+}
+
+// eventIDOrNew returns id, or a new random UUID when id is empty
+func eventIDOrNew(id string) string {
+	if id != "" {
+		return id
+	}
+	return uuid.NewString()
+}
+
+// APIError is returned when the Paygent API responds with a non-2xx status
+type APIError struct {
+	StatusCode int
+	Body       string
+	// RetryAfter is the delay requested by the Retry-After header, if any
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API request failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// doPost performs an authenticated JSON POST against the Paygent API and returns
+// the response body for 2xx responses. All network calls go through here so that
+// context cancellation, deadlines and the retry policy are applied uniformly.
+// The same body and idempotency key are sent on every attempt, so the API can
+// discard duplicates of a request whose response was lost.
+func (c *Client) doPost(ctx context.Context, path string, requestBody []byte, idempotencyKey string) ([]byte, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	policy := c.retryPolicy
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			c.logger.Errorf("Context done before request: %v", err)
+			return nil, fmt.Errorf("HTTP request aborted: %w", err)
+		}
+
+		responseBody, err := c.doPostOnce(ctx, path, requestBody, idempotencyKey)
+		if err == nil {
+			return responseBody, nil
+		}
+		if attempt >= policy.MaxAttempts || !c.shouldRetry(ctx, err) {
+			return nil, err
+		}
+
+		delay := policy.backoff(attempt)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			delay = max(delay, policy.retryAfter(apiErr.RetryAfter))
+		}
+
+		c.logger.Warnf("Attempt %d/%d to %s failed, retrying in %s: %v", attempt, policy.MaxAttempts, path, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			c.logger.Errorf("Context done while waiting to retry: %v", ctx.Err())
+			return nil, fmt.Errorf("HTTP request aborted: %w (last error: %v)", ctx.Err(), err)
+		}
+	}
+}
+
+// doPostOnce performs a single attempt of doPost
+func (c *Client) doPostOnce(ctx context.Context, path string, requestBody []byte, idempotencyKey string) ([]byte, error) {
 	// Create HTTP request
-	url := fmt.Sprintf("%s/api/v1/usage", c.baseURL)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	url := fmt.Sprintf("%s%s", c.baseURL, path)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(requestBody))
 	if err != nil {
 		c.logger.Errorf("Failed to create HTTP request: %v", err)
-		return fmt.Errorf("failed to create HTTP request: %w", err)
+		return nil, permanentError{fmt.Errorf("failed to create HTTP request: %w", err)}
 	}
 
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("paygent-api-key", c.apiKey)
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
 
 	c.logger.Debugf("Making HTTP POST request to: %s", url)
 
@@ -818,7 +1119,7 @@ func (c *Client) SendUsageWithTokenString(agentID, customerID, indicator string,
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		c.logger.Errorf("HTTP request failed: %v", err)
-		return fmt.Errorf("HTTP request failed: %w", err)
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -826,21 +1127,23 @@ func (c *Client) SendUsageWithTokenString(agentID, customerID, indicator string,
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
 		c.logger.Errorf("Failed to read response body: %v", err)
-		return fmt.Errorf("failed to read response body: %w", err)
+		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
 	c.logger.Debugf("API response status: %d, body: %s", resp.StatusCode, string(responseBody))
 
 	// Check response status
 	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		c.logger.Infof("Successfully sent usage data from strings for agentID=%s, customerID=%s, cost=%.6f",
-			agentID, customerID, cost)
-		return nil
+		return responseBody, nil
 	}
 
 	// Handle error response
 	c.logger.Errorf("API request failed with status %d: %s", resp.StatusCode, string(responseBody))
-	return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(responseBody))
+	return nil, &APIError{
+		StatusCode: resp.StatusCode,
+		Body:       string(responseBody),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
 }
 
 // SetLogLevel sets the logging level for the client
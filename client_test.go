@@ -1,7 +1,13 @@
 package paygent
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -205,3 +211,103 @@ func TestCalculateCostFromStrings(t *testing.T) {
 		})
 	}
 }
+
+func TestSendUsageContext(t *testing.T) {
+	var gotKey string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey = r.Header.Get("paygent-api-key")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClientWithURL("test-api-key", server.URL)
+	usageData := UsageData{
+		ServiceProvider:  OpenAI,
+		Model:            GPT4O,
+		PromptTokens:     100,
+		CompletionTokens: 50,
+	}
+
+	if err := client.SendUsageContext(context.Background(), "agent", "customer", "indicator", usageData); err != nil {
+		t.Fatalf("SendUsageContext() error = %v", err)
+	}
+	if gotKey != "test-api-key" {
+		t.Errorf("Expected paygent-api-key header 'test-api-key', got '%s'", gotKey)
+	}
+}
+
+func TestSendUsageContextCancellation(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := NewClientWithURL("test-api-key", server.URL)
+	usageData := UsageData{Model: GPT4O, PromptTokens: 10, CompletionTokens: 10}
+
+	t.Run("deadline exceeded", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		err := client.SendUsageContext(ctx, "agent", "customer", "indicator", usageData)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+		}
+	})
+
+	t.Run("already canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := client.SendUsageWithTokenStringContext(ctx, "agent", "customer", "indicator", UsageDataWithStrings{
+			Model:        GPT4O,
+			PromptString: "hello",
+			OutputString: "world",
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	})
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	var keys []string
+	var bodies []APIRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body APIRequest
+		json.NewDecoder(r.Body).Decode(&body)
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		bodies = append(bodies, body)
+		if len(keys)%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClientWithURL("test-api-key", server.URL)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond})
+
+	// Generated event ID is reused on retry
+	if err := client.SendUsage("agent", "customer", "indicator", UsageData{Model: GPT4O, PromptTokens: 10}); err != nil {
+		t.Fatalf("SendUsage() error = %v", err)
+	}
+	if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+		t.Fatalf("Expected the same non-empty Idempotency-Key on both attempts, got %v", keys)
+	}
+	if bodies[0].EventID != keys[0] || bodies[1].EventID != keys[0] {
+		t.Errorf("Expected eventId %q in both bodies, got %q and %q", keys[0], bodies[0].EventID, bodies[1].EventID)
+	}
+
+	// Caller-supplied event ID is preserved
+	if err := client.SendUsage("agent", "customer", "indicator", UsageData{EventID: "evt-123", Model: GPT4O}); err != nil {
+		t.Fatalf("SendUsage() error = %v", err)
+	}
+	if keys[2] != "evt-123" || bodies[2].EventID != "evt-123" {
+		t.Errorf("Expected caller-supplied event ID 'evt-123', got key %q and eventId %q", keys[2], bodies[2].EventID)
+	}
+}
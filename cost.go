@@ -0,0 +1,293 @@
+package paygent
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidUsage is returned when usage data cannot be priced, e.g. when the
+// cached prompt tokens exceed the prompt tokens
+var ErrInvalidUsage = errors.New("invalid usage data")
+
+// tokenCounts are the billable token buckets of a usage event
+type tokenCounts struct {
+	// Prompt is every input token, including cache reads, cache writes and media
+	Prompt int
+	// Completion is every output token, including reasoning and media
+	Completion   int
+	CachedPrompt int
+	CacheWrite   int
+	Reasoning    int
+
+	AudioPrompt     int
+	ImagePrompt     int
+	VideoPrompt     int
+	AudioCompletion int
+	ImageCompletion int
+	VideoCompletion int
+}
+
+// textPrompt returns the uncached text prompt tokens, billed at the regular input rate
+func (t tokenCounts) textPrompt() int {
+	return t.Prompt - t.CachedPrompt - t.CacheWrite - t.AudioPrompt - t.ImagePrompt - t.VideoPrompt
+}
+
+// textCompletion returns the visible text completion tokens, billed at the regular output rate
+func (t tokenCounts) textCompletion() int {
+	return t.Completion - t.Reasoning - t.AudioCompletion - t.ImageCompletion - t.VideoCompletion
+}
+
+// validate checks that the prompt and completion buckets are not negative and
+// fit within the prompt and completion tokens
+func (t tokenCounts) validate() error {
+	buckets := []int{t.CachedPrompt, t.CacheWrite, t.Reasoning,
+		t.AudioPrompt, t.ImagePrompt, t.VideoPrompt, t.AudioCompletion, t.ImageCompletion, t.VideoCompletion}
+	for _, tokens := range buckets {
+		if tokens < 0 {
+			return fmt.Errorf("%w: token counts must not be negative", ErrInvalidUsage)
+		}
+	}
+	if t.textPrompt() < 0 {
+		return fmt.Errorf("%w: cached, cache write and media prompt tokens (%d) exceed prompt tokens (%d)",
+			ErrInvalidUsage, t.Prompt-t.textPrompt(), t.Prompt)
+	}
+	if t.textCompletion() < 0 {
+		return fmt.Errorf("%w: reasoning and media completion tokens (%d) exceed completion tokens (%d)",
+			ErrInvalidUsage, t.Completion-t.textCompletion(), t.Completion)
+	}
+	return nil
+}
+
+// costBreakdown is the result of pricing a usage event
+type costBreakdown struct {
+	Pricing ModelPricing
+	Source  PricingSource
+	Version string
+	// PricedAs is the model whose price was used, which differs from the
+	// usage's model when an unknown model was matched to a known one
+	PricedAs string
+	// Tier is the name of the pricing tier applied, empty for the base rates
+	Tier   string
+	Tokens tokenCounts
+
+	// Exact cost of each bucket. PromptCost covers the uncached text prompt
+	// tokens only and CompletionCost the visible text completion tokens only.
+	PromptCost       *big.Rat
+	CachedPromptCost *big.Rat
+	CacheWriteCost   *big.Rat
+	CompletionCost   *big.Rat
+	ReasoningCost    *big.Rat
+
+	AudioPromptCost     *big.Rat
+	ImagePromptCost     *big.Rat
+	VideoPromptCost     *big.Rat
+	AudioCompletionCost *big.Rat
+	ImageCompletionCost *big.Rat
+	VideoCompletionCost *big.Rat
+
+	// Total is the exact sum of the buckets and Amount is Total rounded with
+	// the client's rounding settings
+	Total  *big.Rat
+	Amount Money
+
+	// ProviderCurrency is the currency the model is priced in and Currency the
+	// currency of Amount. When Amount was converted, FX is the rate used and
+	// ProviderAmount the amount before conversion.
+	ProviderCurrency string
+	Currency         string
+	FX               *FXRate
+	ProviderAmount   *Money
+
+	// BillingRule is the name of the billing rule Amount was priced with, if
+	// any, and CostAmount the cost in the billing currency before the rule.
+	// FreeAllowance is the part of the price the rule's free allowance covered.
+	BillingRule   string
+	CostAmount    *Money
+	FreeAllowance *Money
+}
+
+// buckets returns the exact cost of every bucket
+func (b costBreakdown) buckets() []*big.Rat {
+	return []*big.Rat{b.PromptCost, b.CachedPromptCost, b.CacheWriteCost, b.CompletionCost, b.ReasoningCost,
+		b.AudioPromptCost, b.ImagePromptCost, b.VideoPromptCost, b.AudioCompletionCost, b.ImageCompletionCost, b.VideoCompletionCost}
+}
+
+// priceUsage prices token counts for model with the price in force at the given time.
+// Each bucket is billed at its own rate. Prompt bucket rates that are not set
+// fall back to the prompt rate, and completion bucket rates to the completion rate.
+func (c *Client) priceUsage(model string, tokens tokenCounts, at time.Time) (costBreakdown, error) {
+	if err := tokens.validate(); err != nil {
+		return costBreakdown{}, err
+	}
+
+	pricing, source, pricedAs, err := c.resolvePricing(model, at)
+	if err != nil {
+		return costBreakdown{}, err
+	}
+	version := PricingVersionID(pricedAs, pricing, source)
+
+	var tierName string
+	if tier, ok := pricing.tierFor(c.tierMeasure(pricedAs, pricing.TierBasis, tokens)); ok {
+		pricing, tierName = pricing.withTier(tier), tier.name()
+	}
+	prompt, completion := pricing.PromptTokensCost, pricing.CompletionTokensCost
+
+	cost := costBreakdown{
+		Pricing:          pricing,
+		Source:           source,
+		Version:          version,
+		PricedAs:         pricedAs,
+		Tier:             tierName,
+		Tokens:           tokens,
+		PromptCost:       perThousand(tokens.textPrompt(), prompt),
+		CachedPromptCost: perThousand(tokens.CachedPrompt, rateOr(pricing.CachedPromptTokensCost, prompt)),
+		CacheWriteCost:   perThousand(tokens.CacheWrite, rateOr(pricing.CacheWriteTokensCost, prompt)),
+		CompletionCost:   perThousand(tokens.textCompletion(), completion),
+		ReasoningCost:    perThousand(tokens.Reasoning, rateOr(pricing.ReasoningTokensCost, completion)),
+
+		AudioPromptCost:     perThousand(tokens.AudioPrompt, rateOr(pricing.AudioPromptTokensCost, prompt)),
+		ImagePromptCost:     perThousand(tokens.ImagePrompt, rateOr(pricing.ImagePromptTokensCost, prompt)),
+		VideoPromptCost:     perThousand(tokens.VideoPrompt, rateOr(pricing.VideoPromptTokensCost, prompt)),
+		AudioCompletionCost: perThousand(tokens.AudioCompletion, rateOr(pricing.AudioCompletionTokensCost, completion)),
+		ImageCompletionCost: perThousand(tokens.ImageCompletion, rateOr(pricing.ImageCompletionTokensCost, completion)),
+		VideoCompletionCost: perThousand(tokens.VideoCompletion, rateOr(pricing.VideoCompletionTokensCost, completion)),
+	}
+	cost.Total = new(big.Rat)
+	for _, bucket := range cost.buckets() {
+		cost.Total.Add(cost.Total, bucket)
+	}
+	if cost.Amount, err = c.roundAmount(cost.Total); err != nil {
+		return costBreakdown{}, fmt.Errorf("cost of %s: %w", model, err)
+	}
+	return cost, nil
+}
+
+// tierMeasure returns the value a pricing tier is selected with, for usage
+// priced as the given model. The volume is only read here: it grows when
+// events are delivered, not priced.
+func (c *Client) tierMeasure(pricedAs string, basis TierBasis, tokens tokenCounts) int {
+	if basis == TierByVolume {
+		return c.volume.total(pricedAs)
+	}
+	return tokens.Prompt
+}
+
+// recordVolume counts a delivered event's tokens towards the volume of the
+// model it was priced as
+func (c *Client) recordVolume(apiRequest APIRequest) {
+	model := apiRequest.PricedAsModel
+	if model == "" {
+		model = apiRequest.Model
+	}
+	c.volume.add(model, apiRequest.InputToken+apiRequest.OutputToken)
+}
+
+// tokenVolume tracks the cumulative tokens delivered per model
+type tokenVolume struct {
+	mu     sync.Mutex
+	totals map[string]int
+}
+
+func newTokenVolume() *tokenVolume {
+	return &tokenVolume{totals: make(map[string]int)}
+}
+
+// add records tokens for model
+func (v *tokenVolume) add(model string, tokens int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.totals[model] += tokens
+}
+
+// total returns the tokens recorded for model
+func (v *tokenVolume) total(model string) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.totals[model]
+}
+
+// reset clears the recorded volumes
+func (v *tokenVolume) reset() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.totals = make(map[string]int)
+}
+
+// TokenVolume returns the cumulative tokens of the events the client has
+// delivered for model since it was created or ResetTokenVolume was last
+// called. Events that are only built, or that fail to send, do not count.
+// Provider-native IDs share the volume of their canonical model.
+func (c *Client) TokenVolume(model string) int {
+	if canonical, ok := c.canonicalModel(model, time.Now()); ok {
+		model = canonical
+	}
+	return c.volume.total(model)
+}
+
+// ResetTokenVolume clears the volumes volume-based pricing tiers are selected
+// with, e.g. at the start of a billing period
+func (c *Client) ResetTokenVolume() {
+	c.volume.reset()
+}
+
+// tierSuffix describes the applied tier for debug logs
+func (b costBreakdown) tierSuffix() string {
+	if b.Tier == "" {
+		return ""
+	}
+	return ", tier " + b.Tier
+}
+
+// rateOr returns rate, or fallback when rate is not set
+func rateOr(rate, fallback float64) float64 {
+	if rate > 0 {
+		return rate
+	}
+	return fallback
+}
+
+// perThousand returns the exact cost of tokens at a price per 1000 tokens. The
+// price is taken as the decimal it prints as, so 0.0025 is exactly 0.0025.
+func perThousand(tokens int, cost float64) *big.Rat {
+	r := ratFromFloat(cost)
+	return r.Mul(r, big.NewRat(int64(tokens), 1000))
+}
+
+// String describes the priced buckets for debug logs. Prompt and completion
+// tokens are always listed, other buckets only when used.
+func (b costBreakdown) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "prompt_tokens=%d (%s), completion_tokens=%d (%s)",
+		b.Tokens.textPrompt(), b.PromptCost.FloatString(MoneyScale), b.Tokens.textCompletion(), b.CompletionCost.FloatString(MoneyScale))
+
+	buckets := []struct {
+		name   string
+		tokens int
+		cost   *big.Rat
+	}{
+		{"cached_prompt_tokens", b.Tokens.CachedPrompt, b.CachedPromptCost},
+		{"cache_write_tokens", b.Tokens.CacheWrite, b.CacheWriteCost},
+		{"reasoning_tokens", b.Tokens.Reasoning, b.ReasoningCost},
+		{"audio_prompt_tokens", b.Tokens.AudioPrompt, b.AudioPromptCost},
+		{"image_prompt_tokens", b.Tokens.ImagePrompt, b.ImagePromptCost},
+		{"video_prompt_tokens", b.Tokens.VideoPrompt, b.VideoPromptCost},
+		{"audio_completion_tokens", b.Tokens.AudioCompletion, b.AudioCompletionCost},
+		{"image_completion_tokens", b.Tokens.ImageCompletion, b.ImageCompletionCost},
+		{"video_completion_tokens", b.Tokens.VideoCompletion, b.VideoCompletionCost},
+	}
+	for _, bucket := range buckets {
+		if bucket.tokens != 0 {
+			fmt.Fprintf(&sb, ", %s=%d (%s)", bucket.name, bucket.tokens, bucket.cost.FloatString(MoneyScale))
+		}
+	}
+
+	fmt.Fprintf(&sb, ", total=%s, amount=%s", b.Total.FloatString(MoneyScale), b.Amount)
+	return sb.String()
+}
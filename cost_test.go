@@ -0,0 +1,292 @@
+package paygent
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCachedTokenPricing(t *testing.T) {
+	client := NewClient("test-api-key")
+	client.RegisterModelPricing("no-cache-rates", ModelPricing{PromptTokensCost: 1, CompletionTokensCost: 2})
+
+	tests := []struct {
+		name      string
+		model     string
+		usageData UsageData
+		expected  float64
+	}{
+		{
+			name:      "OpenAI cached read",
+			model:     GPT4O,
+			usageData: UsageData{PromptTokens: 1000, CachedPromptTokens: 800, CompletionTokens: 100},
+			expected:  0.0005 + 0.001 + 0.001, // 200 * 0.0025 + 800 * 0.00125 + 100 * 0.01
+		},
+		{
+			name:      "Anthropic cache read and write",
+			model:     Sonnet45,
+			usageData: UsageData{PromptTokens: 3000, CachedPromptTokens: 1000, CacheWriteTokens: 1000, CompletionTokens: 1000},
+			expected:  0.003 + 0.0003 + 0.00375 + 0.015,
+		},
+		{
+			name:      "No cache rates bills at the prompt rate",
+			model:     "no-cache-rates",
+			usageData: UsageData{PromptTokens: 1000, CachedPromptTokens: 500, CacheWriteTokens: 500},
+			expected:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cost, err := client.calculateCost(tt.model, tt.usageData)
+			if err != nil {
+				t.Fatalf("calculateCost() error = %v", err)
+			}
+			if math.Abs(cost-tt.expected) > 1e-9 {
+				t.Errorf("calculateCost() = %v, want %v", cost, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCachedTokensExceedPrompt(t *testing.T) {
+	client := NewClient("test-api-key")
+
+	usageData := UsageData{Model: GPT4O, PromptTokens: 100, CachedPromptTokens: 80, CacheWriteTokens: 40}
+	if _, err := client.BuildUsageRequest("agent", "customer", "indicator", usageData); !errors.Is(err, ErrInvalidUsage) {
+		t.Errorf("Expected ErrInvalidUsage, got %v", err)
+	}
+}
+
+func TestBuildUsageRequestCachedTokens(t *testing.T) {
+	client := NewClient("test-api-key")
+
+	request, err := client.BuildUsageRequest("agent", "customer", "indicator", UsageData{
+		Model:              Haiku45,
+		PromptTokens:       2000,
+		CachedPromptTokens: 1500,
+		CacheWriteTokens:   500,
+	})
+	if err != nil {
+		t.Fatalf("BuildUsageRequest() error = %v", err)
+	}
+	if request.InputToken != 2000 || request.CachedInputToken != 1500 || request.CacheWriteToken != 500 {
+		t.Errorf("Unexpected token counts in request %+v", request)
+	}
+	if want := MoneyFromFloat(0.00015 + 0.000625); request.Amount != want {
+		t.Errorf("Expected amount %v, got %v", want, request.Amount)
+	}
+}
+
+func TestReasoningTokenPricing(t *testing.T) {
+	client := NewClient("test-api-key")
+	client.RegisterModelPricing("reasoner", ModelPricing{PromptTokensCost: 1, CompletionTokensCost: 2, ReasoningTokensCost: 4})
+
+	tests := []struct {
+		name              string
+		model             string
+		usageData         UsageData
+		expectedAmount    float64
+		expectedReasoning float64
+	}{
+		{
+			name:              "Reasoning at the output rate",
+			model:             O3,
+			usageData:         UsageData{PromptTokens: 1000, CompletionTokens: 2000, ReasoningTokens: 1500},
+			expectedAmount:    0.002 + 0.016, // 1000 * 0.002 + 2000 * 0.008
+			expectedReasoning: 0.012,
+		},
+		{
+			name:              "Distinct reasoning rate",
+			model:             "reasoner",
+			usageData:         UsageData{PromptTokens: 1000, CompletionTokens: 1000, ReasoningTokens: 500},
+			expectedAmount:    1 + 1 + 2,
+			expectedReasoning: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.usageData.Model = tt.model
+			request, err := client.BuildUsageRequest("agent", "customer", "indicator", tt.usageData)
+			if err != nil {
+				t.Fatalf("BuildUsageRequest() error = %v", err)
+			}
+			if request.Amount != MoneyFromFloat(tt.expectedAmount) {
+				t.Errorf("Expected amount %v, got %v", tt.expectedAmount, request.Amount)
+			}
+			if request.ReasoningAmount == nil || *request.ReasoningAmount != MoneyFromFloat(tt.expectedReasoning) {
+				t.Errorf("Expected reasoning amount %v, got %v", tt.expectedReasoning, request.ReasoningAmount)
+			}
+			if request.ReasoningToken != tt.usageData.ReasoningTokens {
+				t.Errorf("Expected %d reasoning tokens, got %d", tt.usageData.ReasoningTokens, request.ReasoningToken)
+			}
+		})
+	}
+
+	// Reasoning tokens are part of the completion tokens
+	usageData := UsageData{Model: O3, CompletionTokens: 10, ReasoningTokens: 20}
+	if _, err := client.calculateCost(O3, usageData); !errors.Is(err, ErrInvalidUsage) {
+		t.Errorf("Expected ErrInvalidUsage, got %v", err)
+	}
+}
+
+func TestModalityPricing(t *testing.T) {
+	client := NewClient("test-api-key")
+
+	tests := []struct {
+		name      string
+		model     string
+		usageData UsageData
+		expected  float64
+	}{
+		{
+			name:  "Realtime audio and image input",
+			model: GPTRealtime,
+			usageData: UsageData{
+				PromptTokens: 3000, AudioPromptTokens: 1000, ImagePromptTokens: 1000,
+				CompletionTokens: 2000, AudioCompletionTokens: 1000,
+			},
+			// text 1000 * 0.004 + audio 1000 * 0.032 + image 1000 * 0.005 + text 1000 * 0.016 + audio 1000 * 0.064
+			expected: 0.004 + 0.032 + 0.005 + 0.016 + 0.064,
+		},
+		{
+			name:      "Image output",
+			model:     Gemini25FlashImage,
+			usageData: UsageData{PromptTokens: 1000, CompletionTokens: 1290, ImageCompletionTokens: 1290},
+			expected:  0.0003 + 0.0387,
+		},
+		{
+			name:      "Video without a video rate bills at the prompt rate",
+			model:     GPT4O,
+			usageData: UsageData{PromptTokens: 1000, VideoPromptTokens: 1000},
+			expected:  0.0025,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cost, err := client.calculateCost(tt.model, tt.usageData)
+			if err != nil {
+				t.Fatalf("calculateCost() error = %v", err)
+			}
+			if math.Abs(cost-tt.expected) > 1e-9 {
+				t.Errorf("calculateCost() = %v, want %v", cost, tt.expected)
+			}
+		})
+	}
+
+	// Media tokens are part of the prompt and completion tokens
+	usageData := UsageData{PromptTokens: 10, AudioPromptTokens: 20}
+	if _, err := client.calculateCost(GPTRealtime, usageData); !errors.Is(err, ErrInvalidUsage) {
+		t.Errorf("Expected ErrInvalidUsage, got %v", err)
+	}
+}
+
+func TestTieredPricing(t *testing.T) {
+	client := NewClient("test-api-key")
+
+	tests := []struct {
+		name         string
+		usageData    UsageData
+		expected     float64
+		expectedTier string
+	}{
+		{
+			name:      "At the threshold",
+			usageData: UsageData{Model: Gemini25Pro, PromptTokens: 200000, CompletionTokens: 1000},
+			expected:  200*0.00125 + 0.01,
+		},
+		{
+			name:         "Long context",
+			usageData:    UsageData{Model: Gemini25Pro, PromptTokens: 200001, CompletionTokens: 1000},
+			expected:     200.001*0.0025 + 0.015,
+			expectedTier: "long-context",
+		},
+		{
+			name:         "Long context cache rates",
+			usageData:    UsageData{Model: Sonnet45, PromptTokens: 300000, CachedPromptTokens: 100000, CacheWriteTokens: 100000},
+			expected:     100*0.006 + 100*0.0006 + 100*0.0075,
+			expectedTier: "long-context",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, err := client.BuildUsageRequest("agent", "customer", "indicator", tt.usageData)
+			if err != nil {
+				t.Fatalf("BuildUsageRequest() error = %v", err)
+			}
+			if request.Amount != MoneyFromFloat(tt.expected) {
+				t.Errorf("Expected amount %v, got %v", tt.expected, request.Amount)
+			}
+			if request.PricingTier != tt.expectedTier {
+				t.Errorf("Expected tier %q, got %q", tt.expectedTier, request.PricingTier)
+			}
+		})
+	}
+}
+
+func TestVolumeTieredPricing(t *testing.T) {
+	failing := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClientWithURL("test-api-key", server.URL)
+	client.RegisterModelPricing("bulk-model", ModelPricing{
+		PromptTokensCost:     1,
+		CompletionTokensCost: 1,
+		TierBasis:            TierByVolume,
+		Tiers: []PricingTier{
+			{MinTokens: 2000, PromptTokensCost: 0.5, CompletionTokensCost: 0.5},
+			{Name: "enterprise", MinTokens: 4000, PromptTokensCost: 0.25, CompletionTokensCost: 0.25},
+		},
+	})
+
+	usageData := UsageData{Model: "bulk-model", PromptTokens: 1000, CompletionTokens: 1000}
+	expected := []struct {
+		cost float64
+		tier string
+	}{
+		{cost: 2},                       // volume 0
+		{cost: 1, tier: ">=2000"},       // volume 2000
+		{cost: 0.5, tier: "enterprise"}, // volume 4000
+	}
+	for i, want := range expected {
+		// Building a request prices it without counting it
+		for j := 0; j < 2; j++ {
+			request, err := client.BuildUsageRequest("agent", "customer", "indicator", usageData)
+			if err != nil {
+				t.Fatalf("BuildUsageRequest() error = %v", err)
+			}
+			if request.Amount != MoneyFromFloat(want.cost) || request.PricingTier != want.tier {
+				t.Errorf("Event %d: expected %v in tier %q, got %s in tier %q", i, want.cost, want.tier, request.Amount, request.PricingTier)
+			}
+		}
+		if err := client.SendUsage("agent", "customer", "indicator", usageData); err != nil {
+			t.Fatalf("SendUsage() error = %v", err)
+		}
+	}
+	if volume := client.TokenVolume("bulk-model"); volume != 6000 {
+		t.Errorf("Expected volume 6000, got %d", volume)
+	}
+
+	failing = true
+	if err := client.SendUsage("agent", "customer", "indicator", usageData); err == nil {
+		t.Fatal("Expected SendUsage() to fail")
+	}
+	if volume := client.TokenVolume("bulk-model"); volume != 6000 {
+		t.Errorf("Expected a failed event not to count towards the volume, got %d", volume)
+	}
+
+	client.ResetTokenVolume()
+	if cost, _ := client.usageCost("bulk-model", usageData); cost.Tier != "" {
+		t.Errorf("Expected base rates after ResetTokenVolume, got tier %q", cost.Tier)
+	}
+}
@@ -0,0 +1,199 @@
+package paygent
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// openAIModelEncodings is the tiktoken encoding of every OpenAI model constant.
+// GPT-4o, GPT-4.1, GPT-5, the realtime and audio models and the o-series use
+// o200k_base; GPT-4, GPT-3.5 and the base completion models use cl100k_base.
+var openAIModelEncodings = map[string]string{
+	GPT5:                     EncodingO200kBase,
+	GPT5Mini:                 EncodingO200kBase,
+	GPT5Nano:                 EncodingO200kBase,
+	GPT5ChatLatest:           EncodingO200kBase,
+	GPT5Codex:                EncodingO200kBase,
+	GPT5Pro:                  EncodingO200kBase,
+	GPT5SearchAPI:            EncodingO200kBase,
+	GPT41:                    EncodingO200kBase,
+	GPT41Mini:                EncodingO200kBase,
+	GPT41Nano:                EncodingO200kBase,
+	GPT4O:                    EncodingO200kBase,
+	GPT4O20240513:            EncodingO200kBase,
+	GPT4OMini:                EncodingO200kBase,
+	GPTRealtime:              EncodingO200kBase,
+	GPTRealtimeMini:          EncodingO200kBase,
+	GPT4ORealtimePreview:     EncodingO200kBase,
+	GPT4OMiniRealtimePreview: EncodingO200kBase,
+	GPTAudio:                 EncodingO200kBase,
+	GPTAudioMini:             EncodingO200kBase,
+	GPT4OAudioPreview:        EncodingO200kBase,
+	GPT4OMiniAudioPreview:    EncodingO200kBase,
+	O1:                       EncodingO200kBase,
+	O1Pro:                    EncodingO200kBase,
+	O3Pro:                    EncodingO200kBase,
+	O3:                       EncodingO200kBase,
+	O3DeepResearch:           EncodingO200kBase,
+	O4Mini:                   EncodingO200kBase,
+	O4MiniDeepResearch:       EncodingO200kBase,
+	O3Mini:                   EncodingO200kBase,
+	O1Mini:                   EncodingO200kBase,
+	CodexMiniLatest:          EncodingO200kBase,
+	GPT4OMiniSearchPreview:   EncodingO200kBase,
+	GPT4OSearchPreview:       EncodingO200kBase,
+	ComputerUsePreview:       EncodingO200kBase,
+	ChatGPT4OLatest:          EncodingO200kBase,
+	GPT4Turbo20240409:        EncodingCL100kBase,
+	GPT40125Preview:          EncodingCL100kBase,
+	GPT41106Preview:          EncodingCL100kBase,
+	GPT41106VisionPreview:    EncodingCL100kBase,
+	GPT40613:                 EncodingCL100kBase,
+	GPT40314:                 EncodingCL100kBase,
+	GPT432K:                  EncodingCL100kBase,
+	GPT35Turbo:               EncodingCL100kBase,
+	GPT35Turbo0125:           EncodingCL100kBase,
+	GPT35Turbo1106:           EncodingCL100kBase,
+	GPT35Turbo0613:           EncodingCL100kBase,
+	GPT350301:                EncodingCL100kBase,
+	GPT35TurboInstruct:       EncodingCL100kBase,
+	GPT35Turbo16K0613:        EncodingCL100kBase,
+	Davinci002:               EncodingCL100kBase,
+	Babbage002:               EncodingCL100kBase,
+}
+
+// bpeEncoding describes an encoding built from its published ranks
+type bpeEncoding struct {
+	// url is where the ranks are published
+	url     string
+	pattern string
+	special map[string]int
+}
+
+// bpeEncodings are the encodings built here rather than by tiktoken-go, which
+// does not ship o200k_base. Their ranks are loaded with a tiktoken BpeLoader,
+// by default one that caches them in TIKTOKEN_CACHE_DIR.
+var bpeEncodings = map[string]bpeEncoding{
+	EncodingO200kBase: {
+		url:     "https://openaipublic.blob.core.windows.net/encodings/o200k_base.tiktoken",
+		pattern: o200kBasePattern,
+		special: map[string]int{tiktoken.ENDOFTEXT: 199999, tiktoken.ENDOFPROMPT: 200018},
+	},
+	EncodingCL100kBase: {
+		url:     "https://openaipublic.blob.core.windows.net/encodings/cl100k_base.tiktoken",
+		pattern: cl100kBasePattern,
+		special: map[string]int{
+			tiktoken.ENDOFTEXT:   100257,
+			tiktoken.FIM_PREFIX:  100258,
+			tiktoken.FIM_MIDDLE:  100259,
+			tiktoken.FIM_SUFFIX:  100260,
+			tiktoken.ENDOFPROMPT: 100276,
+		},
+	},
+}
+
+// o200kBasePattern splits text into pieces before byte pair encoding
+const o200kBasePattern = `[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+	`|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+	`|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+
+// cl100kBasePattern is cl100k_base's split pattern
+const cl100kBasePattern = `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+
+// encoderRetryDelay is how long a failed encoder load is remembered. Until
+// then callers get the error at once and fall back to word estimates, rather
+// than each waiting on another download.
+const encoderRetryDelay = 30 * time.Second
+
+var (
+	// rankLoader loads the ranks of the encodings in bpeEncodings
+	rankLoader = tiktoken.NewDefaultBpeLoader()
+
+	encodersMu sync.RWMutex
+	// encoders holds the load of every encoding requested so far. Encoders
+	// only read their ranks and are safe for concurrent use.
+	encoders = make(map[string]*encoderLoad)
+)
+
+// encoderLoad is the load of an encoding's encoder, shared by every caller
+// that asks for it while it runs
+type encoderLoad struct {
+	// done is closed when the load has finished
+	done       chan struct{}
+	encoder    *tiktoken.Tiktoken
+	err        error
+	finishedAt time.Time
+}
+
+// expired reports whether the load failed long enough ago to be retried
+func (l *encoderLoad) expired(now time.Time) bool {
+	select {
+	case <-l.done:
+		return l.err != nil && now.Sub(l.finishedAt) >= encoderRetryDelay
+	default:
+		return false
+	}
+}
+
+// getEncoding returns the cached encoder of an encoding, loading it on first
+// use. The load runs outside encodersMu, so it only holds up callers of the
+// same encoding, and a failed load is retried after encoderRetryDelay.
+func getEncoding(name string) (*tiktoken.Tiktoken, error) {
+	encodersMu.RLock()
+	load, ok := encoders[name]
+	encodersMu.RUnlock()
+	if !ok || load.expired(time.Now()) {
+		load = loadEncoder(name)
+	}
+
+	<-load.done
+	return load.encoder, load.err
+}
+
+// loadEncoder loads an encoding's encoder, unless another caller already is
+func loadEncoder(name string) *encoderLoad {
+	encodersMu.Lock()
+	if load, ok := encoders[name]; ok && !load.expired(time.Now()) {
+		encodersMu.Unlock()
+		return load
+	}
+	load := &encoderLoad{done: make(chan struct{})}
+	encoders[name] = load
+	encodersMu.Unlock()
+
+	load.encoder, load.err = newEncoder(name, rankLoader)
+	load.finishedAt = time.Now()
+	close(load.done)
+	return load
+}
+
+// newEncoder builds the encoder of an encoding, loading the ranks of the
+// encodings in bpeEncodings with loader
+func newEncoder(name string, loader tiktoken.BpeLoader) (*tiktoken.Tiktoken, error) {
+	spec, ok := bpeEncodings[name]
+	if !ok {
+		return tiktoken.GetEncoding(name)
+	}
+
+	ranks, err := loader.LoadTiktokenBpe(spec.url)
+	if err != nil {
+		return nil, err
+	}
+	encoding := &tiktoken.Encoding{
+		Name:           name,
+		PatStr:         spec.pattern,
+		MergeableRanks: ranks,
+		SpecialTokens:  spec.special,
+	}
+	bpe, err := tiktoken.NewCoreBPE(encoding.MergeableRanks, encoding.SpecialTokens, encoding.PatStr)
+	if err != nil {
+		return nil, err
+	}
+	specialTokens := make(map[string]any, len(encoding.SpecialTokens))
+	for token := range encoding.SpecialTokens {
+		specialTokens[token] = true
+	}
+	return tiktoken.NewTiktoken(bpe, encoding, specialTokens), nil
+}
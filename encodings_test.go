@@ -0,0 +1,455 @@
+package paygent
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dlclark/regexp2"
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/sirupsen/logrus"
+)
+
+func TestOpenAIModelEncodings(t *testing.T) {
+	for model, provider := range modelProviders {
+		if provider != OpenAI {
+			continue
+		}
+		if _, ok := openAIModelEncodings[model]; !ok {
+			t.Errorf("OpenAI model %q has no encoding", model)
+		}
+	}
+
+	client := NewClient("test-api-key")
+	for _, model := range []string{GPT5, GPT41, GPT4O, O3, O4Mini} {
+		if info := client.TokenizerForModel(model); info.Encoding != EncodingO200kBase || !info.Exact {
+			t.Errorf("Expected %s to use o200k_base, got %+v", model, info)
+		}
+	}
+	if info := client.TokenizerForModel("gpt-4o-2024-08-06"); info.Encoding != EncodingO200kBase {
+		t.Errorf("Expected snapshot to use o200k_base, got %+v", info)
+	}
+}
+
+func TestO200kBasePattern(t *testing.T) {
+	if _, err := tiktoken.NewCoreBPE(map[string]int{"a": 0}, map[string]int{tiktoken.ENDOFTEXT: 1}, o200kBasePattern); err != nil {
+		t.Fatalf("o200k_base pattern does not compile: %v", err)
+	}
+}
+
+// updateFixtures regenerates the rank fixtures from the published ranks:
+//
+//	go test -run TestUpdateRankFixtures -update-fixtures
+var updateFixtures = flag.Bool("update-fixtures", false, "regenerate testdata/tiktoken from the published ranks")
+
+// fixtureTexts are the texts the rank fixtures encode as the published ranks
+// do. Their tokens differ between the two encodings.
+var fixtureTexts = map[string]map[string][]int{
+	EncodingO200kBase: {
+		"hello world": {24912, 2375},
+		"2 + 2 = 4":   {17, 659, 220, 17, 314, 220, 19},
+	},
+	EncodingCL100kBase: {
+		"hello world": {15339, 1917},
+		"2 + 2 = 4":   {17, 489, 220, 17, 284, 220, 19},
+	},
+}
+
+// fixtureRanks loads the rank files in testdata/tiktoken instead of the
+// published ones. They are trimmed from the published ranks to every byte and
+// the tokens fixtureTexts are encoded with, keeping their ranks.
+type fixtureRanks struct{}
+
+func (fixtureRanks) LoadTiktokenBpe(url string) (map[string]int, error) {
+	return tiktoken.NewDefaultBpeLoader().LoadTiktokenBpe(filepath.Join("testdata", "tiktoken", path.Base(url)))
+}
+
+func TestEncodingTokenCounts(t *testing.T) {
+	// The default loader caches every file it reads
+	t.Setenv("TIKTOKEN_CACHE_DIR", t.TempDir())
+
+	for name, texts := range fixtureTexts {
+		encoding, err := newEncoder(name, fixtureRanks{})
+		if err != nil {
+			t.Fatalf("newEncoder(%s) error = %v", name, err)
+		}
+		for text, expected := range texts {
+			if got := encoding.Encode(text, nil, nil); !reflect.DeepEqual(got, expected) {
+				t.Errorf("%s encodes %q as %v, want %v", name, text, got, expected)
+			}
+		}
+
+		special := map[string]int{EncodingO200kBase: 199999, EncodingCL100kBase: 100257}[name]
+		if got := encoding.Encode(tiktoken.ENDOFTEXT, []string{"all"}, nil); !reflect.DeepEqual(got, []int{special}) {
+			t.Errorf("%s encodes %s as %v, want [%d]", name, tiktoken.ENDOFTEXT, got, special)
+		}
+	}
+}
+
+func TestEncodingPatterns(t *testing.T) {
+	const text = "HelloWorld it's 2025/10"
+	tests := []struct {
+		encoding string
+		expected []string
+	}{
+		{encoding: EncodingO200kBase, expected: []string{"Hello", "World", " it's", " ", "202", "5", "/", "10"}},
+		{encoding: EncodingCL100kBase, expected: []string{"HelloWorld", " it", "'s", " ", "202", "5", "/", "10"}},
+	}
+
+	for _, tt := range tests {
+		if got := splitPieces(t, bpeEncodings[tt.encoding].pattern, text); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s splits %q into %q, want %q", tt.encoding, text, got, tt.expected)
+		}
+	}
+}
+
+// splitPieces splits text with an encoding's pattern, as tiktoken does before merging
+func splitPieces(tb testing.TB, pattern, text string) []string {
+	re, err := regexp2.Compile(pattern, regexp2.None)
+	if err != nil {
+		tb.Fatalf("pattern does not compile: %v", err)
+	}
+	var pieces []string
+	for match, _ := re.FindStringMatch(text); match != nil; match, _ = re.FindNextMatch(match) {
+		pieces = append(pieces, match.String())
+	}
+	return pieces
+}
+
+// trimRanks returns the part of ranks that encodes texts as the whole ranks do:
+// every byte, each piece that is a token and the tokens the merges of the
+// other pieces pass through
+func trimRanks(tb testing.TB, ranks map[string]int, pattern string, texts []string) map[string]int {
+	trimmed := make(map[string]int)
+	for token, rank := range ranks {
+		if len(token) == 1 {
+			trimmed[token] = rank
+		}
+	}
+	for _, text := range texts {
+		for _, piece := range splitPieces(tb, pattern, text) {
+			if rank, ok := ranks[piece]; ok {
+				trimmed[piece] = rank
+				continue
+			}
+			parts := make([]string, 0, len(piece))
+			for i := 0; i < len(piece); i++ {
+				parts = append(parts, piece[i:i+1])
+			}
+			for len(parts) > 1 {
+				best := -1
+				for i := 0; i+1 < len(parts); i++ {
+					if rank, ok := ranks[parts[i]+parts[i+1]]; ok && (best < 0 || rank < ranks[parts[best]+parts[best+1]]) {
+						best = i
+					}
+				}
+				if best < 0 {
+					break
+				}
+				merged := parts[best] + parts[best+1]
+				trimmed[merged] = ranks[merged]
+				parts = append(parts[:best+1], parts[best+2:]...)
+				parts[best] = merged
+			}
+		}
+	}
+	return trimmed
+}
+
+func TestTrimRanks(t *testing.T) {
+	ranks := syntheticRanks()
+	texts := []string{"the quick brown fox", "jumps over the lazy dog"}
+	trimmed := trimRanks(t, ranks, o200kBasePattern, texts)
+	if len(trimmed) >= len(ranks) {
+		t.Fatalf("Expected fewer ranks after trimming, got %d of %d", len(trimmed), len(ranks))
+	}
+
+	special := map[string]int{tiktoken.ENDOFTEXT: len(ranks)}
+	encoder := func(mergeable map[string]int) *tiktoken.Tiktoken {
+		bpe, err := tiktoken.NewCoreBPE(mergeable, special, o200kBasePattern)
+		if err != nil {
+			t.Fatalf("NewCoreBPE() error = %v", err)
+		}
+		return tiktoken.NewTiktoken(bpe, &tiktoken.Encoding{PatStr: o200kBasePattern, MergeableRanks: mergeable, SpecialTokens: special}, nil)
+	}
+	full, part := encoder(ranks), encoder(trimmed)
+	for _, text := range texts {
+		want, got := full.Encode(text, nil, nil), part.Encode(text, nil, nil)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Trimmed ranks encode %q as %v, want %v", text, got, want)
+		}
+	}
+}
+
+// TestUpdateRankFixtures rewrites testdata/tiktoken with the published ranks
+// trimmed to fixtureTexts. It needs network access and runs only with -update-fixtures.
+func TestUpdateRankFixtures(t *testing.T) {
+	if !*updateFixtures {
+		t.Skip("run with -update-fixtures to regenerate the rank fixtures")
+	}
+
+	for name, spec := range bpeEncodings {
+		ranks, err := tiktoken.NewDefaultBpeLoader().LoadTiktokenBpe(spec.url)
+		if err != nil {
+			t.Fatalf("Failed to load %s ranks: %v", name, err)
+		}
+		var texts []string
+		for text := range fixtureTexts[name] {
+			texts = append(texts, text)
+		}
+		trimmed := trimRanks(t, ranks, spec.pattern, texts)
+
+		tokens := make([]string, 0, len(trimmed))
+		for token := range trimmed {
+			tokens = append(tokens, token)
+		}
+		sort.Slice(tokens, func(i, j int) bool { return trimmed[tokens[i]] < trimmed[tokens[j]] })
+		var file strings.Builder
+		for _, token := range tokens {
+			fmt.Fprintf(&file, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), trimmed[token])
+		}
+		if err := os.WriteFile(filepath.Join("testdata", "tiktoken", name+".tiktoken"), []byte(file.String()), 0o644); err != nil {
+			t.Fatalf("Failed to write %s fixture: %v", name, err)
+		}
+	}
+}
+
+// TestPublishedEncodingTokenCounts checks texts the fixtures do not cover
+// against the published ranks, when they can be downloaded
+func TestPublishedEncodingTokenCounts(t *testing.T) {
+	tests := []struct {
+		encoding string
+		text     string
+		expected int
+	}{
+		{encoding: EncodingCL100kBase, text: "tiktoken is great!", expected: 6},
+		{encoding: EncodingO200kBase, text: "お誕生日おめでとう", expected: 8},
+		{encoding: EncodingCL100kBase, text: "お誕生日おめでとう", expected: 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.encoding+" "+tt.text, func(t *testing.T) {
+			encoding, err := getEncoding(tt.encoding)
+			if err != nil {
+				t.Skipf("%s ranks unavailable: %v", tt.encoding, err)
+			}
+			if got := len(encoding.Encode(tt.text, nil, nil)); got != tt.expected {
+				t.Errorf("%s counts %d tokens in %q, want %d", tt.encoding, got, tt.text, tt.expected)
+			}
+		})
+	}
+}
+
+// failingRanks fails every load once release is closed, counting the loads
+type failingRanks struct {
+	release chan struct{}
+	loads   int32
+}
+
+func (l *failingRanks) LoadTiktokenBpe(url string) (map[string]int, error) {
+	atomic.AddInt32(&l.loads, 1)
+	<-l.release
+	return nil, errors.New("offline")
+}
+
+func TestEncoderLoad(t *testing.T) {
+	loader := &failingRanks{release: make(chan struct{})}
+	previous := rankLoader
+	rankLoader = loader
+	encodersMu.Lock()
+	delete(encoders, EncodingO200kBase)
+	encodersMu.Unlock()
+	t.Cleanup(func() {
+		rankLoader = previous
+		encodersMu.Lock()
+		delete(encoders, EncodingO200kBase)
+		encodersMu.Unlock()
+	})
+	cacheEncoder(t, "cached_base", &tiktoken.Tiktoken{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := getEncoding(EncodingO200kBase); err == nil {
+				t.Error("Expected the load to fail")
+			}
+		}()
+	}
+	for atomic.LoadInt32(&loader.loads) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Other encodings are served while the load runs
+	if _, err := getEncoding("cached_base"); err != nil {
+		t.Errorf("Expected the cached encoder during another load, got %v", err)
+	}
+	close(loader.release)
+	wg.Wait()
+	if loads := atomic.LoadInt32(&loader.loads); loads != 1 {
+		t.Errorf("Expected concurrent callers to share one load, got %d", loads)
+	}
+
+	// The failure is remembered until encoderRetryDelay has passed
+	if _, err := getEncoding(EncodingO200kBase); err == nil || atomic.LoadInt32(&loader.loads) != 1 {
+		t.Errorf("Expected the cached failure without another load, got %v after %d loads", err, loader.loads)
+	}
+	encodersMu.Lock()
+	encoders[EncodingO200kBase].finishedAt = time.Now().Add(-encoderRetryDelay)
+	encodersMu.Unlock()
+	if _, err := getEncoding(EncodingO200kBase); err == nil || atomic.LoadInt32(&loader.loads) != 2 {
+		t.Errorf("Expected the load to be retried after encoderRetryDelay, got %v after %d loads", err, loader.loads)
+	}
+}
+
+func TestEncoderCache(t *testing.T) {
+	encoding, err := getEncoding(EncodingCL100kBase)
+	if err != nil {
+		t.Skipf("cl100k_base ranks unavailable: %v", err)
+	}
+	if again, _ := getEncoding(EncodingCL100kBase); again != encoding {
+		t.Error("Expected the cached encoder to be reused")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := len(encoding.Encode("tiktoken is great!", nil, nil)); got != 6 {
+				t.Errorf("Expected 6 tokens, got %d", got)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// syntheticEncoding builds an encoding from generated ranks, so benchmarks
+// run without downloading real ones
+func syntheticEncoding(b *testing.B) *tiktoken.Encoding {
+	ranks := syntheticRanks()
+	encoding := &tiktoken.Encoding{
+		Name:           "synthetic_base",
+		PatStr:         o200kBasePattern,
+		MergeableRanks: ranks,
+		SpecialTokens:  map[string]int{tiktoken.ENDOFTEXT: len(ranks)},
+	}
+
+	bpe, err := tiktoken.NewCoreBPE(encoding.MergeableRanks, encoding.SpecialTokens, encoding.PatStr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	cacheEncoder(b, encoding.Name, tiktoken.NewTiktoken(bpe, encoding, map[string]any{tiktoken.ENDOFTEXT: true}))
+	return encoding
+}
+
+// cacheEncoder caches an encoder as if it had been loaded, until the test ends
+func cacheEncoder(tb testing.TB, name string, encoder *tiktoken.Tiktoken) {
+	done := make(chan struct{})
+	close(done)
+	encodersMu.Lock()
+	encoders[name] = &encoderLoad{done: done, encoder: encoder, finishedAt: time.Now()}
+	encodersMu.Unlock()
+	tb.Cleanup(func() {
+		encodersMu.Lock()
+		delete(encoders, name)
+		encodersMu.Unlock()
+	})
+}
+
+// syntheticRanks returns every byte and every pair and triple of lowercase
+// letters and spaces
+func syntheticRanks() map[string]int {
+	ranks := make(map[string]int)
+	for i := 0; i < 256; i++ {
+		ranks[string([]byte{byte(i)})] = len(ranks)
+	}
+	letters := "abcdefghijklmnopqrstuvwxyz "
+	for _, x := range letters {
+		for _, y := range letters {
+			ranks[string([]rune{x, y})] = len(ranks)
+			for _, z := range letters {
+				ranks[string([]rune{x, y, z})] = len(ranks)
+			}
+		}
+	}
+	return ranks
+}
+
+const benchmarkText = "The quick brown fox jumps over the lazy dog while the cat watches from the window. "
+
+// BenchmarkCountTokensUncached builds the encoder on every call, as the
+// client did before encoders were cached
+func BenchmarkCountTokensUncached(b *testing.B) {
+	encoding := syntheticEncoding(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bpe, err := tiktoken.NewCoreBPE(encoding.MergeableRanks, encoding.SpecialTokens, encoding.PatStr)
+		if err != nil {
+			b.Fatal(err)
+		}
+		tiktoken.NewTiktoken(bpe, encoding, nil).Encode(benchmarkText, nil, nil)
+	}
+}
+
+func BenchmarkCountTokensCached(b *testing.B) {
+	encoding := syntheticEncoding(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		encoder, err := getEncoding(encoding.Name)
+		if err != nil {
+			b.Fatal(err)
+		}
+		encoder.Encode(benchmarkText, nil, nil)
+	}
+}
+
+func BenchmarkCountTokensCachedParallel(b *testing.B) {
+	encoding := syntheticEncoding(b)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			encoder, err := getEncoding(encoding.Name)
+			if err != nil {
+				b.Fatal(err)
+			}
+			encoder.Encode(benchmarkText, nil, nil)
+		}
+	})
+}
+
+func BenchmarkBuildUsageRequestFromStrings(b *testing.B) {
+	encoding := syntheticEncoding(b)
+	tokenizer, err := NewTiktokenTokenizer(encoding.Name)
+	if err != nil {
+		b.Fatal(err)
+	}
+	client := NewClient("test-api-key")
+	client.SetLogLevel(logrus.ErrorLevel)
+	client.SetModelTokenizer(GPT4O, tokenizer)
+	usageData := UsageDataWithStrings{
+		ServiceProvider: OpenAI,
+		Model:           GPT4O,
+		PromptString:    strings.Repeat(benchmarkText, 50),
+		OutputString:    strings.Repeat(benchmarkText, 10),
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.BuildUsageRequestFromStrings("agent", "customer", "bench", usageData); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
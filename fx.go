@@ -0,0 +1,310 @@
+package paygent
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrNoFXRate is returned when a cost cannot be converted to the billing currency
+var ErrNoFXRate = errors.New("no exchange rate")
+
+// FXRate is an exchange rate: one unit of From buys Rate units of To
+type FXRate struct {
+	From string  `json:"from"`
+	To   string  `json:"to"`
+	Rate float64 `json:"rate"`
+	// Source names where the rate came from, e.g. "ECB"
+	Source string `json:"source,omitempty"`
+	// AsOf is when the rate was published, nil when the provider does not say
+	AsOf *time.Time `json:"asOf,omitempty"`
+}
+
+// FXRateProvider returns exchange rates for converting costs from the currency
+// a model is priced in to a customer's billing currency.
+// Implementations must be safe for concurrent use.
+type FXRateProvider interface {
+	// Rate returns the rate from one currency to another in force at the given time.
+	// It returns an error wrapping ErrNoFXRate when it has no such rate.
+	Rate(from, to string, at time.Time) (FXRate, error)
+}
+
+// StaticFXRates is an FXRateProvider backed by a fixed table of rates, keyed
+// "FROM/TO" (e.g. "USD/EUR"). Inverse rates are derived when only the opposite
+// direction is listed.
+type StaticFXRates struct {
+	mu     sync.RWMutex
+	rates  map[string]float64
+	source string
+	asOf   time.Time
+}
+
+// NewStaticFXRates creates a static rate table from rates keyed "FROM/TO"
+func NewStaticFXRates(rates map[string]float64) (*StaticFXRates, error) {
+	table := &StaticFXRates{rates: make(map[string]float64, len(rates))}
+	for pair, rate := range rates {
+		from, to, err := parseCurrencyPair(pair)
+		if err != nil {
+			return nil, err
+		}
+		if err := table.Set(from, to, rate); err != nil {
+			return nil, err
+		}
+	}
+	return table, nil
+}
+
+// Set adds or replaces the rate from one currency to another
+func (s *StaticFXRates) Set(from, to string, rate float64) error {
+	from, to = normalizeCurrency(from), normalizeCurrency(to)
+	if !validCurrency(from) || !validCurrency(to) {
+		return fmt.Errorf("invalid currency pair %s/%s", from, to)
+	}
+	if !validRate(rate) {
+		return fmt.Errorf("invalid exchange rate %v for %s/%s, must be a positive number", rate, from, to)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rates[from+"/"+to] = rate
+	return nil
+}
+
+// Rate returns the listed rate, or the inverse of the opposite rate
+func (s *StaticFXRates) Rate(from, to string, at time.Time) (FXRate, error) {
+	from, to = normalizeCurrency(from), normalizeCurrency(to)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := FXRate{From: from, To: to, Source: s.source}
+	if !s.asOf.IsZero() {
+		asOf := s.asOf
+		result.AsOf = &asOf
+	}
+	if from == to {
+		result.Rate = 1
+		return result, nil
+	}
+	if rate, ok := s.rates[from+"/"+to]; ok {
+		result.Rate = rate
+		return result, nil
+	}
+	if rate, ok := s.rates[to+"/"+from]; ok {
+		result.Rate = 1 / rate
+		return result, nil
+	}
+	return FXRate{}, fmt.Errorf("%w from %s to %s", ErrNoFXRate, from, to)
+}
+
+// fxRatesFile is the file format read by LoadFXRatesFile
+type fxRatesFile struct {
+	Source string             `json:"source,omitempty" yaml:"source,omitempty"`
+	AsOf   string             `json:"as_of,omitempty" yaml:"as_of,omitempty"`
+	Rates  map[string]float64 `json:"rates" yaml:"rates"`
+}
+
+// LoadFXRatesFile reads a static rate table from a JSON or YAML file. The
+// format is taken from the extension: .json, .yaml or .yml.
+//
+// Example (YAML):
+//
+//	source: ECB
+//	as_of: 2025-10-01
+//	rates:
+//	  USD/EUR: 0.92
+//	  USD/INR: 83.2
+func LoadFXRatesFile(path string) (*StaticFXRates, error) {
+	format, err := priceSheetFormatFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read exchange rates: %w", err)
+	}
+
+	var file fxRatesFile
+	switch format {
+	case PriceSheetJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&file)
+	case PriceSheetYAML:
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode exchange rates: %w", err)
+	}
+
+	table, err := NewStaticFXRates(file.Rates)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exchange rates: %w", err)
+	}
+	table.source = file.Source
+	if file.AsOf != "" {
+		if table.asOf, err = parseEffectiveDate(file.AsOf); err != nil {
+			return nil, fmt.Errorf("invalid exchange rates: %w", err)
+		}
+	}
+	return table, nil
+}
+
+// SetFXRateProvider sets the exchange rates used to convert costs to billing currencies
+func (c *Client) SetFXRateProvider(provider FXRateProvider) {
+	c.fxRates = provider
+}
+
+// SetBillingCurrency sets the currency usage is reported in. Costs priced in
+// another currency are converted with the FX rate provider. The default is to
+// report costs in the currency the model is priced in.
+func (c *Client) SetBillingCurrency(currency string) error {
+	currency = normalizeCurrency(currency)
+	if currency != "" && !validCurrency(currency) {
+		return fmt.Errorf("invalid currency %q, expected an ISO 4217 code", currency)
+	}
+
+	c.billingMu.Lock()
+	defer c.billingMu.Unlock()
+
+	c.billingCurrency = currency
+	return nil
+}
+
+// SetCustomerBillingCurrency sets the currency usage for one customer is
+// reported in, overriding SetBillingCurrency. An empty currency removes the override.
+func (c *Client) SetCustomerBillingCurrency(customerID, currency string) error {
+	currency = normalizeCurrency(currency)
+	if currency != "" && !validCurrency(currency) {
+		return fmt.Errorf("invalid currency %q, expected an ISO 4217 code", currency)
+	}
+
+	c.billingMu.Lock()
+	defer c.billingMu.Unlock()
+
+	if currency == "" {
+		delete(c.customerCurrencies, customerID)
+		return nil
+	}
+	c.customerCurrencies[customerID] = currency
+	return nil
+}
+
+// billingCurrencyFor returns the currency to report a customer's usage in, or
+// an empty string to keep the pricing currency
+func (c *Client) billingCurrencyFor(customerID string) string {
+	c.billingMu.RLock()
+	defer c.billingMu.RUnlock()
+
+	if currency, ok := c.customerCurrencies[customerID]; ok {
+		return currency
+	}
+	return c.billingCurrency
+}
+
+// convertCost converts a cost from the currency its model is priced in to the
+// customer's billing currency at the rate in force when the usage occurred.
+// Usage left for the server to price is only tagged with the billing currency.
+func (c *Client) convertCost(cost costBreakdown, customerID string, at time.Time) (costBreakdown, error) {
+	cost.ProviderCurrency = currencyOrUSD(cost.Pricing.Currency)
+	cost.Currency = cost.ProviderCurrency
+
+	target := c.billingCurrencyFor(customerID)
+	if target == "" || target == cost.ProviderCurrency {
+		return cost, nil
+	}
+	if cost.Source == PricingSourceServer {
+		// Nothing to convert: the server prices the event in the billing currency
+		cost.Currency = target
+		return cost, nil
+	}
+	if c.fxRates == nil {
+		return costBreakdown{}, fmt.Errorf("%w from %s to %s: no FX rate provider configured", ErrNoFXRate, cost.ProviderCurrency, target)
+	}
+
+	rate, err := c.fxRates.Rate(cost.ProviderCurrency, target, at)
+	if err != nil {
+		return costBreakdown{}, err
+	}
+	if !validRate(rate.Rate) {
+		return costBreakdown{}, fmt.Errorf("%w from %s to %s: invalid rate %v", ErrNoFXRate, cost.ProviderCurrency, target, rate.Rate)
+	}
+
+	providerAmount := cost.Amount
+	cost.ProviderAmount = &providerAmount
+	cost.FX = &rate
+	cost.Currency = target
+	if cost.Amount, err = c.roundAmount(cost.converted(cost.Total)); err != nil {
+		return costBreakdown{}, fmt.Errorf("cost in %s: %w", target, err)
+	}
+	return cost, nil
+}
+
+// converted returns an exact provider-currency cost in the billing currency
+func (b costBreakdown) converted(r *big.Rat) *big.Rat {
+	if b.FX == nil {
+		return r
+	}
+	return new(big.Rat).Mul(r, ratFromFloat(b.FX.Rate))
+}
+
+// parseCurrencyPair splits a "FROM/TO" currency pair
+func parseCurrencyPair(pair string) (string, string, error) {
+	from, to, ok := strings.Cut(pair, "/")
+	from, to = normalizeCurrency(from), normalizeCurrency(to)
+	if !ok || !validCurrency(from) || !validCurrency(to) {
+		return "", "", fmt.Errorf("invalid currency pair %q, expected FROM/TO such as USD/EUR", pair)
+	}
+	return from, to, nil
+}
+
+// normalizeCurrency upper-cases a currency code
+func normalizeCurrency(currency string) string {
+	return strings.ToUpper(strings.TrimSpace(currency))
+}
+
+// validCurrency reports whether currency looks like an ISO 4217 code
+func validCurrency(currency string) bool {
+	if len(currency) != 3 {
+		return false
+	}
+	for _, r := range currency {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// currencyOrUSD returns currency, or USD when it is empty
+func currencyOrUSD(currency string) string {
+	if currency == "" {
+		return "USD"
+	}
+	return normalizeCurrency(currency)
+}
+
+// validRate reports whether rate is a finite, positive exchange rate
+func validRate(rate float64) bool {
+	return rate > 0 && !math.IsInf(rate, 0) && !math.IsNaN(rate)
+}
+
+// providerCurrencyIfConverted returns the provider currency when the amount was converted
+func (b costBreakdown) providerCurrencyIfConverted() string {
+	if b.FX == nil {
+		return ""
+	}
+	return b.ProviderCurrency
+}
@@ -0,0 +1,187 @@
+package paygent
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStaticFXRates(t *testing.T) {
+	rates, err := NewStaticFXRates(map[string]float64{"usd/eur": 0.8})
+	if err != nil {
+		t.Fatalf("NewStaticFXRates() error = %v", err)
+	}
+
+	tests := []struct {
+		from, to string
+		expected float64
+	}{
+		{from: "USD", to: "EUR", expected: 0.8},
+		{from: "EUR", to: "USD", expected: 1.25},
+		{from: "EUR", to: "eur", expected: 1},
+	}
+	for _, tt := range tests {
+		rate, err := rates.Rate(tt.from, tt.to, time.Now())
+		if err != nil {
+			t.Fatalf("Rate(%s, %s) error = %v", tt.from, tt.to, err)
+		}
+		if rate.Rate != tt.expected {
+			t.Errorf("Rate(%s, %s) = %v, want %v", tt.from, tt.to, rate.Rate, tt.expected)
+		}
+	}
+
+	if _, err := rates.Rate("USD", "JPY", time.Now()); !errors.Is(err, ErrNoFXRate) {
+		t.Errorf("Expected ErrNoFXRate, got %v", err)
+	}
+	if _, err := NewStaticFXRates(map[string]float64{"USD-EUR": 0.8}); err == nil {
+		t.Error("Expected error for malformed currency pair")
+	}
+	if err := rates.Set("USD", "GBP", 0); err == nil {
+		t.Error("Expected error for zero rate")
+	}
+}
+
+func TestLoadFXRatesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.yaml")
+	content := "source: ECB\nas_of: 2025-10-01\nrates:\n  USD/EUR: 0.92\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	rates, err := LoadFXRatesFile(path)
+	if err != nil {
+		t.Fatalf("LoadFXRatesFile() error = %v", err)
+	}
+	rate, err := rates.Rate("USD", "EUR", time.Now())
+	if err != nil {
+		t.Fatalf("Rate() error = %v", err)
+	}
+	if rate.Rate != 0.92 || rate.Source != "ECB" || rate.AsOf == nil || !rate.AsOf.Equal(time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected rate %+v", rate)
+	}
+}
+
+func TestStaticFXRateJSON(t *testing.T) {
+	rates, _ := NewStaticFXRates(map[string]float64{"USD/EUR": 0.9})
+	rate, err := rates.Rate("USD", "EUR", time.Now())
+	if err != nil {
+		t.Fatalf("Rate() error = %v", err)
+	}
+
+	data, err := json.Marshal(rate)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if expected := `{"from":"USD","to":"EUR","rate":0.9}`; string(data) != expected {
+		t.Errorf("Marshal() = %s, want %s", data, expected)
+	}
+}
+
+func TestBuildUsageRequestCurrency(t *testing.T) {
+	client := NewClient("test-api-key")
+	rates, _ := NewStaticFXRates(map[string]float64{"USD/EUR": 0.9})
+	client.SetFXRateProvider(rates)
+	if err := client.SetCustomerBillingCurrency("eu-customer", "eur"); err != nil {
+		t.Fatalf("SetCustomerBillingCurrency() error = %v", err)
+	}
+
+	// 1000 prompt tokens at $0.0025 per 1000 tokens
+	usageData := UsageData{Model: GPT4O, PromptTokens: 1000}
+
+	request, err := client.BuildUsageRequest("agent", "eu-customer", "indicator", usageData)
+	if err != nil {
+		t.Fatalf("BuildUsageRequest() error = %v", err)
+	}
+	if request.Amount != MoneyFromFloat(0.00225) || request.Currency != "EUR" {
+		t.Errorf("Expected 0.00225 EUR, got %s %s", request.Amount, request.Currency)
+	}
+	if request.ProviderAmount == nil || *request.ProviderAmount != MoneyFromFloat(0.0025) || request.ProviderCurrency != "USD" {
+		t.Errorf("Expected provider amount 0.0025 USD, got %v %s", request.ProviderAmount, request.ProviderCurrency)
+	}
+	if request.FXRate == nil || request.FXRate.Rate != 0.9 {
+		t.Errorf("Expected FX rate 0.9 to be recorded, got %+v", request.FXRate)
+	}
+
+	// Other customers are billed in the pricing currency
+	request, err = client.BuildUsageRequest("agent", "us-customer", "indicator", usageData)
+	if err != nil {
+		t.Fatalf("BuildUsageRequest() error = %v", err)
+	}
+	if request.Currency != "USD" || request.ProviderAmount != nil || request.FXRate != nil {
+		t.Errorf("Expected unconverted USD request, got %+v", request)
+	}
+}
+
+func TestBuildUsageRequestMissingFXRate(t *testing.T) {
+	client := NewClient("test-api-key")
+	if err := client.SetBillingCurrency("GBP"); err != nil {
+		t.Fatalf("SetBillingCurrency() error = %v", err)
+	}
+
+	usageData := UsageData{Model: GPT4O, PromptTokens: 1000}
+	if _, err := client.BuildUsageRequest("agent", "customer", "indicator", usageData); !errors.Is(err, ErrNoFXRate) {
+		t.Errorf("Expected ErrNoFXRate without a provider, got %v", err)
+	}
+
+	rates, _ := NewStaticFXRates(map[string]float64{"USD/EUR": 0.9})
+	client.SetFXRateProvider(rates)
+	if _, err := client.BuildUsageRequest("agent", "customer", "indicator", usageData); !errors.Is(err, ErrNoFXRate) {
+		t.Errorf("Expected ErrNoFXRate for a missing rate, got %v", err)
+	}
+
+	if err := client.SetBillingCurrency("pounds"); err == nil {
+		t.Error("Expected error for invalid currency code")
+	}
+}
+
+func TestBuildUsageRequestServerPricedCurrency(t *testing.T) {
+	client := NewClient("test-api-key")
+	client.SetUnknownModelPolicy(UnknownModelServerPriced)
+	if err := client.SetBillingCurrency("EUR"); err != nil {
+		t.Fatalf("SetBillingCurrency() error = %v", err)
+	}
+
+	// No FX rate provider is needed for usage the server prices
+	request, err := client.BuildUsageRequest("agent", "customer", "indicator", UsageData{Model: "unknown-model", PromptTokens: 1000})
+	if err != nil {
+		t.Fatalf("BuildUsageRequest() error = %v", err)
+	}
+	if !request.ServerPricing || !request.Amount.IsZero() || request.Currency != "EUR" || request.FXRate != nil || request.ProviderAmount != nil {
+		t.Errorf("Expected unconverted server-priced EUR request, got %+v", request)
+	}
+}
+
+func TestPriceSheetCurrency(t *testing.T) {
+	client := NewClient("test-api-key")
+	sheet := `{"currency":"eur","models":[{"model":"eu-model","prompt_tokens_cost":1}]}`
+	if err := client.LoadPriceSheet(strings.NewReader(sheet), PriceSheetJSON); err != nil {
+		t.Fatalf("LoadPriceSheet() error = %v", err)
+	}
+
+	// Billed in the currency the model is priced in by default
+	request, err := client.BuildUsageRequest("agent", "customer", "indicator", UsageData{Model: "eu-model", PromptTokens: 1000})
+	if err != nil {
+		t.Fatalf("BuildUsageRequest() error = %v", err)
+	}
+	if request.Amount != MoneyFromFloat(1) || request.Currency != "EUR" {
+		t.Errorf("Expected 1 EUR, got %s %s", request.Amount, request.Currency)
+	}
+
+	// Converted with the inverse of the listed rate
+	rates, _ := NewStaticFXRates(map[string]float64{"USD/EUR": 0.8})
+	client.SetFXRateProvider(rates)
+	if err := client.SetBillingCurrency("USD"); err != nil {
+		t.Fatalf("SetBillingCurrency() error = %v", err)
+	}
+	request, err = client.BuildUsageRequest("agent", "customer", "indicator", UsageData{Model: "eu-model", PromptTokens: 1000})
+	if err != nil {
+		t.Fatalf("BuildUsageRequest() error = %v", err)
+	}
+	if request.Amount != MoneyFromFloat(1.25) || request.Currency != "USD" || request.ProviderCurrency != "EUR" {
+		t.Errorf("Expected 1.25 USD converted from EUR, got %s %s from %s", request.Amount, request.Currency, request.ProviderCurrency)
+	}
+}
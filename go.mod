@@ -3,12 +3,13 @@ module github.com/paygent/paygent-sdk-go
 go 1.21
 
 require (
+	github.com/dlclark/regexp2 v1.10.0
+	github.com/google/uuid v1.3.0
 	github.com/pkoukk/tiktoken-go v0.1.6
 	github.com/sirupsen/logrus v1.9.3
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/dlclark/regexp2 v1.10.0 // indirect
-	github.com/google/uuid v1.3.0 // indirect
 	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
 )
@@ -0,0 +1,431 @@
+package paygent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/dlclark/regexp2"
+)
+
+// byteLevelPattern is the pre-tokenization pattern of byte-level BPE when it splits text itself
+const byteLevelPattern = `'s|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+(?!\S)|\s+`
+
+// hfTokenizerFile is the subset of a Hugging Face tokenizer.json used to count tokens
+type hfTokenizerFile struct {
+	AddedTokens []struct {
+		Content string `json:"content"`
+	} `json:"added_tokens"`
+	Normalizer   *hfComponent `json:"normalizer"`
+	PreTokenizer *hfComponent `json:"pre_tokenizer"`
+	Model        struct {
+		Type         string            `json:"type"`
+		Vocab        map[string]int    `json:"vocab"`
+		Merges       []json.RawMessage `json:"merges"`
+		ByteFallback bool              `json:"byte_fallback"`
+		IgnoreMerges bool              `json:"ignore_merges"`
+	} `json:"model"`
+}
+
+// hfComponent is a normalizer or pre-tokenizer of a tokenizer.json
+type hfComponent struct {
+	Type string `json:"type"`
+
+	// Sequence
+	Normalizers   []hfComponent `json:"normalizers"`
+	Pretokenizers []hfComponent `json:"pretokenizers"`
+
+	// Prepend, Replace and Split
+	Prepend string `json:"prepend"`
+	Pattern struct {
+		String *string `json:"String"`
+		Regex  *string `json:"Regex"`
+	} `json:"pattern"`
+	Content  string `json:"content"`
+	Behavior string `json:"behavior"`
+	Invert   bool   `json:"invert"`
+
+	// ByteLevel and Metaspace
+	AddPrefixSpace *bool  `json:"add_prefix_space"`
+	UseRegex       *bool  `json:"use_regex"`
+	Replacement    string `json:"replacement"`
+	PrependScheme  string `json:"prepend_scheme"`
+	Split          *bool  `json:"split"`
+
+	// Digits
+	IndividualDigits bool `json:"individual_digits"`
+
+	// Strip
+	StripLeft  bool `json:"strip_left"`
+	StripRight bool `json:"strip_right"`
+}
+
+// hfTokenizer counts tokens with a byte pair encoding vocabulary from a
+// Hugging Face tokenizer.json
+type hfTokenizer struct {
+	vocab        map[string]int
+	merges       map[[2]string]int
+	byteLevel    bool
+	byteFallback bool
+	ignoreMerges bool
+	// addedTokens are matched before normalization, longest first
+	addedTokens []string
+	normalizers []func(string) string
+	// preTokenizers split the normalized text into words
+	preTokenizers []func([]string) []string
+}
+
+// LoadHFTokenizerFile loads a Hugging Face tokenizer.json, such as those of
+// Llama 3 and 4, Mistral and DeepSeek models, for offline token counting.
+// Only BPE models are supported.
+func LoadHFTokenizerFile(path string) (Tokenizer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tokenizer: %w", err)
+	}
+	defer file.Close()
+
+	return ParseHFTokenizer(file)
+}
+
+// ParseHFTokenizer reads a Hugging Face tokenizer.json
+func ParseHFTokenizer(r io.Reader) (Tokenizer, error) {
+	var file hfTokenizerFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to decode tokenizer: %w", err)
+	}
+	if file.Model.Type != "BPE" {
+		return nil, fmt.Errorf("unsupported tokenizer model %q, only BPE is supported", file.Model.Type)
+	}
+	if len(file.Model.Vocab) == 0 {
+		return nil, fmt.Errorf("tokenizer has no vocabulary")
+	}
+
+	t := &hfTokenizer{
+		vocab:        file.Model.Vocab,
+		merges:       make(map[[2]string]int, len(file.Model.Merges)),
+		byteFallback: file.Model.ByteFallback,
+		ignoreMerges: file.Model.IgnoreMerges,
+	}
+	for rank, raw := range file.Model.Merges {
+		pair, err := parseHFMerge(raw)
+		if err != nil {
+			return nil, fmt.Errorf("merge %d: %w", rank, err)
+		}
+		t.merges[pair] = rank
+	}
+	for _, added := range file.AddedTokens {
+		if added.Content != "" {
+			t.addedTokens = append(t.addedTokens, added.Content)
+		}
+	}
+	sort.Slice(t.addedTokens, func(i, j int) bool { return len(t.addedTokens[i]) > len(t.addedTokens[j]) })
+
+	if file.Normalizer != nil {
+		if err := t.addNormalizer(*file.Normalizer); err != nil {
+			return nil, err
+		}
+	}
+	if file.PreTokenizer != nil {
+		if err := t.addPreTokenizer(*file.PreTokenizer); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// parseHFMerge reads a merge written as "left right" or ["left", "right"]
+func parseHFMerge(raw json.RawMessage) ([2]string, error) {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		left, right, ok := strings.Cut(text, " ")
+		if !ok {
+			return [2]string{}, fmt.Errorf("invalid merge %q", text)
+		}
+		return [2]string{left, right}, nil
+	}
+	var pair []string
+	if err := json.Unmarshal(raw, &pair); err != nil || len(pair) != 2 {
+		return [2]string{}, fmt.Errorf("invalid merge %s", raw)
+	}
+	return [2]string{pair[0], pair[1]}, nil
+}
+
+// addNormalizer adds the steps of a normalizer. Unicode normalization forms
+// are not applied, as counted text is expected to be NFC already.
+func (t *hfTokenizer) addNormalizer(n hfComponent) error {
+	switch n.Type {
+	case "Sequence":
+		for _, step := range n.Normalizers {
+			if err := t.addNormalizer(step); err != nil {
+				return err
+			}
+		}
+	case "Prepend":
+		prepend := n.Prepend
+		t.normalizers = append(t.normalizers, func(s string) string { return prepend + s })
+	case "Replace":
+		if n.Pattern.String == nil {
+			return fmt.Errorf("unsupported Replace normalizer, only string patterns are supported")
+		}
+		old, replacement := *n.Pattern.String, n.Content
+		t.normalizers = append(t.normalizers, func(s string) string { return strings.ReplaceAll(s, old, replacement) })
+	case "Lowercase":
+		t.normalizers = append(t.normalizers, strings.ToLower)
+	case "Strip":
+		left, right := n.StripLeft, n.StripRight
+		t.normalizers = append(t.normalizers, func(s string) string {
+			if left {
+				s = strings.TrimLeftFunc(s, unicode.IsSpace)
+			}
+			if right {
+				s = strings.TrimRightFunc(s, unicode.IsSpace)
+			}
+			return s
+		})
+	case "NFC", "NFKC", "NFD", "NFKD":
+	default:
+		return fmt.Errorf("unsupported normalizer %q", n.Type)
+	}
+	return nil
+}
+
+// addPreTokenizer adds the steps of a pre-tokenizer
+func (t *hfTokenizer) addPreTokenizer(p hfComponent) error {
+	switch p.Type {
+	case "Sequence":
+		for _, step := range p.Pretokenizers {
+			if err := t.addPreTokenizer(step); err != nil {
+				return err
+			}
+		}
+	case "Split":
+		pattern := ""
+		switch {
+		case p.Pattern.Regex != nil:
+			pattern = *p.Pattern.Regex
+		case p.Pattern.String != nil:
+			pattern = regexp2.Escape(*p.Pattern.String)
+		default:
+			return fmt.Errorf("Split pre-tokenizer has no pattern")
+		}
+		re, err := regexp2.Compile(pattern, regexp2.None)
+		if err != nil {
+			return fmt.Errorf("invalid Split pattern: %w", err)
+		}
+		behavior, invert := p.Behavior, p.Invert
+		t.preTokenizers = append(t.preTokenizers, eachWord(func(word string) []string {
+			return splitWithBehavior(splitRegex(re, word), behavior, invert)
+		}))
+	case "ByteLevel":
+		t.byteLevel = true
+		if p.AddPrefixSpace != nil && *p.AddPrefixSpace {
+			t.preTokenizers = append(t.preTokenizers, func(words []string) []string {
+				if len(words) > 0 && !strings.HasPrefix(words[0], " ") {
+					words[0] = " " + words[0]
+				}
+				return words
+			})
+		}
+		if p.UseRegex == nil || *p.UseRegex {
+			re := regexp2.MustCompile(byteLevelPattern, regexp2.None)
+			t.preTokenizers = append(t.preTokenizers, eachWord(func(word string) []string {
+				return splitWithBehavior(splitRegex(re, word), "Isolated", false)
+			}))
+		}
+	case "Metaspace":
+		t.preTokenizers = append(t.preTokenizers, metaspace(p))
+	case "Digits":
+		pattern := `\p{N}+`
+		if p.IndividualDigits {
+			pattern = `\p{N}`
+		}
+		re := regexp2.MustCompile(pattern, regexp2.None)
+		t.preTokenizers = append(t.preTokenizers, eachWord(func(word string) []string {
+			return splitWithBehavior(splitRegex(re, word), "Isolated", false)
+		}))
+	case "WhitespaceSplit":
+		t.preTokenizers = append(t.preTokenizers, eachWord(strings.Fields))
+	default:
+		return fmt.Errorf("unsupported pre-tokenizer %q", p.Type)
+	}
+	return nil
+}
+
+// metaspace returns a Metaspace pre-tokenizer, which replaces spaces with a
+// marker, optionally prepends it and splits words before it
+func metaspace(p hfComponent) func([]string) []string {
+	replacement := p.Replacement
+	if replacement == "" {
+		replacement = "▁"
+	}
+	scheme := p.PrependScheme
+	if scheme == "" {
+		scheme = "always"
+		if p.AddPrefixSpace != nil && !*p.AddPrefixSpace {
+			scheme = "never"
+		}
+	}
+	split := p.Split == nil || *p.Split
+
+	return func(words []string) []string {
+		var out []string
+		for i, word := range words {
+			word = strings.ReplaceAll(word, " ", replacement)
+			if (scheme == "always" || (scheme == "first" && i == 0)) && !strings.HasPrefix(word, replacement) {
+				word = replacement + word
+			}
+			if split {
+				out = append(out, splitBefore(word, replacement)...)
+			} else {
+				out = append(out, word)
+			}
+		}
+		return out
+	}
+}
+
+// eachWord applies a word splitter to every word
+func eachWord(split func(string) []string) func([]string) []string {
+	return func(words []string) []string {
+		var out []string
+		for _, word := range words {
+			out = append(out, split(word)...)
+		}
+		return out
+	}
+}
+
+// splitWithBehavior turns regex segments into words following a Split
+// pre-tokenizer behavior: Isolated, Removed, MergedWithPrevious or MergedWithNext
+func splitWithBehavior(segments []regexSegment, behavior string, invert bool) []string {
+	var words []string
+	pendingNext := ""
+	for _, segment := range segments {
+		isMatch := segment.isMatch != invert
+		switch {
+		case !isMatch:
+			words = append(words, pendingNext+segment.text)
+			pendingNext = ""
+		case behavior == "Removed":
+		case behavior == "MergedWithPrevious" && len(words) > 0:
+			words[len(words)-1] += segment.text
+		case behavior == "MergedWithNext":
+			pendingNext += segment.text
+		default:
+			words = append(words, segment.text)
+		}
+	}
+	if pendingNext != "" {
+		words = append(words, pendingNext)
+	}
+	return words
+}
+
+// splitBefore splits s before every run of sep, keeping sep with the text after it
+func splitBefore(s, sep string) []string {
+	var words []string
+	start := 0
+	for i := len(sep); i < len(s); {
+		if strings.HasPrefix(s[i:], sep) && !strings.HasPrefix(s[i-len(sep):], sep) {
+			words = append(words, s[start:i])
+			start = i
+		}
+		i++
+	}
+	return append(words, s[start:])
+}
+
+// Count returns the number of tokens in text
+func (t *hfTokenizer) Count(text string) int {
+	count := 0
+	for _, segment := range splitAddedTokens(text, t.addedTokens) {
+		if segment.isMatch {
+			count++
+			continue
+		}
+		normalized := segment.text
+		for _, normalize := range t.normalizers {
+			normalized = normalize(normalized)
+		}
+		words := []string{normalized}
+		for _, preTokenize := range t.preTokenizers {
+			words = preTokenize(words)
+		}
+		for _, word := range words {
+			count += t.countWord(word)
+		}
+	}
+	return count
+}
+
+// countWord returns the number of tokens of a pre-tokenized word
+func (t *hfTokenizer) countWord(word string) int {
+	if word == "" {
+		return 0
+	}
+	var symbols []string
+	if t.byteLevel {
+		symbols = byteLevelSymbols(word)
+	} else {
+		symbols = runeStrings(word)
+	}
+	if t.ignoreMerges {
+		if _, ok := t.vocab[strings.Join(symbols, "")]; ok {
+			return 1
+		}
+	}
+
+	symbols = bpeMerge(symbols, func(left, right string) (float64, bool) {
+		rank, ok := t.merges[[2]string{left, right}]
+		return float64(rank), ok
+	})
+
+	count := 0
+	for _, symbol := range symbols {
+		if _, ok := t.vocab[symbol]; !ok && t.byteFallback {
+			// Unknown characters are encoded as one <0xNN> token per byte
+			count += len(symbol)
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+// splitAddedTokens splits text around occurrences of added tokens, which are
+// single tokens that bypass normalization
+func splitAddedTokens(text string, added []string) []regexSegment {
+	if len(added) == 0 {
+		return []regexSegment{{text: text}}
+	}
+	var segments []regexSegment
+	start := 0
+	for i := 0; i < len(text); {
+		matched := ""
+		for _, token := range added {
+			if strings.HasPrefix(text[i:], token) {
+				matched = token
+				break
+			}
+		}
+		if matched == "" {
+			i++
+			continue
+		}
+		if i > start {
+			segments = append(segments, regexSegment{text: text[start:i]})
+		}
+		segments = append(segments, regexSegment{text: matched, isMatch: true})
+		i += len(matched)
+		start = i
+	}
+	if start < len(text) {
+		segments = append(segments, regexSegment{text: text[start:]})
+	}
+	return segments
+}
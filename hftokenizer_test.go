@@ -0,0 +1,167 @@
+package paygent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// byteLevelTokenizerJSON is a tiny byte-level BPE tokenizer in the style of Llama 3 and DeepSeek
+const byteLevelTokenizerJSON = `{
+	"added_tokens": [{"id": 100, "content": "<|eot_id|>"}],
+	"normalizer": null,
+	"pre_tokenizer": {"type": "ByteLevel", "add_prefix_space": false, "use_regex": true},
+	"model": {
+		"type": "BPE",
+		"vocab": {"h": 0, "e": 1, "l": 2, "o": 3, "Ġ": 4, "w": 5, "r": 6, "d": 7,
+			"he": 8, "ll": 9, "hell": 10, "hello": 11, "Ġw": 12, "or": 13, "Ġwor": 14, "ld": 15, "Ġworld": 16},
+		"merges": ["h e", "l l", "he ll", "hell o", "Ġ w", "o r", "Ġw or", "l d", ["Ġwor", "ld"]]
+	}
+}`
+
+// metaspaceTokenizerJSON is a tiny tokenizer in the style of Llama 2 and Mistral
+const metaspaceTokenizerJSON = `{
+	"normalizer": {"type": "Sequence", "normalizers": [
+		{"type": "Prepend", "prepend": "▁"},
+		{"type": "Replace", "pattern": {"String": " "}, "content": "▁"}
+	]},
+	"pre_tokenizer": null,
+	"model": {
+		"type": "BPE",
+		"byte_fallback": true,
+		"vocab": {"▁": 0, "h": 1, "i": 2, "▁h": 3, "▁hi": 4},
+		"merges": ["▁ h", "▁h i"]
+	}
+}`
+
+func TestHFTokenizerCount(t *testing.T) {
+	byteLevel, err := ParseHFTokenizer(strings.NewReader(byteLevelTokenizerJSON))
+	if err != nil {
+		t.Fatalf("Failed to parse byte-level tokenizer: %v", err)
+	}
+	metaspace, err := ParseHFTokenizer(strings.NewReader(metaspaceTokenizerJSON))
+	if err != nil {
+		t.Fatalf("Failed to parse metaspace tokenizer: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		tokenizer Tokenizer
+		text      string
+		expected  int
+	}{
+		{name: "Merged words", tokenizer: byteLevel, text: "hello world", expected: 2},
+		{name: "Partial merges", tokenizer: byteLevel, text: "hell wor", expected: 2},
+		{name: "Unknown symbol", tokenizer: byteLevel, text: "hello!", expected: 2},
+		{name: "Added token", tokenizer: byteLevel, text: "hello<|eot_id|>", expected: 2},
+		{name: "Empty", tokenizer: byteLevel, text: "", expected: 0},
+		{name: "Metaspace", tokenizer: metaspace, text: "hi", expected: 1},
+		{name: "Byte fallback", tokenizer: metaspace, text: "hi é", expected: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.tokenizer.Count(tt.text); got != tt.expected {
+				t.Errorf("Count(%q) = %d, want %d", tt.text, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHFStripNormalizer(t *testing.T) {
+	tests := []struct {
+		name     string
+		left     bool
+		right    bool
+		expected int
+	}{
+		{name: "Both sides", left: true, right: true, expected: 1},
+		{name: "Left only", left: true, expected: 3},
+		{name: "Right only", right: true, expected: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenizerJSON := strings.Replace(metaspaceTokenizerJSON, `"normalizers": [`,
+				fmt.Sprintf(`"normalizers": [{"type": "Strip", "strip_left": %t, "strip_right": %t},`, tt.left, tt.right), 1)
+			tokenizer, err := ParseHFTokenizer(strings.NewReader(tokenizerJSON))
+			if err != nil {
+				t.Fatalf("ParseHFTokenizer() error = %v", err)
+			}
+			if got := tokenizer.Count("  hi  "); got != tt.expected {
+				t.Errorf("Count(%q) = %d, want %d", "  hi  ", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseHFTokenizerErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "Invalid JSON", input: `{`},
+		{name: "Unsupported model", input: `{"model": {"type": "WordPiece", "vocab": {"a": 0}}}`},
+		{name: "Empty vocabulary", input: `{"model": {"type": "BPE", "vocab": {}}}`},
+		{name: "Invalid merge", input: `{"model": {"type": "BPE", "vocab": {"a": 0}, "merges": ["ab"]}}`},
+		{name: "Unsupported normalizer", input: `{"normalizer": {"type": "Precompiled"}, "model": {"type": "BPE", "vocab": {"a": 0}}}`},
+		{name: "Unsupported pre-tokenizer", input: `{"pre_tokenizer": {"type": "BertPreTokenizer"}, "model": {"type": "BPE", "vocab": {"a": 0}}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseHFTokenizer(strings.NewReader(tt.input)); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestLoadHFTokenizerFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokenizer.json")
+	if err := os.WriteFile(path, []byte(byteLevelTokenizerJSON), 0o644); err != nil {
+		t.Fatalf("Failed to write tokenizer: %v", err)
+	}
+
+	tokenizer, err := LoadHFTokenizerFile(path)
+	if err != nil {
+		t.Fatalf("Failed to load tokenizer: %v", err)
+	}
+
+	client := NewClient("test-api-key")
+	client.SetModelTokenizer(Llama4Maverick, tokenizer)
+	if got := client.getTokenCount(Llama4Maverick, "hello world"); got != 2 {
+		t.Errorf("Expected 2 tokens, got %d", got)
+	}
+
+	if _, err := LoadHFTokenizerFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+func TestSplitWithBehavior(t *testing.T) {
+	segments := []regexSegment{{text: "a"}, {text: "-", isMatch: true}, {text: "b"}}
+
+	tests := []struct {
+		behavior string
+		invert   bool
+		expected []string
+	}{
+		{behavior: "Isolated", expected: []string{"a", "-", "b"}},
+		{behavior: "Removed", expected: []string{"a", "b"}},
+		{behavior: "MergedWithPrevious", expected: []string{"a-", "b"}},
+		{behavior: "MergedWithNext", expected: []string{"a", "-b"}},
+		{behavior: "Removed", invert: true, expected: []string{"-"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.behavior, func(t *testing.T) {
+			got := splitWithBehavior(segments, tt.behavior, tt.invert)
+			if strings.Join(got, "|") != strings.Join(tt.expected, "|") {
+				t.Errorf("splitWithBehavior(%s) = %q, want %q", tt.behavior, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package paygent
+
+import (
+	"math"
+)
+
+// Image detail levels
+const (
+	// ImageDetailAuto lets the provider choose the detail level. It is the
+	// default and is counted as ImageDetailHigh.
+	ImageDetailAuto = "auto"
+	// ImageDetailLow bills an OpenAI image at a flat rate, whatever its size
+	ImageDetailLow = "low"
+	// ImageDetailHigh bills an image by its size
+	ImageDetailHigh = "high"
+)
+
+// ImageInput describes an image sent to a model
+type ImageInput struct {
+	// Width and Height are the image's dimensions in pixels
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// Detail is one of the ImageDetail constants
+	Detail string `json:"detail,omitempty"`
+	// Provider is the provider whose image formula is used, and defaults to
+	// the provider of the model
+	Provider string `json:"provider,omitempty"`
+}
+
+// openAIImageTiles is the base and per-tile tokens of OpenAI models that bill
+// images in 512px tiles. OpenAI models that are not listed here or in
+// openAIImagePatches bill 85 base and 170 tile tokens.
+var openAIImageTiles = map[string][2]int{
+	GPT5:               {70, 140},
+	GPT5ChatLatest:     {70, 140},
+	GPT5Codex:          {70, 140},
+	GPT5Pro:            {70, 140},
+	GPT4OMini:          {2833, 5667},
+	O1:                 {75, 150},
+	O1Pro:              {75, 150},
+	O3:                 {75, 150},
+	ComputerUsePreview: {65, 129},
+}
+
+var defaultOpenAIImageTiles = [2]int{85, 170}
+
+// openAIImagePatches is the token multiplier of OpenAI models that bill
+// images in 32px patches
+var openAIImagePatches = map[string]float64{
+	GPT5Mini:  1.62,
+	GPT5Nano:  2.46,
+	GPT41Mini: 1.62,
+	GPT41Nano: 2.46,
+	O4Mini:    1.72,
+}
+
+// Image sizing limits of each provider
+const (
+	openAIMaxImageSide      = 2048
+	openAIImageShortSide    = 768
+	openAIImageTileSize     = 512
+	openAIImagePatchSize    = 32
+	openAIMaxImagePatches   = 1536
+	anthropicMaxImageSide   = 1568
+	anthropicMaxImageTokens = 1600
+	anthropicPixelsPerToken = 750
+	geminiSmallImageSide    = 384
+	geminiImageTileSize     = 768
+	geminiImageTileTokens   = 258
+)
+
+// ImageTokens returns the prompt tokens an image costs when sent to model
+func (c *Client) ImageTokens(model string, image ImageInput) int {
+	return imageTokens(c.TokenizerForModel(model), image)
+}
+
+// countImageTokens counts the prompt tokens of images sent to a tokenizer's model
+func countImageTokens(tokenizer TokenizerInfo, images []ImageInput) int {
+	tokens := 0
+	for _, image := range images {
+		tokens += imageTokens(tokenizer, image)
+	}
+	return tokens
+}
+
+// imageTokens counts the prompt tokens of an image with its provider's
+// formula. Providers without a published formula are counted like Anthropic,
+// by pixel area.
+func imageTokens(tokenizer TokenizerInfo, image ImageInput) int {
+	if image.Width <= 0 || image.Height <= 0 {
+		return 0
+	}
+	provider := image.Provider
+	if provider == "" {
+		provider = tokenizer.Provider
+	}
+	width, height := float64(image.Width), float64(image.Height)
+
+	switch provider {
+	case OpenAI:
+		if multiplier, ok := openAIImagePatches[tokenizer.Model]; ok {
+			return int(math.Ceil(float64(openAIImagePatchCount(width, height)) * multiplier))
+		}
+		tiles, ok := openAIImageTiles[tokenizer.Model]
+		if !ok {
+			tiles = defaultOpenAIImageTiles
+		}
+		if image.Detail == ImageDetailLow {
+			return tiles[0]
+		}
+		return tiles[0] + tiles[1]*openAIImageTileCount(width, height)
+	case GoogleDeepMind:
+		if width <= geminiSmallImageSide && height <= geminiSmallImageSide {
+			return geminiImageTileTokens
+		}
+		return geminiImageTileTokens * ceilDiv(width, geminiImageTileSize) * ceilDiv(height, geminiImageTileSize)
+	default:
+		return anthropicImageTokens(width, height)
+	}
+}
+
+// openAIImageTileCount returns the 512px tiles of an image after it is
+// scaled to fit 2048x2048 and then down to 768px on its short side
+func openAIImageTileCount(width, height float64) int {
+	if long := math.Max(width, height); long > openAIMaxImageSide {
+		width, height = width*openAIMaxImageSide/long, height*openAIMaxImageSide/long
+	}
+	if short := math.Min(width, height); short > openAIImageShortSide {
+		width, height = width*openAIImageShortSide/short, height*openAIImageShortSide/short
+	}
+	return ceilDiv(width, openAIImageTileSize) * ceilDiv(height, openAIImageTileSize)
+}
+
+// openAIImagePatchCount returns the 32px patches of an image, scaled down
+// to a whole number of patches when it has more than 1536
+func openAIImagePatchCount(width, height float64) int {
+	patches := ceilDiv(width, openAIImagePatchSize) * ceilDiv(height, openAIImagePatchSize)
+	if patches <= openAIMaxImagePatches {
+		return patches
+	}
+
+	shrink := math.Sqrt(openAIImagePatchSize * openAIImagePatchSize * openAIMaxImagePatches / (width * height))
+	widthPatches, heightPatches := width*shrink/openAIImagePatchSize, height*shrink/openAIImagePatchSize
+	shrink *= math.Min(math.Floor(widthPatches)/widthPatches, math.Floor(heightPatches)/heightPatches)
+	patches = ceilDiv(width*shrink, openAIImagePatchSize) * ceilDiv(height*shrink, openAIImagePatchSize)
+	return min(patches, openAIMaxImagePatches)
+}
+
+// anthropicImageTokens returns the tokens of an image by pixel area, after
+// it is scaled down to 1568px on its long side and about 1600 tokens
+func anthropicImageTokens(width, height float64) int {
+	if long := math.Max(width, height); long > anthropicMaxImageSide {
+		width, height = width*anthropicMaxImageSide/long, height*anthropicMaxImageSide/long
+	}
+	tokens := math.Ceil(width * height / anthropicPixelsPerToken)
+	return int(math.Min(tokens, anthropicMaxImageTokens))
+}
+
+// ceilDiv returns x / size rounded up, ignoring floating point error
+func ceilDiv(x, size float64) int {
+	return int(math.Ceil(x/size - 1e-9))
+}
@@ -0,0 +1,76 @@
+package paygent
+
+import (
+	"testing"
+)
+
+func TestImageTokens(t *testing.T) {
+	client := NewClient("test-api-key")
+
+	tests := []struct {
+		name     string
+		model    string
+		image    ImageInput
+		expected int
+	}{
+		{name: "GPT-4o low detail", model: GPT4O, image: ImageInput{Width: 4096, Height: 4096, Detail: ImageDetailLow}, expected: 85},
+		// Scaled to 768x768: 4 tiles
+		{name: "GPT-4o square", model: GPT4O, image: ImageInput{Width: 1024, Height: 1024}, expected: 765},
+		// Scaled to 1024x2048 then 768x1536: 6 tiles
+		{name: "GPT-4o tall", model: GPT4O, image: ImageInput{Width: 2048, Height: 4096, Detail: ImageDetailHigh}, expected: 1105},
+		{name: "GPT-4o mini", model: GPT4OMini, image: ImageInput{Width: 512, Height: 512}, expected: 8500},
+		{name: "GPT-5", model: GPT5, image: ImageInput{Width: 512, Height: 512}, expected: 210},
+		{name: "o3", model: O3, image: ImageInput{Width: 512, Height: 512, Detail: ImageDetailLow}, expected: 75},
+		// 1024x1024 is 1024 patches
+		{name: "GPT-4.1 mini", model: GPT41Mini, image: ImageInput{Width: 1024, Height: 1024}, expected: 1659},
+		// Scaled to 1056x1408: 33x44 patches
+		{name: "o4-mini large", model: O4Mini, image: ImageInput{Width: 1800, Height: 2400}, expected: 2498},
+		{name: "Claude", model: Sonnet45, image: ImageInput{Width: 1000, Height: 1000}, expected: 1334},
+		{name: "Claude capped", model: Sonnet45, image: ImageInput{Width: 3000, Height: 3000}, expected: 1600},
+		{name: "Gemini small", model: Gemini25Flash, image: ImageInput{Width: 384, Height: 200}, expected: 258},
+		{name: "Gemini tiled", model: Gemini25Pro, image: ImageInput{Width: 1000, Height: 700}, expected: 516},
+		{name: "Provider override", model: Llama4Scout, image: ImageInput{Width: 300, Height: 300, Provider: GoogleDeepMind}, expected: 258},
+		{name: "Other provider", model: Llama4Scout, image: ImageInput{Width: 750, Height: 100}, expected: 100},
+		{name: "No dimensions", model: GPT4O, image: ImageInput{}, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := client.ImageTokens(tt.model, tt.image); got != tt.expected {
+				t.Errorf("ImageTokens(%s, %+v) = %d, want %d", tt.model, tt.image, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestImagesInUsageRequests(t *testing.T) {
+	client := NewClient("test-api-key")
+	client.SetProviderTokenizer(OpenAI, wordTokenizer{})
+	image := ImageInput{Width: 1024, Height: 1024}
+
+	fromStrings, err := client.BuildUsageRequestFromStrings("agent", "customer", "vision", UsageDataWithStrings{
+		Model:        GPT4O,
+		PromptString: "Describe this image",
+		OutputString: "A cat",
+		Images:       []ImageInput{image},
+	})
+	if err != nil {
+		t.Fatalf("BuildUsageRequestFromStrings() error = %v", err)
+	}
+	if fromStrings.InputToken != 3+765 || fromStrings.ImageInputToken != 765 {
+		t.Errorf("Unexpected tokens from strings: %d input, %d image", fromStrings.InputToken, fromStrings.ImageInputToken)
+	}
+
+	messages := []ChatMessage{{Role: RoleUser, Content: "Describe this image", Images: []ImageInput{image, {Width: 512, Height: 512, Detail: ImageDetailLow}}}}
+	fromMessages, err := client.BuildUsageRequestFromMessages("agent", "customer", "vision", UsageDataWithMessages{
+		Model:    GPT4O,
+		Messages: messages,
+	})
+	if err != nil {
+		t.Fatalf("BuildUsageRequestFromMessages() error = %v", err)
+	}
+	// 3 priming + 3 per message + 1 role + 3 content + 765 + 85 image
+	if fromMessages.InputToken != 860 || fromMessages.ImageInputToken != 850 {
+		t.Errorf("Unexpected tokens from messages: %d input, %d image", fromMessages.InputToken, fromMessages.ImageInputToken)
+	}
+}
@@ -0,0 +1,208 @@
+package paygent
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Chat message roles
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool"
+)
+
+// ChatMessage is one message of a chat request
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// Name optionally names the author of the message
+	Name string `json:"name,omitempty"`
+	// ToolCalls are the tools an assistant message called
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID is the call a tool message is the result of
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	// Images are the images attached to the message
+	Images []ImageInput `json:"images,omitempty"`
+}
+
+// ToolCall is a call of a tool by the model
+type ToolCall struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+	// Arguments is the JSON encoded arguments of the call
+	Arguments string `json:"arguments"`
+}
+
+// UsageDataWithMessages represents the usage data structure with the messages of a chat request and its reply
+type UsageDataWithMessages struct {
+	// EventID uniquely identifies the usage event. A UUID is generated when empty.
+	EventID         string        `json:"event_id,omitempty"`
+	ServiceProvider string        `json:"service_provider"`
+	Model           string        `json:"model"`
+	Messages        []ChatMessage `json:"messages"`
+	// Tools are the tool definitions sent with the request and ToolChoice the
+	// request's tool choice, one of the ToolChoice constants or a tool name
+	Tools      []ToolDefinition `json:"tools,omitempty"`
+	ToolChoice string           `json:"tool_choice,omitempty"`
+	// OutputString and OutputToolCalls are the content and tool calls of the model's reply
+	OutputString    string     `json:"output_string"`
+	OutputToolCalls []ToolCall `json:"output_tool_calls,omitempty"`
+	// OccurredAt is when the usage happened. It selects the price in force at
+	// that time and defaults to now.
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// chatFormat is the token overhead a provider's chat template adds to messages
+type chatFormat struct {
+	// perMessage tokens wrap every message, in addition to its role and content
+	perMessage int
+	// perName tokens are added for a message with a name
+	perName int
+	// replyPriming tokens open the reply after the last message
+	replyPriming int
+	// countRole is true when the role is written out in the template
+	countRole bool
+	// tools is how tool definitions and calls are written into the prompt
+	tools toolSerialization
+	// perToolCall tokens wrap every tool call, and perToolResult tokens are
+	// added to every tool message
+	perToolCall   int
+	perToolResult int
+}
+
+// defaultChatFormat is OpenAI's chat format, which is also used for
+// providers that do not publish theirs
+var defaultChatFormat = chatFormat{perMessage: 3, perName: 1, replyPriming: 3, countRole: true,
+	tools: toolsTypeScript, perToolCall: 3, perToolResult: -2}
+
+// chatFormats is the chat format of each provider. OpenAI's overhead is
+// documented; the others follow the provider's chat template and write tools
+// as JSON.
+var chatFormats = map[string]chatFormat{
+	OpenAI: defaultChatFormat,
+	// "\n\nHuman:" and "\n\nAssistant:" turns
+	Anthropic: {perMessage: 2, replyPriming: 3, countRole: true, tools: toolsAnthropic},
+	// <start_of_turn>role\n ... <end_of_turn>\n, replies open with <start_of_turn>model\n
+	GoogleDeepMind: {perMessage: 3, replyPriming: 3, countRole: true},
+	// <|start_header_id|>role<|end_header_id|>\n\n ... <|eot_id|>, after <|begin_of_text|>
+	Meta: {perMessage: 4, replyPriming: 5, countRole: true},
+	// <s>[INST] ... [/INST] ...</s>
+	MistralAI: {perMessage: 2, replyPriming: 1},
+}
+
+// chatFormatFor returns the chat format of a tokenizer's model
+func chatFormatFor(tokenizer TokenizerInfo) chatFormat {
+	if tokenizer.Model == GPT350301 {
+		// The first ChatGPT snapshot wraps messages in one more token and
+		// writes the name instead of the role
+		return chatFormat{perMessage: 4, perName: -1, replyPriming: 3, countRole: true,
+			tools: toolsTypeScript, perToolCall: 3, perToolResult: -2}
+	}
+	if format, ok := chatFormats[tokenizer.Provider]; ok {
+		return format
+	}
+	return defaultChatFormat
+}
+
+// CountMessageTokens returns the prompt tokens of a chat request to model,
+// including the overhead of the provider's chat template
+func (c *Client) CountMessageTokens(model string, messages []ChatMessage) int {
+	return c.countMessageTokens(c.TokenizerForModel(model), messages)
+}
+
+// countMessageTokens counts the prompt tokens of messages with a tokenizer
+func (c *Client) countMessageTokens(tokenizer TokenizerInfo, messages []ChatMessage) int {
+	if len(messages) == 0 {
+		return 0
+	}
+	format := chatFormatFor(tokenizer)
+
+	tokens := format.replyPriming
+	for _, message := range messages {
+		tokens += format.perMessage + c.countTokens(tokenizer, message.Content)
+		if format.countRole {
+			tokens += c.countTokens(tokenizer, message.Role)
+		}
+		if message.Name != "" {
+			tokens += format.perName + c.countTokens(tokenizer, message.Name)
+		}
+		if message.Role == RoleTool {
+			tokens += format.perToolResult
+		}
+		tokens += c.countToolCallTokens(tokenizer, message.ToolCalls) + countImageTokens(tokenizer, message.Images)
+	}
+	return tokens
+}
+
+// messageImageTokens counts the prompt tokens of the images attached to messages
+func messageImageTokens(tokenizer TokenizerInfo, messages []ChatMessage) int {
+	tokens := 0
+	for _, message := range messages {
+		tokens += countImageTokens(tokenizer, message.Images)
+	}
+	return tokens
+}
+
+// messagesCost counts the tokens of a chat request and its reply and prices them
+func (c *Client) messagesCost(model string, usageData UsageDataWithMessages) (costBreakdown, error) {
+	tokenizer := c.tokenizerFor(usageData.Model, usageData.ServiceProvider)
+	promptTokens := c.countMessageTokens(tokenizer, usageData.Messages) +
+		c.countRequestToolTokens(tokenizer, usageData.Messages, usageData.Tools, usageData.ToolChoice)
+	completionTokens := c.countTokens(tokenizer, usageData.OutputString) + c.countToolCallTokens(tokenizer, usageData.OutputToolCalls)
+
+	tokens := tokenCounts{Prompt: promptTokens, Completion: completionTokens, ImagePrompt: messageImageTokens(tokenizer, usageData.Messages)}
+	cost, err := c.priceUsage(model, tokens, occurredAtOrNow(usageData.OccurredAt))
+	if err != nil {
+		return costBreakdown{}, err
+	}
+
+	c.logger.Debugf("Cost calculation for model '%s' from messages (%s pricing, version %s%s): %s", model, cost.Source, cost.Version, cost.tierSuffix(), cost)
+
+	return cost, nil
+}
+
+// SendUsageWithMessages sends usage data to the Paygent API using the messages of a chat request
+func (c *Client) SendUsageWithMessages(agentID, customerID, indicator string, usageData UsageDataWithMessages) error {
+	return c.SendUsageWithMessagesContext(context.Background(), agentID, customerID, indicator, usageData)
+}
+
+// SendUsageWithMessagesContext sends usage data to the Paygent API using the
+// messages of a chat request, honouring cancellation and deadlines carried by ctx.
+func (c *Client) SendUsageWithMessagesContext(ctx context.Context, agentID, customerID, indicator string, usageData UsageDataWithMessages) error {
+	c.logger.Infof("Starting sendUsageWithMessages for agentID=%s, customerID=%s, indicator=%s, serviceProvider=%s, model=%s",
+		agentID, customerID, indicator, usageData.ServiceProvider, usageData.Model)
+
+	apiRequest, err := c.BuildUsageRequestFromMessages(agentID, customerID, indicator, usageData)
+	if err != nil {
+		return err
+	}
+
+	if err := c.postUsage(ctx, apiRequest); err != nil {
+		return err
+	}
+
+	c.logger.Infof("Successfully sent usage data from messages for agentID=%s, customerID=%s, cost=%s",
+		agentID, customerID, apiRequest.Amount)
+	return nil
+}
+
+// BuildUsageRequestFromMessages tokenizes and prices message usage data and builds the API request for it without sending it
+func (c *Client) BuildUsageRequestFromMessages(agentID, customerID, indicator string, usageData UsageDataWithMessages) (APIRequest, error) {
+	// Calculate cost from messages with the price in force when the usage occurred
+	usageData.OccurredAt = occurredAtOrNow(usageData.OccurredAt)
+	cost, err := c.messagesCost(usageData.Model, usageData)
+	if err != nil {
+		c.logger.Errorf("Failed to calculate cost from messages: %v", err)
+		return APIRequest{}, fmt.Errorf("failed to calculate cost from messages: %w", err)
+	}
+	return c.countedUsageRequest(agentID, customerID, indicator, countedUsage{
+		EventID:         usageData.EventID,
+		ServiceProvider: usageData.ServiceProvider,
+		Model:           usageData.Model,
+		OccurredAt:      usageData.OccurredAt,
+		Source:          "messages",
+	}, cost)
+}
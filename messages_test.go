@@ -0,0 +1,85 @@
+package paygent
+
+import (
+	"strings"
+	"testing"
+)
+
+// wordTokenizer counts every word as one token
+type wordTokenizer struct{}
+
+func (wordTokenizer) Count(text string) int { return len(strings.Fields(text)) }
+
+func TestCountMessageTokens(t *testing.T) {
+	client := NewClient("test-api-key")
+	for _, provider := range []string{OpenAI, Anthropic, Meta, MistralAI} {
+		client.SetProviderTokenizer(provider, wordTokenizer{})
+	}
+
+	messages := []ChatMessage{
+		{Role: RoleSystem, Content: "You are helpful."},
+		{Role: RoleUser, Content: "What is the capital of France?", Name: "alice"},
+	}
+
+	tests := []struct {
+		model    string
+		expected int
+	}{
+		// 3 priming + 2 × (3 per message + 1 role) + 9 content + 1 name + 1 per name
+		{model: GPT4O, expected: 22},
+		// 3 priming + 2 × (4 per message + 1 role) + 9 content + 1 name - 1 per name
+		{model: GPT350301, expected: 22},
+		// 3 priming + 2 × (2 per message + 1 role) + 9 content + 1 name
+		{model: Sonnet45, expected: 19},
+		// 5 priming + 2 × (4 per message + 1 role) + 9 content + 1 name
+		{model: Llama4Scout, expected: 25},
+		// 1 priming + 2 × 2 per message + 9 content + 1 name
+		{model: MistralLarge, expected: 15},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			if got := client.CountMessageTokens(tt.model, messages); got != tt.expected {
+				t.Errorf("CountMessageTokens(%s) = %d, want %d", tt.model, got, tt.expected)
+			}
+		})
+	}
+
+	if got := client.CountMessageTokens(GPT4O, nil); got != 0 {
+		t.Errorf("Expected no tokens without messages, got %d", got)
+	}
+}
+
+func TestBuildUsageRequestFromMessages(t *testing.T) {
+	client := NewClient("test-api-key")
+	client.SetProviderTokenizer(OpenAI, wordTokenizer{})
+
+	usageData := UsageDataWithMessages{
+		ServiceProvider: OpenAI,
+		Model:           GPT4O,
+		Messages: []ChatMessage{
+			{Role: RoleUser, Content: "Weather in Paris?"},
+			{Role: RoleAssistant, ToolCalls: []ToolCall{{ID: "call_1", Name: "get_weather", Arguments: `{"city": "Paris"}`}}},
+			{Role: RoleTool, ToolCallID: "call_1", Content: "Sunny"},
+		},
+		OutputString:    "It is sunny in Paris.",
+		OutputToolCalls: []ToolCall{{Name: "done", Arguments: "{}"}},
+	}
+
+	apiRequest, err := client.BuildUsageRequestFromMessages("agent", "customer", "chat", usageData)
+	if err != nil {
+		t.Fatalf("BuildUsageRequestFromMessages() error = %v", err)
+	}
+
+	// 3 priming + 3 × (3 per message + 1 role) + 3 content + 3 + 3 tool call + 1 - 2 tool result
+	if apiRequest.InputToken != 23 {
+		t.Errorf("Expected 23 input tokens, got %d", apiRequest.InputToken)
+	}
+	// 5 content + 3 + 2 tool call
+	if apiRequest.OutputToken != 10 {
+		t.Errorf("Expected 10 output tokens, got %d", apiRequest.OutputToken)
+	}
+	if apiRequest.EventID == "" || apiRequest.Model != GPT4O || apiRequest.Amount.IsZero() {
+		t.Errorf("Unexpected request: %+v", apiRequest)
+	}
+}
@@ -0,0 +1,272 @@
+package paygent
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// MoneyScale is the number of decimal places a Money value holds
+const MoneyScale = 9
+
+// moneyUnit is the number of nano-units in one unit of currency
+const moneyUnit = 1_000_000_000
+
+// ErrMoneyOverflow is returned when an amount does not fit in a Money, which
+// holds about ±9.2 billion units of currency
+var ErrMoneyOverflow = errors.New("paygent: amount out of range")
+
+// Money is an exact amount of money, stored as an integer number of nano-units
+// (10^-9) of its currency. It marshals to JSON as an exact decimal number.
+type Money struct {
+	nanos int64
+}
+
+// RoundingMode controls how amounts are rounded to a number of decimal places
+type RoundingMode int
+
+const (
+	// RoundHalfEven rounds to the nearest value, ties to the even neighbour (banker's rounding)
+	RoundHalfEven RoundingMode = iota
+	// RoundHalfUp rounds to the nearest value, ties away from zero
+	RoundHalfUp
+	// RoundDown rounds towards zero
+	RoundDown
+	// RoundUp rounds away from zero
+	RoundUp
+)
+
+// String returns the name of the rounding mode
+func (m RoundingMode) String() string {
+	switch m {
+	case RoundHalfEven:
+		return "half_even"
+	case RoundHalfUp:
+		return "half_up"
+	case RoundDown:
+		return "down"
+	case RoundUp:
+		return "up"
+	default:
+		return "unknown"
+	}
+}
+
+// MoneyFromNanos returns the amount of nanos nano-units
+func MoneyFromNanos(nanos int64) Money {
+	return Money{nanos: nanos}
+}
+
+// MoneyFromFloat converts a float to Money using its shortest decimal
+// representation, so MoneyFromFloat(0.1) is exactly 0.1. Digits beyond
+// MoneyScale are rounded half to even. NaN, infinities and amounts out of
+// range are zero; use ParseMoney to detect them.
+func MoneyFromFloat(f float64) Money {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return Money{}
+	}
+	m, err := roundRat(ratFromFloat(f), MoneyScale, RoundHalfEven)
+	if err != nil {
+		return Money{}
+	}
+	return m
+}
+
+// ParseMoney parses a decimal string such as "12.345" or "1e-6". Digits beyond
+// MoneyScale are rounded half to even. Amounts out of range return an error
+// wrapping ErrMoneyOverflow.
+func ParseMoney(s string) (Money, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return Money{}, fmt.Errorf("invalid money amount %q", s)
+	}
+	m, err := roundRat(r, MoneyScale, RoundHalfEven)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid money amount %q: %w", s, err)
+	}
+	return m, nil
+}
+
+// Nanos returns the amount in nano-units
+func (m Money) Nanos() int64 {
+	return m.nanos
+}
+
+// Float64 returns the amount as a float, for compatibility with float-based code.
+// The result may not be exact.
+func (m Money) Float64() float64 {
+	f, _ := m.Rat().Float64()
+	return f
+}
+
+// Rat returns the exact amount as a rational number
+func (m Money) Rat() *big.Rat {
+	return big.NewRat(m.nanos, moneyUnit)
+}
+
+// Add returns the sum of m and other, or ErrMoneyOverflow if it is out of range
+func (m Money) Add(other Money) (Money, error) {
+	sum := m.nanos + other.nanos
+	if (other.nanos > 0 && sum < m.nanos) || (other.nanos < 0 && sum > m.nanos) {
+		return Money{}, ErrMoneyOverflow
+	}
+	return Money{nanos: sum}, nil
+}
+
+// Sub returns m minus other, or ErrMoneyOverflow if it is out of range
+func (m Money) Sub(other Money) (Money, error) {
+	diff := m.nanos - other.nanos
+	if (other.nanos > 0 && diff > m.nanos) || (other.nanos < 0 && diff < m.nanos) {
+		return Money{}, ErrMoneyOverflow
+	}
+	return Money{nanos: diff}, nil
+}
+
+// IsZero reports whether the amount is zero
+func (m Money) IsZero() bool {
+	return m.nanos == 0
+}
+
+// Round rounds the amount to the given number of decimal places. Rounding
+// away from zero can only overflow within a unit of the range limits, where
+// it returns ErrMoneyOverflow.
+func (m Money) Round(places int, mode RoundingMode) (Money, error) {
+	return roundRat(m.Rat(), places, mode)
+}
+
+// String returns the exact decimal representation without trailing zeros, e.g. "0.0075"
+func (m Money) String() string {
+	nanos := m.nanos
+	sign := ""
+	if nanos < 0 {
+		sign = "-"
+	}
+	abs := new(big.Int).Abs(big.NewInt(nanos))
+	whole, frac := new(big.Int).QuoRem(abs, big.NewInt(moneyUnit), new(big.Int))
+
+	if frac.Sign() == 0 {
+		return sign + whole.String()
+	}
+	digits := strings.TrimRight(fmt.Sprintf("%0*d", MoneyScale, frac.Int64()), "0")
+	return sign + whole.String() + "." + digits
+}
+
+// MarshalJSON encodes the amount as an exact JSON number
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON decodes a JSON number or a decimal string
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		data = []byte(s)
+	}
+
+	parsed, err := ParseMoney(string(data))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// roundRat rounds r to the given number of decimal places (at most MoneyScale),
+// returning ErrMoneyOverflow when the result does not fit in a Money
+func roundRat(r *big.Rat, places int, mode RoundingMode) (Money, error) {
+	if places < 0 {
+		places = 0
+	}
+	if places > MoneyScale {
+		places = MoneyScale
+	}
+
+	// Scale so the digits to keep are the integer part
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(places)), nil)
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(scale))
+
+	quo, rem := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	if rem.Sign() != 0 {
+		// Compare twice the remainder with the denominator to find ties
+		cmp := new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(scaled.Denom())
+		awayFromZero := false
+		switch mode {
+		case RoundHalfEven:
+			awayFromZero = cmp > 0 || (cmp == 0 && quo.Bit(0) == 1)
+		case RoundHalfUp:
+			awayFromZero = cmp >= 0
+		case RoundUp:
+			awayFromZero = true
+		}
+		if awayFromZero {
+			quo.Add(quo, big.NewInt(int64(rem.Sign())))
+		}
+	}
+
+	// Back to nano-units
+	nanos := quo.Mul(quo, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(MoneyScale-places)), nil))
+	if !nanos.IsInt64() {
+		return Money{}, ErrMoneyOverflow
+	}
+	return Money{nanos: nanos.Int64()}, nil
+}
+
+// ratFromFloat returns the decimal value a float prints as, e.g. 0.1 rather
+// than the binary value closest to it. NaN and infinities are zero.
+func ratFromFloat(f float64) *big.Rat {
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+	if !ok {
+		return new(big.Rat)
+	}
+	return r
+}
+
+// SetAmountRounding sets how usage amounts are rounded: to places decimal
+// places (0 to 9) using mode. Costs are computed exactly and rounded once per
+// amount. The default is 9 places, rounding half to even.
+func (c *Client) SetAmountRounding(places int, mode RoundingMode) error {
+	if places < 0 || places > MoneyScale {
+		return fmt.Errorf("rounding places must be between 0 and %d, got %d", MoneyScale, places)
+	}
+	if mode < RoundHalfEven || mode > RoundUp {
+		return fmt.Errorf("unknown rounding mode %d", mode)
+	}
+	c.roundingPlaces = places
+	c.roundingMode = mode
+	return nil
+}
+
+// roundAmount rounds an exact cost with the client's rounding settings,
+// returning an error wrapping ErrMoneyOverflow when it is out of range
+func (c *Client) roundAmount(r *big.Rat) (Money, error) {
+	amount, err := roundRat(r, c.roundingPlaces, c.roundingMode)
+	if err != nil {
+		return Money{}, fmt.Errorf("amount %s: %w", r.FloatString(MoneyScale), err)
+	}
+	return amount, nil
+}
+
+// optionalAmount rounds an exact partial cost for an optional payload field,
+// returning nil when it is zero
+func (c *Client) optionalAmount(r *big.Rat) (*Money, error) {
+	if r.Sign() == 0 {
+		return nil, nil
+	}
+	amount, err := c.roundAmount(r)
+	if err != nil {
+		return nil, err
+	}
+	return &amount, nil
+}
@@ -0,0 +1,148 @@
+package paygent
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestMoneyRound(t *testing.T) {
+	tests := []struct {
+		amount   string
+		places   int
+		mode     RoundingMode
+		expected string
+	}{
+		{amount: "0.125", places: 2, mode: RoundHalfEven, expected: "0.12"},
+		{amount: "0.135", places: 2, mode: RoundHalfEven, expected: "0.14"},
+		{amount: "0.125", places: 2, mode: RoundHalfUp, expected: "0.13"},
+		{amount: "-0.125", places: 2, mode: RoundHalfUp, expected: "-0.13"},
+		{amount: "0.121", places: 2, mode: RoundUp, expected: "0.13"},
+		{amount: "-0.121", places: 2, mode: RoundUp, expected: "-0.13"},
+		{amount: "0.129", places: 2, mode: RoundDown, expected: "0.12"},
+		{amount: "1.5", places: 0, mode: RoundHalfEven, expected: "2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.amount+" "+tt.mode.String(), func(t *testing.T) {
+			amount, err := ParseMoney(tt.amount)
+			if err != nil {
+				t.Fatalf("ParseMoney() error = %v", err)
+			}
+			rounded, err := amount.Round(tt.places, tt.mode)
+			if err != nil {
+				t.Fatalf("Round() error = %v", err)
+			}
+			if got := rounded.String(); got != tt.expected {
+				t.Errorf("Round(%d, %v) = %s, want %s", tt.places, tt.mode, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	amount, err := MoneyFromFloat(0.1).Add(MoneyFromFloat(0.2))
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	data, err := json.Marshal(struct {
+		Amount Money `json:"amount"`
+	}{amount})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(data) != `{"amount":0.3}` {
+		t.Errorf("Expected exact decimal, got %s", data)
+	}
+
+	for _, input := range []string{`0.000000123`, `"0.000000123"`, `1.23e-7`} {
+		var decoded Money
+		if err := json.Unmarshal([]byte(input), &decoded); err != nil {
+			t.Fatalf("Unmarshal(%s) error = %v", input, err)
+		}
+		if decoded.Nanos() != 123 {
+			t.Errorf("Unmarshal(%s) = %d nanos, want 123", input, decoded.Nanos())
+		}
+	}
+}
+
+func TestAmountsAggregateExactly(t *testing.T) {
+	client := NewClient("test-api-key")
+
+	// 10k micro-charges of 7 prompt tokens at $0.00015 per 1000 tokens
+	var total Money
+	for i := 0; i < 10000; i++ {
+		cost, err := client.usageCost(GPT4OMini, UsageData{PromptTokens: 7})
+		if err != nil {
+			t.Fatalf("usageCost() error = %v", err)
+		}
+		if total, err = total.Add(cost.Amount); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	if total.String() != "0.0105" {
+		t.Errorf("Expected total of exactly 0.0105, got %s", total)
+	}
+}
+
+func TestMoneyOverflow(t *testing.T) {
+	max := MoneyFromNanos(math.MaxInt64)
+	if _, err := max.Add(MoneyFromNanos(1)); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("Expected Add() to overflow, got %v", err)
+	}
+	if _, err := MoneyFromNanos(math.MinInt64).Sub(MoneyFromNanos(1)); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("Expected Sub() to overflow, got %v", err)
+	}
+	if _, err := max.Round(0, RoundUp); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("Expected Round() to overflow, got %v", err)
+	}
+	if _, err := ParseMoney("1e10"); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("Expected ParseMoney() to overflow, got %v", err)
+	}
+	if sum, err := max.Add(MoneyFromNanos(-1)); err != nil || sum.Nanos() != math.MaxInt64-1 {
+		t.Errorf("Expected Add() of a negative amount to fit, got %s (%v)", sum, err)
+	}
+
+	// Usage whose cost does not fit is rejected instead of sent as a wrapped amount
+	client := NewClient("test-api-key")
+	client.RegisterModelPricing("pricey-model", ModelPricing{PromptTokensCost: 1000})
+	if _, err := client.BuildUsageRequest("agent", "customer", "indicator", UsageData{Model: "pricey-model", PromptTokens: 1e10}); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("Expected BuildUsageRequest() to fail with ErrMoneyOverflow, got %v", err)
+	}
+
+	// So is a cost that only overflows once converted to the billing currency
+	rates, _ := NewStaticFXRates(map[string]float64{"USD/IDR": 16000})
+	client.SetFXRateProvider(rates)
+	if err := client.SetBillingCurrency("IDR"); err != nil {
+		t.Fatalf("SetBillingCurrency() error = %v", err)
+	}
+	if _, err := client.BuildUsageRequest("agent", "customer", "indicator", UsageData{Model: "pricey-model", PromptTokens: 1e6}); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("Expected BuildUsageRequest() to fail with ErrMoneyOverflow after conversion, got %v", err)
+	}
+}
+
+func TestSetAmountRounding(t *testing.T) {
+	client := NewClient("test-api-key")
+	if err := client.SetAmountRounding(10, RoundHalfEven); err == nil {
+		t.Error("Expected error for more than 9 decimal places")
+	}
+	if err := client.SetAmountRounding(6, RoundingMode(42)); err == nil {
+		t.Error("Expected error for an unknown rounding mode")
+	}
+	if err := client.SetAmountRounding(6, RoundUp); err != nil {
+		t.Fatalf("SetAmountRounding() error = %v", err)
+	}
+
+	// 1 token at $0.0025 per 1000 tokens is $0.0000025, rounded up to 6 places
+	request, err := client.BuildUsageRequest("agent", "customer", "indicator", UsageData{Model: GPT4O, PromptTokens: 1})
+	if err != nil {
+		t.Fatalf("BuildUsageRequest() error = %v", err)
+	}
+	if request.Amount.String() != "0.000003" {
+		t.Errorf("Expected amount 0.000003, got %s", request.Amount)
+	}
+	if request.Amount.Float64() != 0.000003 {
+		t.Errorf("Expected float amount 0.000003, got %v", request.Amount.Float64())
+	}
+}
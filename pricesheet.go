@@ -0,0 +1,431 @@
+package paygent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PriceSheetFormat is the encoding of a price sheet
+type PriceSheetFormat string
+
+const (
+	// PriceSheetJSON is a JSON encoded price sheet
+	PriceSheetJSON PriceSheetFormat = "json"
+	// PriceSheetYAML is a YAML encoded price sheet
+	PriceSheetYAML PriceSheetFormat = "yaml"
+)
+
+// PriceSheet is a table of model prices loaded from a file.
+// Costs are per 1000 tokens, like ModelPricing. A model may be listed several
+// times with different effective dates to keep its price history; usage is
+// priced with the version in force when it occurred.
+//
+// Example (YAML):
+//
+//	currency: USD
+//	effective_date: 2025-10-01
+//	models:
+//	  - model: gpt-4o
+//	    provider: OpenAI
+//	    prompt_tokens_cost: 0.0025
+//	    completion_tokens_cost: 0.01
+//	  - model: gpt-4o
+//	    provider: OpenAI
+//	    prompt_tokens_cost: 0.005
+//	    completion_tokens_cost: 0.015
+//	    effective_date: 2024-05-13
+//	    effective_until: 2025-10-01
+type PriceSheet struct {
+	// Currency is the default currency of the entries (default USD)
+	Currency string `json:"currency,omitempty" yaml:"currency,omitempty"`
+	// EffectiveDate is the default effective date of the entries, as YYYY-MM-DD or RFC 3339
+	EffectiveDate string            `json:"effective_date,omitempty" yaml:"effective_date,omitempty"`
+	Models        []PriceSheetEntry `json:"models" yaml:"models"`
+}
+
+// PriceSheetEntry is the price of a single model in a PriceSheet
+type PriceSheetEntry struct {
+	Model                string  `json:"model" yaml:"model"`
+	Provider             string  `json:"provider,omitempty" yaml:"provider,omitempty"`
+	PromptTokensCost     float64 `json:"prompt_tokens_cost" yaml:"prompt_tokens_cost"`
+	CompletionTokensCost float64 `json:"completion_tokens_cost" yaml:"completion_tokens_cost"`
+	// CachedPromptTokensCost is the price of prompt tokens read from cache (default: the prompt price)
+	CachedPromptTokensCost float64 `json:"cached_prompt_tokens_cost,omitempty" yaml:"cached_prompt_tokens_cost,omitempty"`
+	// CacheWriteTokensCost is the price of prompt tokens written to cache (default: the prompt price)
+	CacheWriteTokensCost float64 `json:"cache_write_tokens_cost,omitempty" yaml:"cache_write_tokens_cost,omitempty"`
+	// ReasoningTokensCost is the price of reasoning tokens (default: the completion price)
+	ReasoningTokensCost float64 `json:"reasoning_tokens_cost,omitempty" yaml:"reasoning_tokens_cost,omitempty"`
+	// Media prompt token prices (default: the prompt price)
+	AudioPromptTokensCost float64 `json:"audio_prompt_tokens_cost,omitempty" yaml:"audio_prompt_tokens_cost,omitempty"`
+	ImagePromptTokensCost float64 `json:"image_prompt_tokens_cost,omitempty" yaml:"image_prompt_tokens_cost,omitempty"`
+	VideoPromptTokensCost float64 `json:"video_prompt_tokens_cost,omitempty" yaml:"video_prompt_tokens_cost,omitempty"`
+	// Media completion token prices (default: the completion price)
+	AudioCompletionTokensCost float64 `json:"audio_completion_tokens_cost,omitempty" yaml:"audio_completion_tokens_cost,omitempty"`
+	ImageCompletionTokensCost float64 `json:"image_completion_tokens_cost,omitempty" yaml:"image_completion_tokens_cost,omitempty"`
+	VideoCompletionTokensCost float64 `json:"video_completion_tokens_cost,omitempty" yaml:"video_completion_tokens_cost,omitempty"`
+	// TierBasis is what the tiers are keyed on: prompt_tokens (default) or volume
+	TierBasis string `json:"tier_basis,omitempty" yaml:"tier_basis,omitempty"`
+	// Tiers replace the entry's rates once the tier basis reaches min_tokens
+	Tiers []PriceSheetTier `json:"tiers,omitempty" yaml:"tiers,omitempty"`
+	// Currency overrides the sheet currency for this entry
+	Currency string `json:"currency,omitempty" yaml:"currency,omitempty"`
+	// EffectiveDate overrides the sheet effective date for this entry
+	EffectiveDate string `json:"effective_date,omitempty" yaml:"effective_date,omitempty"`
+	// EffectiveUntil is when the price stopped applying, as YYYY-MM-DD or RFC 3339 (empty while current)
+	EffectiveUntil string `json:"effective_until,omitempty" yaml:"effective_until,omitempty"`
+	// VersionID identifies this price version in usage records
+	VersionID string `json:"version_id,omitempty" yaml:"version_id,omitempty"`
+}
+
+// PriceSheetTier is a pricing tier of a PriceSheetEntry. Rates that are not set
+// keep the entry's rate.
+type PriceSheetTier struct {
+	Name                   string  `json:"name,omitempty" yaml:"name,omitempty"`
+	MinTokens              int     `json:"min_tokens" yaml:"min_tokens"`
+	PromptTokensCost       float64 `json:"prompt_tokens_cost,omitempty" yaml:"prompt_tokens_cost,omitempty"`
+	CompletionTokensCost   float64 `json:"completion_tokens_cost,omitempty" yaml:"completion_tokens_cost,omitempty"`
+	CachedPromptTokensCost float64 `json:"cached_prompt_tokens_cost,omitempty" yaml:"cached_prompt_tokens_cost,omitempty"`
+	CacheWriteTokensCost   float64 `json:"cache_write_tokens_cost,omitempty" yaml:"cache_write_tokens_cost,omitempty"`
+	ReasoningTokensCost    float64 `json:"reasoning_tokens_cost,omitempty" yaml:"reasoning_tokens_cost,omitempty"`
+}
+
+// ParsePriceSheet decodes and validates a price sheet
+func ParsePriceSheet(r io.Reader, format PriceSheetFormat) (*PriceSheet, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read price sheet: %w", err)
+	}
+
+	var sheet PriceSheet
+	switch format {
+	case PriceSheetJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&sheet)
+	case PriceSheetYAML:
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&sheet)
+	default:
+		return nil, fmt.Errorf("unsupported price sheet format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode price sheet: %w", err)
+	}
+
+	if err := sheet.Validate(); err != nil {
+		return nil, err
+	}
+	return &sheet, nil
+}
+
+// LoadPriceSheetFile reads and validates a price sheet file. The format is
+// taken from the extension: .json, .yaml or .yml.
+func LoadPriceSheetFile(path string) (*PriceSheet, error) {
+	format, err := priceSheetFormatFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open price sheet: %w", err)
+	}
+	defer file.Close()
+
+	return ParsePriceSheet(file, format)
+}
+
+// Validate checks that every entry names a model, has finite non-negative
+// costs, an ISO 4217 currency and a parseable effective period, and that no
+// model has two versions with the same effective date
+func (s *PriceSheet) Validate() error {
+	_, err := s.pricingTable()
+	return err
+}
+
+// pricingTable validates the sheet and converts it to a versioned pricing table
+func (s *PriceSheet) pricingTable() (pricingVersions, error) {
+	if len(s.Models) == 0 {
+		return nil, errors.New("invalid price sheet: no models")
+	}
+
+	var errs []error
+	table := make(pricingVersions, len(s.Models))
+	for i, entry := range s.Models {
+		pricing, err := s.entryPricing(entry)
+		if err == nil {
+			for _, existing := range table[entry.Model] {
+				if existing.EffectiveDate.Equal(pricing.EffectiveDate) {
+					err = fmt.Errorf("duplicate model %q", entry.Model)
+					break
+				}
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("entry %d: %w", i, err))
+			continue
+		}
+		table.add(entry.Model, pricing)
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid price sheet: %w", errors.Join(errs...))
+	}
+	return table, nil
+}
+
+// entryPricing validates a single entry and applies the sheet defaults
+func (s *PriceSheet) entryPricing(entry PriceSheetEntry) (ModelPricing, error) {
+	if strings.TrimSpace(entry.Model) == "" {
+		return ModelPricing{}, errors.New("model is required")
+	}
+	costs := []struct {
+		field string
+		cost  float64
+	}{
+		{"prompt_tokens_cost", entry.PromptTokensCost},
+		{"completion_tokens_cost", entry.CompletionTokensCost},
+		{"cached_prompt_tokens_cost", entry.CachedPromptTokensCost},
+		{"cache_write_tokens_cost", entry.CacheWriteTokensCost},
+		{"reasoning_tokens_cost", entry.ReasoningTokensCost},
+		{"audio_prompt_tokens_cost", entry.AudioPromptTokensCost},
+		{"image_prompt_tokens_cost", entry.ImagePromptTokensCost},
+		{"video_prompt_tokens_cost", entry.VideoPromptTokensCost},
+		{"audio_completion_tokens_cost", entry.AudioCompletionTokensCost},
+		{"image_completion_tokens_cost", entry.ImageCompletionTokensCost},
+		{"video_completion_tokens_cost", entry.VideoCompletionTokensCost},
+	}
+	for _, c := range costs {
+		if !validCost(c.cost) {
+			return ModelPricing{}, fmt.Errorf("model %q: %s must be a non-negative number", entry.Model, c.field)
+		}
+	}
+
+	tiers, basis, err := entryTiers(entry)
+	if err != nil {
+		return ModelPricing{}, fmt.Errorf("model %q: %w", entry.Model, err)
+	}
+
+	currency := normalizeCurrency(firstNonEmpty(entry.Currency, s.Currency, "USD"))
+	if !validCurrency(currency) {
+		return ModelPricing{}, fmt.Errorf("model %q: invalid currency %q, expected an ISO 4217 code", entry.Model, currency)
+	}
+
+	var effectiveDate time.Time
+	if value := firstNonEmpty(entry.EffectiveDate, s.EffectiveDate); value != "" {
+		if effectiveDate, err = parseEffectiveDate(value); err != nil {
+			return ModelPricing{}, fmt.Errorf("model %q: %w", entry.Model, err)
+		}
+	}
+
+	var effectiveUntil time.Time
+	if entry.EffectiveUntil != "" {
+		if effectiveUntil, err = parseEffectiveDate(entry.EffectiveUntil); err != nil {
+			return ModelPricing{}, fmt.Errorf("model %q: %w", entry.Model, err)
+		}
+		if !effectiveUntil.After(effectiveDate) {
+			return ModelPricing{}, fmt.Errorf("model %q: effective_until must be after the effective date", entry.Model)
+		}
+	}
+
+	return ModelPricing{
+		PromptTokensCost:          entry.PromptTokensCost,
+		CompletionTokensCost:      entry.CompletionTokensCost,
+		CachedPromptTokensCost:    entry.CachedPromptTokensCost,
+		CacheWriteTokensCost:      entry.CacheWriteTokensCost,
+		ReasoningTokensCost:       entry.ReasoningTokensCost,
+		AudioPromptTokensCost:     entry.AudioPromptTokensCost,
+		ImagePromptTokensCost:     entry.ImagePromptTokensCost,
+		VideoPromptTokensCost:     entry.VideoPromptTokensCost,
+		AudioCompletionTokensCost: entry.AudioCompletionTokensCost,
+		ImageCompletionTokensCost: entry.ImageCompletionTokensCost,
+		VideoCompletionTokensCost: entry.VideoCompletionTokensCost,
+		Tiers:                     tiers,
+		TierBasis:                 basis,
+		Provider:                  entry.Provider,
+		Currency:                  currency,
+		EffectiveDate:             effectiveDate,
+		EffectiveUntil:            effectiveUntil,
+		VersionID:                 entry.VersionID,
+	}, nil
+}
+
+// entryTiers validates and converts the pricing tiers of an entry
+func entryTiers(entry PriceSheetEntry) ([]PricingTier, TierBasis, error) {
+	var basis TierBasis
+	switch entry.TierBasis {
+	case "", TierByPromptTokens.String():
+		basis = TierByPromptTokens
+	case TierByVolume.String():
+		basis = TierByVolume
+	default:
+		return nil, basis, fmt.Errorf("unsupported tier_basis %q, expected prompt_tokens or volume", entry.TierBasis)
+	}
+
+	var tiers []PricingTier
+	thresholds := make(map[int]bool, len(entry.Tiers))
+	for i, tier := range entry.Tiers {
+		if tier.MinTokens <= 0 {
+			return nil, basis, fmt.Errorf("tier %d: min_tokens must be positive", i)
+		}
+		if thresholds[tier.MinTokens] {
+			return nil, basis, fmt.Errorf("tier %d: duplicate min_tokens %d", i, tier.MinTokens)
+		}
+		thresholds[tier.MinTokens] = true
+
+		for _, cost := range []float64{tier.PromptTokensCost, tier.CompletionTokensCost, tier.CachedPromptTokensCost,
+			tier.CacheWriteTokensCost, tier.ReasoningTokensCost} {
+			if !validCost(cost) {
+				return nil, basis, fmt.Errorf("tier %d: costs must be non-negative numbers", i)
+			}
+		}
+
+		tiers = append(tiers, PricingTier{
+			Name:                   tier.Name,
+			MinTokens:              tier.MinTokens,
+			PromptTokensCost:       tier.PromptTokensCost,
+			CompletionTokensCost:   tier.CompletionTokensCost,
+			CachedPromptTokensCost: tier.CachedPromptTokensCost,
+			CacheWriteTokensCost:   tier.CacheWriteTokensCost,
+			ReasoningTokensCost:    tier.ReasoningTokensCost,
+		})
+	}
+	return tiers, basis, nil
+}
+
+// LoadPriceSheet parses a price sheet and atomically installs it in the client's
+// pricing registry. The current prices are kept if the sheet is invalid.
+func (c *Client) LoadPriceSheet(r io.Reader, format PriceSheetFormat) error {
+	sheet, err := ParsePriceSheet(r, format)
+	if err != nil {
+		return err
+	}
+	return c.SetPriceSheet(sheet)
+}
+
+// LoadPriceSheetFile loads a price sheet file into the client's pricing registry.
+// The current prices are kept if the sheet is invalid.
+func (c *Client) LoadPriceSheetFile(path string) error {
+	sheet, err := LoadPriceSheetFile(path)
+	if err != nil {
+		return err
+	}
+	return c.SetPriceSheet(sheet)
+}
+
+// SetPriceSheet installs a price sheet in the client's pricing registry. It fails
+// if the registry does not support price sheets.
+func (c *Client) SetPriceSheet(sheet *PriceSheet) error {
+	registry, ok := c.pricing.(interface{ SetPriceSheet(*PriceSheet) error })
+	if !ok {
+		return fmt.Errorf("pricing registry %T does not support price sheets", c.pricing)
+	}
+	if err := registry.SetPriceSheet(sheet); err != nil {
+		return err
+	}
+
+	if sheet != nil {
+		c.logger.Infof("Loaded price sheet with %d models", len(sheet.Models))
+	}
+	return nil
+}
+
+// WatchPriceSheetFile loads a price sheet file and then checks it for changes
+// every interval until ctx is done, swapping in the new prices whenever the
+// file changes. A changed file that fails to load or validate is logged and
+// the previous prices stay active. The initial load error is returned.
+func (c *Client) WatchPriceSheetFile(ctx context.Context, path string, interval time.Duration) error {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat price sheet: %w", err)
+	}
+	if err := c.LoadPriceSheetFile(path); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		lastModTime, lastSize := info.ModTime(), info.Size()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				c.logger.Errorf("Failed to stat price sheet %s, keeping current prices: %v", path, err)
+				continue
+			}
+			if info.ModTime().Equal(lastModTime) && info.Size() == lastSize {
+				continue
+			}
+			lastModTime, lastSize = info.ModTime(), info.Size()
+
+			if err := c.LoadPriceSheetFile(path); err != nil {
+				c.logger.Errorf("Failed to reload price sheet %s, keeping current prices: %v", path, err)
+				continue
+			}
+			c.logger.Infof("Reloaded price sheet %s", path)
+		}
+	}()
+
+	return nil
+}
+
+// priceSheetFormatFromPath infers the price sheet format from a file extension
+func priceSheetFormatFromPath(path string) (PriceSheetFormat, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return PriceSheetJSON, nil
+	case ".yaml", ".yml":
+		return PriceSheetYAML, nil
+	default:
+		return "", fmt.Errorf("cannot infer price sheet format from %q, expected .json, .yaml or .yml", path)
+	}
+}
+
+// parseEffectiveDate parses a date as YYYY-MM-DD or RFC 3339
+func parseEffectiveDate(value string) (time.Time, error) {
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date, nil
+	}
+	if date, err := time.Parse(time.RFC3339, value); err == nil {
+		return date, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid effective date %q, expected YYYY-MM-DD or RFC 3339", value)
+}
+
+// validCost reports whether cost is a finite, non-negative price
+func validCost(cost float64) bool {
+	return cost >= 0 && !math.IsInf(cost, 0) && !math.IsNaN(cost)
+}
+
+// firstNonEmpty returns the first non-empty string
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,253 @@
+package paygent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPriceSheetYAML = `currency: USD
+effective_date: 2025-10-01
+models:
+  - model: gpt-4o
+    provider: OpenAI
+    prompt_tokens_cost: 0.002
+    completion_tokens_cost: 0.008
+  - model: my-finetune
+    provider: Custom
+    prompt_tokens_cost: 0.5
+    completion_tokens_cost: 1.5
+    effective_date: 2025-11-01T00:00:00Z
+`
+
+func TestParsePriceSheet(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  PriceSheetFormat
+		input   string
+		wantErr string
+	}{
+		{
+			name:   "Valid YAML",
+			format: PriceSheetYAML,
+			input:  testPriceSheetYAML,
+		},
+		{
+			name:   "Valid JSON",
+			format: PriceSheetJSON,
+			input:  `{"models":[{"model":"gpt-4o","provider":"OpenAI","prompt_tokens_cost":0.002,"completion_tokens_cost":0.008}]}`,
+		},
+		{
+			name:    "No models",
+			format:  PriceSheetJSON,
+			input:   `{"models":[]}`,
+			wantErr: "no models",
+		},
+		{
+			name:    "Missing model name",
+			format:  PriceSheetJSON,
+			input:   `{"models":[{"prompt_tokens_cost":1}]}`,
+			wantErr: "model is required",
+		},
+		{
+			name:    "Negative cost",
+			format:  PriceSheetJSON,
+			input:   `{"models":[{"model":"a","prompt_tokens_cost":-1}]}`,
+			wantErr: "prompt_tokens_cost must be a non-negative number",
+		},
+		{
+			name:    "Duplicate model",
+			format:  PriceSheetJSON,
+			input:   `{"models":[{"model":"a"},{"model":"a"}]}`,
+			wantErr: "duplicate model",
+		},
+		{
+			name:    "Duplicate effective date",
+			format:  PriceSheetJSON,
+			input:   `{"models":[{"model":"a","effective_date":"2025-01-01"},{"model":"a","effective_date":"2025-01-01"}]}`,
+			wantErr: "duplicate model",
+		},
+		{
+			name:   "Price history",
+			format: PriceSheetJSON,
+			input:  `{"models":[{"model":"a","effective_date":"2025-01-01","effective_until":"2025-02-01"},{"model":"a","effective_date":"2025-02-01"}]}`,
+		},
+		{
+			name:    "Effective until before effective date",
+			format:  PriceSheetJSON,
+			input:   `{"models":[{"model":"a","effective_date":"2025-02-01","effective_until":"2025-01-01"}]}`,
+			wantErr: "effective_until must be after",
+		},
+		{
+			name:   "Tiers",
+			format: PriceSheetYAML,
+			input:  "models:\n  - model: a\n    prompt_tokens_cost: 1\n    tiers:\n      - name: long\n        min_tokens: 200000\n        prompt_tokens_cost: 2\n",
+		},
+		{
+			name:    "Duplicate tier threshold",
+			format:  PriceSheetJSON,
+			input:   `{"models":[{"model":"a","tiers":[{"min_tokens":10},{"min_tokens":10}]}]}`,
+			wantErr: "duplicate min_tokens",
+		},
+		{
+			name:    "Unknown tier basis",
+			format:  PriceSheetJSON,
+			input:   `{"models":[{"model":"a","tier_basis":"monthly","tiers":[{"min_tokens":10}]}]}`,
+			wantErr: "unsupported tier_basis",
+		},
+		{
+			name:    "Invalid currency",
+			format:  PriceSheetJSON,
+			input:   `{"currency":"EURO","models":[{"model":"a"}]}`,
+			wantErr: "invalid currency",
+		},
+		{
+			name:    "Bad effective date",
+			format:  PriceSheetYAML,
+			input:   "models:\n  - model: a\n    effective_date: next week\n",
+			wantErr: "invalid effective date",
+		},
+		{
+			name:    "Unknown field",
+			format:  PriceSheetJSON,
+			input:   `{"models":[{"model":"a","prompt_cost":1}]}`,
+			wantErr: "unknown field",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePriceSheet(strings.NewReader(tt.input), tt.format)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ParsePriceSheet() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParsePriceSheet() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadPriceSheet(t *testing.T) {
+	client := NewClient("test-api-key")
+	if err := client.LoadPriceSheet(strings.NewReader(testPriceSheetYAML), PriceSheetYAML); err != nil {
+		t.Fatalf("LoadPriceSheet() error = %v", err)
+	}
+
+	pricing, source, _, _ := client.LookupPricing("my-finetune")
+	if source != PricingSourcePriceSheet {
+		t.Errorf("Expected price sheet source, got %v", source)
+	}
+	if pricing.PromptTokensCost != 0.5 || pricing.Provider != Custom || pricing.Currency != "USD" {
+		t.Errorf("Unexpected pricing %+v", pricing)
+	}
+	if want := time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC); !pricing.EffectiveDate.Equal(want) {
+		t.Errorf("Expected effective date %v, got %v", want, pricing.EffectiveDate)
+	}
+	if pricing, _, _, _ := client.LookupPricing(GPT4O); !pricing.EffectiveDate.Equal(time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected sheet effective date on GPT-4o, got %v", pricing.EffectiveDate)
+	}
+
+	// Overrides still win over the sheet, and defaults fill in the rest
+	client.RegisterModelPricing(GPT4O, ModelPricing{PromptTokensCost: 1})
+	if _, source, _, _ := client.LookupPricing(GPT4O); source != PricingSourceOverride {
+		t.Errorf("Expected override source for GPT-4o, got %v", source)
+	}
+	if _, source, _, _ := client.LookupPricing(GPT5); source != PricingSourceDefault {
+		t.Errorf("Expected default source for GPT-5, got %v", source)
+	}
+
+	// An invalid sheet keeps the current prices
+	if err := client.LoadPriceSheet(strings.NewReader(`{"models":[]}`), PriceSheetJSON); err == nil {
+		t.Error("Expected error loading an empty price sheet")
+	}
+	if _, source, _, _ := client.LookupPricing("my-finetune"); source != PricingSourcePriceSheet {
+		t.Errorf("Expected previous price sheet to stay active, got %v", source)
+	}
+}
+
+func TestPriceSheetHistory(t *testing.T) {
+	const sheet = `models:
+  - model: my-finetune
+    prompt_tokens_cost: 1
+    effective_date: 2025-01-01
+    version_id: 2025-q1
+  - model: my-finetune
+    prompt_tokens_cost: 2
+    effective_date: 2025-04-01
+`
+	client := NewClient("test-api-key")
+	if err := client.LoadPriceSheet(strings.NewReader(sheet), PriceSheetYAML); err != nil {
+		t.Fatalf("LoadPriceSheet() error = %v", err)
+	}
+
+	tests := []struct {
+		at              time.Time
+		expectedPrompt  float64
+		expectedVersion string
+	}{
+		{at: time.Date(2025, 2, 15, 0, 0, 0, 0, time.UTC), expectedPrompt: 1, expectedVersion: "2025-q1"},
+		{at: time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC), expectedPrompt: 2, expectedVersion: "price_sheet:my-finetune@2025-04-01"},
+	}
+	for _, tt := range tests {
+		pricing, source, _, _ := client.LookupPricingAt("my-finetune", tt.at)
+		if pricing.PromptTokensCost != tt.expectedPrompt {
+			t.Errorf("At %v: expected prompt cost %v, got %v", tt.at, tt.expectedPrompt, pricing.PromptTokensCost)
+		}
+		if version := PricingVersionID("my-finetune", pricing, source); version != tt.expectedVersion {
+			t.Errorf("At %v: expected version %q, got %q", tt.at, tt.expectedVersion, version)
+		}
+	}
+}
+
+func TestWatchPriceSheetFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.json")
+	write := func(content string, modTime time.Time) {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, modTime, modTime)
+	}
+	client := NewClient("test-api-key")
+	start := time.Now().Add(-time.Hour)
+	write(`{"models":[{"model":"m","prompt_tokens_cost":1}]}`, start)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := client.WatchPriceSheetFile(ctx, path, 10*time.Millisecond); err != nil {
+		t.Fatalf("WatchPriceSheetFile() error = %v", err)
+	}
+
+	promptCost := func() float64 {
+		pricing, _, _, _ := client.LookupPricing("m")
+		return pricing.PromptTokensCost
+	}
+	eventually := func(want float64) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for promptCost() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected prompt cost %v, got %v", want, promptCost())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	eventually(1)
+
+	write(`{"models":[{"model":"m","prompt_tokens_cost":2}]}`, start.Add(time.Minute))
+	eventually(2)
+
+	// A broken file is ignored and the previous table stays active
+	write(`{"models":[{"model":"m","prompt_tokens_cost":-3}]}`, start.Add(2*time.Minute))
+	time.Sleep(50 * time.Millisecond)
+	eventually(2)
+
+	write(`{"models":[{"model":"m","prompt_tokens_cost":4}]}`, start.Add(3*time.Minute))
+	eventually(4)
+}
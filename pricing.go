@@ -0,0 +1,315 @@
+package paygent
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PricingSource tells where the price used for a model came from
+type PricingSource int
+
+const (
+	// PricingSourceDefault is the SDK's built-in price table
+	PricingSourceDefault PricingSource = iota
+	// PricingSourceOverride is a price registered at runtime
+	PricingSourceOverride
+	// PricingSourceFallback is the price used for models the registry does not know
+	PricingSourceFallback
+	// PricingSourcePriceSheet is a price loaded from a price sheet
+	PricingSourcePriceSheet
+	// PricingSourceServer marks usage for an unknown model left for the Paygent server to price
+	PricingSourceServer
+)
+
+// String returns the name of the pricing source
+func (s PricingSource) String() string {
+	switch s {
+	case PricingSourceDefault:
+		return "default"
+	case PricingSourceOverride:
+		return "override"
+	case PricingSourceFallback:
+		return "fallback"
+	case PricingSourcePriceSheet:
+		return "price_sheet"
+	case PricingSourceServer:
+		return "server"
+	default:
+		return "unknown"
+	}
+}
+
+// TierBasis is the measure a model's pricing tiers are keyed on
+type TierBasis int
+
+const (
+	// TierByPromptTokens selects the tier from the prompt size of each event
+	TierByPromptTokens TierBasis = iota
+	// TierByVolume selects the tier from the cumulative tokens (prompt and
+	// completion) the client has priced for the model before the event
+	TierByVolume
+)
+
+// String returns the name of the tier basis
+func (b TierBasis) String() string {
+	switch b {
+	case TierByPromptTokens:
+		return "prompt_tokens"
+	case TierByVolume:
+		return "volume"
+	default:
+		return "unknown"
+	}
+}
+
+// PricingTier replaces a model's rates once the tier measure reaches MinTokens,
+// e.g. the long-context rates of Gemini 2.5 Pro above 200k prompt tokens
+// (MinTokens 200001). The threshold is inclusive.
+// Rates are per 1000 tokens; a zero rate keeps the model's base rate.
+type PricingTier struct {
+	// Name identifies the tier in logs and usage records (default ">=MinTokens")
+	Name      string
+	MinTokens int
+
+	PromptTokensCost       float64
+	CompletionTokensCost   float64
+	CachedPromptTokensCost float64
+	CacheWriteTokensCost   float64
+	ReasoningTokensCost    float64
+}
+
+// name returns the tier name, derived from its threshold when not set
+func (t PricingTier) name() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return fmt.Sprintf(">=%d", t.MinTokens)
+}
+
+// tierFor returns the tier with the highest threshold reached by measure
+func (p ModelPricing) tierFor(measure int) (PricingTier, bool) {
+	var (
+		selected PricingTier
+		found    bool
+	)
+	for _, tier := range p.Tiers {
+		if measure >= tier.MinTokens && (!found || tier.MinTokens > selected.MinTokens) {
+			selected, found = tier, true
+		}
+	}
+	return selected, found
+}
+
+// withTier returns the pricing with the tier's rates applied
+func (p ModelPricing) withTier(tier PricingTier) ModelPricing {
+	p.PromptTokensCost = rateOr(tier.PromptTokensCost, p.PromptTokensCost)
+	p.CompletionTokensCost = rateOr(tier.CompletionTokensCost, p.CompletionTokensCost)
+	p.CachedPromptTokensCost = rateOr(tier.CachedPromptTokensCost, p.CachedPromptTokensCost)
+	p.CacheWriteTokensCost = rateOr(tier.CacheWriteTokensCost, p.CacheWriteTokensCost)
+	p.ReasoningTokensCost = rateOr(tier.ReasoningTokensCost, p.ReasoningTokensCost)
+	return p
+}
+
+// fallbackModelPricing is the default price of models that have no registered price (per 1000 tokens)
+var fallbackModelPricing = ModelPricing{
+	PromptTokensCost:     0.1, // $0.10 per 1000 tokens
+	CompletionTokensCost: 0.1, // $0.10 per 1000 tokens
+}
+
+// PricingRegistry resolves the price of a model.
+// Implementations must be safe for concurrent use.
+type PricingRegistry interface {
+	// Lookup returns the price of model in force at the given time and where it came from.
+	// ok is false when the registry has no price for the model at that time.
+	Lookup(model string, at time.Time) (pricing ModelPricing, source PricingSource, ok bool)
+	// Register adds a price version for model, replacing any version with the same EffectiveDate
+	Register(model string, pricing ModelPricing)
+}
+
+// pricingVersions maps each model to its price versions, ordered by EffectiveDate
+type pricingVersions map[string][]ModelPricing
+
+// at returns the version of model in force at the given time. When versions
+// overlap, the one that took effect most recently wins.
+func (v pricingVersions) at(model string, at time.Time) (ModelPricing, bool) {
+	versions := v[model]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].inEffect(at) {
+			return versions[i], true
+		}
+	}
+	return ModelPricing{}, false
+}
+
+// add inserts a version, replacing any version of the model with the same EffectiveDate
+func (v pricingVersions) add(model string, pricing ModelPricing) {
+	versions := v[model]
+	i := sort.Search(len(versions), func(i int) bool {
+		return !versions[i].EffectiveDate.Before(pricing.EffectiveDate)
+	})
+	if i < len(versions) && versions[i].EffectiveDate.Equal(pricing.EffectiveDate) {
+		versions[i] = pricing
+		return
+	}
+	versions = append(versions, ModelPricing{})
+	copy(versions[i+1:], versions[i:])
+	versions[i] = pricing
+	v[model] = versions
+}
+
+// inEffect reports whether the price applies at the given time
+func (p ModelPricing) inEffect(at time.Time) bool {
+	if !p.EffectiveDate.IsZero() && at.Before(p.EffectiveDate) {
+		return false
+	}
+	return p.EffectiveUntil.IsZero() || at.Before(p.EffectiveUntil)
+}
+
+// InMemoryPricingRegistry is the default PricingRegistry. It holds the built-in
+// price table, an optional price sheet and runtime overrides. Overrides take
+// precedence over the price sheet, which takes precedence over the defaults.
+// Each layer may hold several effective-dated versions of a model's price;
+// a layer with no version in force at the requested time is skipped.
+type InMemoryPricingRegistry struct {
+	mu        sync.RWMutex
+	defaults  pricingVersions
+	sheet     pricingVersions
+	overrides pricingVersions
+}
+
+// NewPricingRegistry creates an in-memory pricing registry seeded with the SDK's default prices
+func NewPricingRegistry() *InMemoryPricingRegistry {
+	defaults := make(pricingVersions, len(defaultModelPricing))
+	for model, pricing := range defaultModelPricing {
+		defaults.add(model, pricing)
+	}
+
+	return &InMemoryPricingRegistry{
+		defaults:  defaults,
+		overrides: make(pricingVersions),
+	}
+}
+
+// Lookup returns the price of model in force at the given time, preferring
+// overrides, then the price sheet, then the defaults
+func (r *InMemoryPricingRegistry) Lookup(model string, at time.Time) (ModelPricing, PricingSource, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if pricing, exists := r.overrides.at(model, at); exists {
+		return pricing, PricingSourceOverride, true
+	}
+	if pricing, exists := r.sheet.at(model, at); exists {
+		return pricing, PricingSourcePriceSheet, true
+	}
+	if pricing, exists := r.defaults.at(model, at); exists {
+		return pricing, PricingSourceDefault, true
+	}
+	return ModelPricing{}, PricingSourceFallback, false
+}
+
+// Register adds an override price version for model
+func (r *InMemoryPricingRegistry) Register(model string, pricing ModelPricing) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.overrides.add(model, pricing)
+}
+
+// Unregister removes every override version for model, restoring its default price if it has one
+func (r *InMemoryPricingRegistry) Unregister(model string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.overrides, model)
+}
+
+// SetPriceSheet atomically replaces the registry's price sheet layer with the
+// prices from sheet. A nil sheet removes the layer. The sheet is validated first
+// and the current prices are kept if it is invalid.
+func (r *InMemoryPricingRegistry) SetPriceSheet(sheet *PriceSheet) error {
+	var table pricingVersions
+	if sheet != nil {
+		var err error
+		if table, err = sheet.pricingTable(); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sheet = table
+	return nil
+}
+
+// Models returns every model with a price, sorted by name
+func (r *InMemoryPricingRegistry) Models() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	seen := make(map[string]bool, len(r.defaults))
+	var models []string
+	for _, layer := range []pricingVersions{r.defaults, r.sheet, r.overrides} {
+		for model := range layer {
+			if !seen[model] {
+				seen[model] = true
+				models = append(models, model)
+			}
+		}
+	}
+	sort.Strings(models)
+	return models
+}
+
+// SetPricingRegistry replaces the registry the client prices usage with
+func (c *Client) SetPricingRegistry(registry PricingRegistry) {
+	c.pricing = registry
+}
+
+// GetPricingRegistry returns the registry the client prices usage with
+func (c *Client) GetPricingRegistry() PricingRegistry {
+	return c.pricing
+}
+
+// RegisterModelPricing sets or overrides the price of a model for this client
+func (c *Client) RegisterModelPricing(model string, pricing ModelPricing) {
+	c.pricing.Register(model, pricing)
+}
+
+// LookupPricing returns the price the client currently bills model at, where it
+// came from and the model the price belongs to. It resolves the model the same
+// way usage is priced: aliases and provider-native IDs map to their canonical
+// model, and unknown models follow the unknown model policy.
+func (c *Client) LookupPricing(model string) (pricing ModelPricing, source PricingSource, pricedAs string, err error) {
+	return c.LookupPricingAt(model, time.Now())
+}
+
+// LookupPricingAt returns the price the client bills model at the given time,
+// where it came from and the model the price belongs to
+func (c *Client) LookupPricingAt(model string, at time.Time) (pricing ModelPricing, source PricingSource, pricedAs string, err error) {
+	return c.resolvePricing(model, at)
+}
+
+// PricingVersionID returns the identifier of a price version for audit records.
+// It is pricing.VersionID when set, otherwise it is derived from the source,
+// model and effective date, e.g. "price_sheet:gpt-4o@2025-10-01".
+func PricingVersionID(model string, pricing ModelPricing, source PricingSource) string {
+	if pricing.VersionID != "" {
+		return pricing.VersionID
+	}
+	if source == PricingSourceFallback || source == PricingSourceServer {
+		return source.String()
+	}
+	id := source.String() + ":" + model
+	if effective := pricing.EffectiveDate.UTC(); !pricing.EffectiveDate.IsZero() {
+		if effective.Equal(effective.Truncate(24 * time.Hour)) {
+			id += "@" + effective.Format("2006-01-02")
+		} else {
+			id += "@" + effective.Format(time.RFC3339)
+		}
+	}
+	return id
+}
@@ -0,0 +1,163 @@
+package paygent
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLookupPricing(t *testing.T) {
+	client := NewClient("test-api-key")
+	client.RegisterModelPricing(GPT4O, ModelPricing{PromptTokensCost: 0.001, CompletionTokensCost: 0.002})
+	client.RegisterModelPricing("my-finetune", ModelPricing{PromptTokensCost: 0.5, CompletionTokensCost: 0.5})
+
+	tests := []struct {
+		name           string
+		model          string
+		expectedSource PricingSource
+		expectedPrompt float64
+		expectedModel  string
+	}{
+		{name: "Default", model: GPT5, expectedSource: PricingSourceDefault, expectedPrompt: 0.00125, expectedModel: GPT5},
+		{name: "Override of default", model: GPT4O, expectedSource: PricingSourceOverride, expectedPrompt: 0.001, expectedModel: GPT4O},
+		{name: "New model", model: "my-finetune", expectedSource: PricingSourceOverride, expectedPrompt: 0.5, expectedModel: "my-finetune"},
+		{name: "Provider-native ID", model: "claude-sonnet-4-5-20250929", expectedSource: PricingSourceDefault, expectedPrompt: 0.003, expectedModel: Sonnet45},
+		{name: "Unknown model", model: "unknown-model", expectedSource: PricingSourceFallback, expectedPrompt: 0.1, expectedModel: "unknown-model"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pricing, source, pricedAs, err := client.LookupPricing(tt.model)
+			if err != nil {
+				t.Fatalf("LookupPricing() error = %v", err)
+			}
+			if source != tt.expectedSource {
+				t.Errorf("LookupPricing() source = %v, want %v", source, tt.expectedSource)
+			}
+			if pricing.PromptTokensCost != tt.expectedPrompt {
+				t.Errorf("LookupPricing() prompt cost = %v, want %v", pricing.PromptTokensCost, tt.expectedPrompt)
+			}
+			if pricedAs != tt.expectedModel {
+				t.Errorf("LookupPricing() priced as %q, want %q", pricedAs, tt.expectedModel)
+			}
+		})
+	}
+
+	// Lookups follow the unknown model policy like usage does
+	client.SetUnknownModelPolicy(UnknownModelReject)
+	if _, _, _, err := client.LookupPricing("unknown-model"); !errors.Is(err, ErrUnknownModel) {
+		t.Errorf("Expected ErrUnknownModel, got %v", err)
+	}
+
+	// Overrides are per client
+	if _, source, _, _ := NewClient("other-key").LookupPricing(GPT4O); source != PricingSourceDefault {
+		t.Errorf("Expected another client to keep the default price, got %v", source)
+	}
+}
+
+func TestPricingRegistryUnregister(t *testing.T) {
+	registry := NewPricingRegistry()
+	registry.Register(GPT4O, ModelPricing{PromptTokensCost: 1})
+	registry.Unregister(GPT4O)
+
+	pricing, source, ok := registry.Lookup(GPT4O, time.Now())
+	if !ok || source != PricingSourceDefault || pricing.PromptTokensCost != 0.0025 {
+		t.Errorf("Expected default GPT-4o price after Unregister, got %+v (%v, %v)", pricing, source, ok)
+	}
+}
+
+func TestPricingVersions(t *testing.T) {
+	may := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	june := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	client := NewClient("test-api-key")
+	client.RegisterModelPricing("my-finetune", ModelPricing{PromptTokensCost: 1, EffectiveDate: may, EffectiveUntil: june, VersionID: "v1"})
+	client.RegisterModelPricing("my-finetune", ModelPricing{PromptTokensCost: 2, EffectiveDate: june})
+	// A price for GPT-4o that only applied in May
+	client.RegisterModelPricing(GPT4O, ModelPricing{PromptTokensCost: 3, EffectiveDate: may, EffectiveUntil: june})
+
+	tests := []struct {
+		name            string
+		model           string
+		at              time.Time
+		expectedSource  PricingSource
+		expectedPrompt  float64
+		expectedVersion string
+	}{
+		{name: "Before first version", model: "my-finetune", at: may.Add(-time.Hour), expectedSource: PricingSourceFallback, expectedPrompt: 0.1, expectedVersion: "fallback"},
+		{name: "First version", model: "my-finetune", at: may.Add(time.Hour), expectedSource: PricingSourceOverride, expectedPrompt: 1, expectedVersion: "v1"},
+		{name: "Current version", model: "my-finetune", at: june, expectedSource: PricingSourceOverride, expectedPrompt: 2, expectedVersion: "override:my-finetune@2025-06-01"},
+		{name: "Historical override", model: GPT4O, at: may, expectedSource: PricingSourceOverride, expectedPrompt: 3},
+		{name: "Expired override falls through", model: GPT4O, at: june, expectedSource: PricingSourceDefault, expectedPrompt: 0.0025, expectedVersion: "default:gpt-4o"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pricing, source, _, _ := client.LookupPricingAt(tt.model, tt.at)
+			if source != tt.expectedSource {
+				t.Errorf("LookupPricingAt() source = %v, want %v", source, tt.expectedSource)
+			}
+			if pricing.PromptTokensCost != tt.expectedPrompt {
+				t.Errorf("LookupPricingAt() prompt cost = %v, want %v", pricing.PromptTokensCost, tt.expectedPrompt)
+			}
+			if version := PricingVersionID(tt.model, pricing, source); tt.expectedVersion != "" && version != tt.expectedVersion {
+				t.Errorf("PricingVersionID() = %q, want %q", version, tt.expectedVersion)
+			}
+		})
+	}
+
+	// Registering a version with the same effective date replaces it
+	client.RegisterModelPricing("my-finetune", ModelPricing{PromptTokensCost: 4, EffectiveDate: june})
+	if pricing, _, _, _ := client.LookupPricing("my-finetune"); pricing.PromptTokensCost != 4 {
+		t.Errorf("Expected replaced version to apply, got %v", pricing.PromptTokensCost)
+	}
+}
+
+func TestBuildUsageRequestHistoricalPricing(t *testing.T) {
+	may := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	june := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	client := NewClient("test-api-key")
+	client.RegisterModelPricing("my-finetune", ModelPricing{PromptTokensCost: 1, EffectiveDate: may, VersionID: "may-prices"})
+	client.RegisterModelPricing("my-finetune", ModelPricing{PromptTokensCost: 2, EffectiveDate: june, VersionID: "june-prices"})
+
+	occurredAt := may.Add(10 * 24 * time.Hour)
+	request, err := client.BuildUsageRequest("agent", "customer", "indicator", UsageData{
+		Model:        "my-finetune",
+		PromptTokens: 1000,
+		OccurredAt:   occurredAt,
+	})
+	if err != nil {
+		t.Fatalf("BuildUsageRequest() error = %v", err)
+	}
+	if request.Amount != MoneyFromFloat(1) || request.PricingVersion != "may-prices" {
+		t.Errorf("Expected May pricing, got amount %v with version %q", request.Amount, request.PricingVersion)
+	}
+	if !request.OccurredAt.Equal(occurredAt) {
+		t.Errorf("Expected occurredAt %v, got %v", occurredAt, request.OccurredAt)
+	}
+
+	// Usage without a timestamp is priced now
+	request, _ = client.BuildUsageRequest("agent", "customer", "indicator", UsageData{Model: "my-finetune", PromptTokens: 1000})
+	if request.Amount != MoneyFromFloat(2) || request.PricingVersion != "june-prices" || request.OccurredAt.IsZero() {
+		t.Errorf("Expected current pricing, got %+v", request)
+	}
+}
+
+func TestPricingRegistryConcurrentUpdates(t *testing.T) {
+	client := NewClient("test-api-key")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				client.RegisterModelPricing(GPT4O, ModelPricing{PromptTokensCost: float64(i)})
+				client.calculateCost(GPT4O, UsageData{PromptTokens: 1000})
+			}
+		}(i)
+	}
+	wg.Wait()
+}
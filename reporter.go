@@ -0,0 +1,360 @@
+package paygent
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is reported when a usage event is dropped because the reporter queue is full
+var ErrQueueFull = errors.New("paygent: reporter queue is full")
+
+// ErrReporterClosed is returned when reporting to a reporter that has been closed
+var ErrReporterClosed = errors.New("paygent: reporter is closed")
+
+// ReporterConfig configures an asynchronous usage Reporter.
+// Zero values are replaced with the defaults noted on each field.
+type ReporterConfig struct {
+	// QueueSize is the maximum number of events buffered in memory (default 1000)
+	QueueSize int
+	// BatchSize is the number of events that triggers a flush (default 100)
+	BatchSize int
+	// FlushInterval is the maximum time an event waits before being flushed (default 5s)
+	FlushInterval time.Duration
+	// Workers is the number of goroutines sending flushed batches (default 1)
+	Workers int
+	// OnError is called for every event that is dropped or fails to send.
+	// It runs on the reporter's goroutines and should not block.
+	// When nil, failures are logged with the client's logger.
+	OnError func(record APIRequest, err error)
+}
+
+// Reporter sends usage events to the Paygent API in the background.
+// Events are priced on the caller's goroutine, buffered in a bounded
+// queue and flushed by worker goroutines when the batch size or flush
+// interval is reached. A Reporter must be closed with Close.
+type Reporter struct {
+	client *Client
+	config ReporterConfig
+
+	queue    chan APIRequest
+	batches  chan []APIRequest
+	flushReq chan chan struct{}
+
+	// ctx is canceled when Close gives up waiting, aborting in-flight sends
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	closed   bool
+	pending  int
+	idle     chan struct{} // closed whenever pending == 0
+	reserved int           // queue slots reserved by enqueue but not filled yet
+	// sending tracks enqueue calls between reserving a slot and filling it,
+	// which Close waits for before closing the queue
+	sending sync.WaitGroup
+
+	dispatcherDone chan struct{}
+	workersDone    chan struct{}
+}
+
+// NewReporter creates a Reporter on top of client and starts its background goroutines
+func NewReporter(client *Client, config ReporterConfig) *Reporter {
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1000
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 5 * time.Second
+	}
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	idle := make(chan struct{})
+	close(idle)
+
+	r := &Reporter{
+		client:         client,
+		config:         config,
+		queue:          make(chan APIRequest, config.QueueSize),
+		batches:        make(chan []APIRequest, config.Workers),
+		flushReq:       make(chan chan struct{}),
+		ctx:            ctx,
+		cancel:         cancel,
+		idle:           idle,
+		dispatcherDone: make(chan struct{}),
+		workersDone:    make(chan struct{}),
+	}
+
+	go r.dispatch()
+
+	var wg sync.WaitGroup
+	for i := 0; i < config.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.work()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(r.workersDone)
+	}()
+
+	if client.spool != nil {
+		r.replay()
+	}
+
+	return r
+}
+
+// replay queues the events a previous run left in the client's spool.
+// Events that do not fit in the queue stay spooled for the next replay.
+func (r *Reporter) replay() {
+	records := r.client.spool.Pending()
+	if len(records) == 0 {
+		return
+	}
+
+	r.client.logger.Infof("Replaying %d spooled usage events", len(records))
+	for i, apiRequest := range records {
+		if err := r.enqueue(apiRequest); err != nil {
+			r.client.logger.Warnf("Stopped replaying spooled usage events, %d stay spooled: %v", len(records)-i, err)
+			return
+		}
+	}
+}
+
+// Report prices usage data and queues it for background delivery.
+// It returns ErrQueueFull if the event was dropped and ErrReporterClosed after Close.
+func (r *Reporter) Report(agentID, customerID, indicator string, usageData UsageData) error {
+	apiRequest, err := r.client.BuildUsageRequest(agentID, customerID, indicator, usageData)
+	if err != nil {
+		return err
+	}
+	return r.report(apiRequest)
+}
+
+// ReportWithTokenString tokenizes and prices string usage data and queues it for background delivery
+func (r *Reporter) ReportWithTokenString(agentID, customerID, indicator string, usageData UsageDataWithStrings) error {
+	apiRequest, err := r.client.BuildUsageRequestFromStrings(agentID, customerID, indicator, usageData)
+	if err != nil {
+		return err
+	}
+	return r.report(apiRequest)
+}
+
+// ReportWithMessages tokenizes and prices message usage data and queues it for background delivery
+func (r *Reporter) ReportWithMessages(agentID, customerID, indicator string, usageData UsageDataWithMessages) error {
+	apiRequest, err := r.client.BuildUsageRequestFromMessages(agentID, customerID, indicator, usageData)
+	if err != nil {
+		return err
+	}
+	return r.report(apiRequest)
+}
+
+// Flush sends every event queued before the call and waits until they have
+// been delivered or reported as failed, or until ctx is done.
+func (r *Reporter) Flush(ctx context.Context) error {
+	ack := make(chan struct{})
+	select {
+	case r.flushReq <- ack:
+		select {
+		case <-ack:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	case <-r.dispatcherDone:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	r.mu.Lock()
+	idle := r.idle
+	r.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting events, flushes everything still queued and waits for
+// the workers to finish. If ctx is done first, in-flight sends are aborted,
+// the remaining events are reported through OnError and ctx.Err() is returned.
+func (r *Reporter) Close(ctx context.Context) error {
+	r.mu.Lock()
+	closing := !r.closed
+	r.closed = true
+	r.mu.Unlock()
+	if closing {
+		r.sending.Wait()
+		close(r.queue)
+	}
+
+	select {
+	case <-r.workersDone:
+		r.cancel()
+		return nil
+	case <-ctx.Done():
+		r.cancel()
+		<-r.workersDone
+		return ctx.Err()
+	}
+}
+
+// report queues a freshly priced event. An event dropped because the queue is
+// full is reported through OnError, and a rejected event gives back the free
+// allowance it used.
+func (r *Reporter) report(apiRequest APIRequest) error {
+	err := r.enqueue(apiRequest)
+	if errors.Is(err, ErrQueueFull) {
+		r.client.logger.Warnf("Reporter queue full, dropping usage event for agentID=%s, customerID=%s",
+			apiRequest.AgentID, apiRequest.CustomerID)
+		r.reportError(apiRequest, err)
+	}
+	if err != nil {
+		r.client.refundAllowance(apiRequest)
+	}
+	return err
+}
+
+// enqueue adds a priced request to the queue without blocking. With a spool
+// configured on the client an accepted event is persisted before it is
+// queued; an event rejected because the reporter is closed or the queue is
+// full is not spooled, so it is never replayed. The queue slot is reserved
+// under r.mu and the event is spooled and queued outside it, so callers do
+// not wait on each other's disk writes.
+func (r *Reporter) enqueue(apiRequest APIRequest) error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return ErrReporterClosed
+	}
+	// Only reserved slots are filled, and the dispatcher only frees them
+	if len(r.queue)+r.reserved >= cap(r.queue) {
+		r.mu.Unlock()
+		return ErrQueueFull
+	}
+	r.reserved++
+	r.sending.Add(1)
+	if r.pending == 0 {
+		r.idle = make(chan struct{})
+	}
+	r.pending++
+	r.mu.Unlock()
+
+	r.client.spoolAppend(apiRequest)
+	r.queue <- apiRequest
+
+	r.mu.Lock()
+	r.reserved--
+	r.mu.Unlock()
+	r.sending.Done()
+	return nil
+}
+
+// dispatch groups queued events into batches on size, interval and flush triggers
+func (r *Reporter) dispatch() {
+	defer close(r.dispatcherDone)
+	defer close(r.batches)
+
+	ticker := time.NewTicker(r.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]APIRequest, 0, r.config.BatchSize)
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		r.batches <- batch
+		batch = make([]APIRequest, 0, r.config.BatchSize)
+	}
+
+	for {
+		select {
+		case apiRequest, ok := <-r.queue:
+			if !ok {
+				send()
+				return
+			}
+			batch = append(batch, apiRequest)
+			if len(batch) >= r.config.BatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case ack := <-r.flushReq:
+		drain:
+			for {
+				select {
+				case apiRequest, ok := <-r.queue:
+					if !ok {
+						break drain
+					}
+					batch = append(batch, apiRequest)
+					if len(batch) >= r.config.BatchSize {
+						send()
+					}
+				default:
+					break drain
+				}
+			}
+			send()
+			close(ack)
+		}
+	}
+}
+
+// work sends batches until the batch channel is closed
+func (r *Reporter) work() {
+	for batch := range r.batches {
+		r.send(batch)
+	}
+}
+
+// send delivers a batch and marks its events as no longer pending
+func (r *Reporter) send(batch []APIRequest) {
+	result, err := r.client.SendUsageBatch(r.ctx, batch)
+	switch {
+	case result != nil:
+		for i, item := range result.Results {
+			if !item.Success {
+				r.reportError(batch[i], item.Err)
+			}
+		}
+	case err != nil:
+		for _, apiRequest := range batch {
+			r.reportError(apiRequest, err)
+		}
+	}
+	r.done(len(batch))
+}
+
+// done decrements the pending counter and wakes Flush callers when it reaches zero
+func (r *Reporter) done(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pending -= n
+	if r.pending == 0 {
+		close(r.idle)
+	}
+}
+
+// reportError hands a failed event to the configured error callback
+func (r *Reporter) reportError(apiRequest APIRequest, err error) {
+	if r.config.OnError != nil {
+		r.config.OnError(apiRequest, err)
+		return
+	}
+	r.client.logger.Errorf("Failed to deliver usage event for agentID=%s, customerID=%s: %v",
+		apiRequest.AgentID, apiRequest.CustomerID, err)
+}
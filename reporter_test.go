@@ -0,0 +1,267 @@
+package paygent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReporterFlush(t *testing.T) {
+	var received int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body batchRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		atomic.AddInt32(&received, int32(len(body.Events)))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClientWithURL("test-api-key", server.URL)
+	reporter := NewReporter(client, ReporterConfig{
+		BatchSize:     10,
+		FlushInterval: time.Hour,
+		Workers:       2,
+	})
+
+	usageData := UsageData{Model: GPT4O, PromptTokens: 100, CompletionTokens: 50}
+	for i := 0; i < 25; i++ {
+		if err := reporter.Report("agent", "customer", "indicator", usageData); err != nil {
+			t.Fatalf("Report() error = %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := reporter.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if got := atomic.LoadInt32(&received); got != 25 {
+		t.Errorf("Expected 25 events delivered after Flush, got %d", got)
+	}
+
+	if err := reporter.Close(ctx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := reporter.Report("agent", "customer", "indicator", usageData); !errors.Is(err, ErrReporterClosed) {
+		t.Errorf("Expected ErrReporterClosed after Close, got %v", err)
+	}
+}
+
+func TestReporterIntervalFlush(t *testing.T) {
+	delivered := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- struct{}{}
+	}))
+	defer server.Close()
+
+	client := NewClientWithURL("test-api-key", server.URL)
+	reporter := NewReporter(client, ReporterConfig{FlushInterval: 20 * time.Millisecond})
+	defer reporter.Close(context.Background())
+
+	if err := reporter.Report("agent", "customer", "indicator", UsageData{Model: GPT4O, PromptTokens: 1}); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected event to be delivered by the interval flush")
+	}
+}
+
+func TestReporterQueueFullAndFailures(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	var mu sync.Mutex
+	var errs []error
+	client := NewClientWithURL("test-api-key", server.URL)
+	reporter := NewReporter(client, ReporterConfig{
+		QueueSize:     1,
+		BatchSize:     1,
+		FlushInterval: time.Hour,
+		OnError: func(record APIRequest, err error) {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		},
+	})
+
+	usageData := UsageData{Model: GPT4O, PromptTokens: 100}
+	var dropped int
+	for i := 0; i < 10; i++ {
+		if err := reporter.Report("agent", "customer", "indicator", usageData); errors.Is(err, ErrQueueFull) {
+			dropped++
+		}
+	}
+	if dropped == 0 {
+		t.Error("Expected some events to be dropped with ErrQueueFull")
+	}
+
+	close(release)
+	if err := reporter.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(errs) != 10 {
+		t.Errorf("Expected 10 errors reported (drops and failures), got %d", len(errs))
+	}
+}
+
+func TestReporterSpoolsOnlyAcceptedEvents(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	spool, err := OpenSpool(SpoolConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("OpenSpool() error = %v", err)
+	}
+	defer spool.Close()
+
+	client := NewClientWithURL("test-api-key", server.URL)
+	client.SetSpool(spool)
+	reporter := NewReporter(client, ReporterConfig{
+		QueueSize:     1,
+		BatchSize:     1,
+		FlushInterval: time.Hour,
+		OnError:       func(APIRequest, error) {},
+	})
+
+	usageData := UsageData{Model: GPT4O, PromptTokens: 100}
+	var accepted int
+	for i := 0; i < 10; i++ {
+		if err := reporter.Report("agent", "customer", "indicator", usageData); err == nil {
+			accepted++
+		}
+	}
+	if accepted == 10 {
+		t.Fatal("Expected some events to be dropped with ErrQueueFull")
+	}
+	if spool.Len() != accepted {
+		t.Errorf("Expected only the %d accepted events to be spooled, got %d", accepted, spool.Len())
+	}
+
+	close(release)
+	if err := reporter.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := reporter.Report("agent", "customer", "indicator", usageData); !errors.Is(err, ErrReporterClosed) {
+		t.Fatalf("Expected ErrReporterClosed after Close, got %v", err)
+	}
+	if spool.Len() != 0 {
+		t.Errorf("Expected delivered events to be acknowledged and rejected ones never spooled, got %d pending", spool.Len())
+	}
+}
+
+func TestReporterReplayKeepsOverflowSpooled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	spool, err := OpenSpool(SpoolConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("OpenSpool() error = %v", err)
+	}
+	defer spool.Close()
+	for i := 0; i < 10; i++ {
+		if err := spool.Append(testEvent(i)); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	client := NewClientWithURL("test-api-key", server.URL)
+	client.SetSpool(spool)
+	var reported []error
+	reporter := NewReporter(client, ReporterConfig{
+		QueueSize:     1,
+		BatchSize:     1,
+		FlushInterval: time.Hour,
+		OnError:       func(_ APIRequest, err error) { reported = append(reported, err) },
+	})
+
+	// Events that did not fit in the queue are not dropped, so they are not reported
+	close(release)
+	if err := reporter.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if len(reported) != 0 {
+		t.Errorf("Expected no events to be reported as failed, got %v", reported)
+	}
+	if spool.Len() == 0 {
+		t.Error("Expected the events that did not fit in the queue to stay spooled")
+	}
+}
+
+func TestReporterConcurrentReportAndClose(t *testing.T) {
+	var received int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body batchRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		atomic.AddInt32(&received, int32(len(body.Events)))
+	}))
+	defer server.Close()
+
+	spool, err := OpenSpool(SpoolConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("OpenSpool() error = %v", err)
+	}
+	defer spool.Close()
+
+	client := NewClientWithURL("test-api-key", server.URL)
+	client.SetSpool(spool)
+	reporter := NewReporter(client, ReporterConfig{QueueSize: 8, BatchSize: 4, FlushInterval: time.Millisecond})
+
+	// Events accepted while Close runs are still delivered
+	var (
+		accepted int32
+		wg       sync.WaitGroup
+	)
+	usageData := UsageData{Model: GPT4O, PromptTokens: 100}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if err := reporter.Report("agent", "customer", "indicator", usageData); err == nil {
+					atomic.AddInt32(&accepted, 1)
+				}
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	if err := reporter.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	wg.Wait()
+
+	if got, want := atomic.LoadInt32(&received), atomic.LoadInt32(&accepted); got != want {
+		t.Errorf("Expected all %d accepted events to be delivered, got %d", want, got)
+	}
+	if spool.Len() != 0 {
+		t.Errorf("Expected delivered events to be acknowledged, got %d pending", spool.Len())
+	}
+}
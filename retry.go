@@ -0,0 +1,167 @@
+package paygent
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed API calls are retried.
+// Only network errors, 429 Too Many Requests and 5xx responses are retried;
+// a Retry-After header on those responses is honoured, up to MaxRetryAfter,
+// when it asks for a longer delay than the computed backoff. Every attempt sends the same
+// request body, so retries are safe to combine with idempotency keys.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	// Values below 1 disable retries.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the computed delay between attempts
+	MaxBackoff time.Duration
+	// Multiplier grows the delay after every attempt (default 2)
+	Multiplier float64
+	// Jitter is the fraction of each delay that is randomized, between 0 and 1.
+	// A jitter of 0.2 waits between 80% and 100% of the computed delay.
+	Jitter float64
+	// MaxRetryAfter caps the delay a Retry-After header can ask for. When zero,
+	// MaxBackoff is used, or one minute if MaxBackoff is not set either.
+	MaxRetryAfter time.Duration
+}
+
+// defaultMaxRetryAfter caps Retry-After delays for policies without MaxRetryAfter or MaxBackoff
+const defaultMaxRetryAfter = time.Minute
+
+// DefaultRetryPolicy returns a retry policy suitable for most deployments:
+// 4 attempts with exponential backoff from 200ms up to 5s and 20% jitter,
+// waiting at most 30s when the API asks to retry later
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: 200 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		MaxRetryAfter:  30 * time.Second,
+	}
+}
+
+// SetRetryPolicy sets the retry policy used for every API call made by the client.
+// By default the client does not retry.
+func (c *Client) SetRetryPolicy(policy RetryPolicy) {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.Multiplier <= 0 {
+		policy.Multiplier = 2
+	}
+	if policy.Jitter < 0 {
+		policy.Jitter = 0
+	}
+	if policy.Jitter > 1 {
+		policy.Jitter = 1
+	}
+	c.retryPolicy = policy
+}
+
+// backoff returns the delay to wait after the given failed attempt (starting at 1)
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	limit := time.Duration(math.MaxInt64)
+	if p.MaxBackoff > 0 {
+		limit = p.MaxBackoff
+	}
+
+	// Clamp while still a float: beyond the int64 range the conversion to a
+	// Duration wraps around
+	delay := limit
+	if exact := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1)); exact < float64(limit) {
+		delay = time.Duration(exact)
+	}
+	if p.Jitter > 0 {
+		delay -= time.Duration(float64(delay) * p.Jitter * rand.Float64())
+	}
+	return delay
+}
+
+// retryAfter caps the delay requested by a Retry-After header
+func (p RetryPolicy) retryAfter(requested time.Duration) time.Duration {
+	limit := p.MaxRetryAfter
+	if limit <= 0 {
+		limit = p.MaxBackoff
+	}
+	if limit <= 0 {
+		limit = defaultMaxRetryAfter
+	}
+	return min(requested, limit)
+}
+
+// shouldRetry reports whether a failed attempt is worth retrying
+func (c *Client) shouldRetry(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	if errors.As(err, &permanentError{}) {
+		return false
+	}
+
+	// Anything else that reached us from doPostOnce is a network-level failure
+	return true
+}
+
+// isPermanentFailure reports whether an event failed in a way that resending
+// the same event can never fix, so it should not be kept for replay
+func isPermanentFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrEventRejected) {
+		return true
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+			// Fixable by configuration or by waiting
+			return false
+		}
+		return apiErr.StatusCode >= 400 && apiErr.StatusCode < 500
+	}
+	return false
+}
+
+// permanentError marks a failure that retrying cannot fix, such as a malformed URL
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+func (e permanentError) Unwrap() error { return e.err }
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := date.Sub(now); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
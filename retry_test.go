@@ -0,0 +1,208 @@
+package paygent
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		maxAttempts  int
+		wantAttempts int32
+		wantErr      bool
+	}{
+		{
+			name:         "Recovers after transient failures",
+			statuses:     []int{http.StatusBadGateway, http.StatusTooManyRequests, http.StatusOK},
+			maxAttempts:  4,
+			wantAttempts: 3,
+		},
+		{
+			name:         "Gives up after max attempts",
+			statuses:     []int{http.StatusServiceUnavailable},
+			maxAttempts:  3,
+			wantAttempts: 3,
+			wantErr:      true,
+		},
+		{
+			name:         "Does not retry client errors",
+			statuses:     []int{http.StatusBadRequest},
+			maxAttempts:  3,
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+		{
+			name:         "Retries disabled by default",
+			statuses:     []int{http.StatusBadGateway},
+			maxAttempts:  0,
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(atomic.AddInt32(&attempts, 1))
+				if n > len(tt.statuses) {
+					n = len(tt.statuses)
+				}
+				w.WriteHeader(tt.statuses[n-1])
+			}))
+			defer server.Close()
+
+			client := NewClientWithURL("test-api-key", server.URL)
+			if tt.maxAttempts > 0 {
+				client.SetRetryPolicy(RetryPolicy{
+					MaxAttempts:    tt.maxAttempts,
+					InitialBackoff: time.Millisecond,
+					MaxBackoff:     5 * time.Millisecond,
+					Jitter:         0.5,
+				})
+			}
+
+			err := client.SendUsage("agent", "customer", "indicator", UsageData{Model: GPT4O, PromptTokens: 10})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SendUsage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(&attempts); got != tt.wantAttempts {
+				t.Errorf("Expected %d attempts, got %d", tt.wantAttempts, got)
+			}
+			var apiErr *APIError
+			if tt.wantErr && !errors.As(err, &apiErr) {
+				t.Errorf("Expected *APIError, got %T", err)
+			}
+		})
+	}
+}
+
+func TestRetryHonoursRetryAfterAndContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client := NewClientWithURL("test-api-key", server.URL)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := client.SendUsageContext(ctx, "agent", "customer", "indicator", UsageData{Model: GPT4O, PromptTokens: 10})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded while waiting for Retry-After, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected retry wait to be cut short by the context, took %s", elapsed)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		value    string
+		expected time.Duration
+	}{
+		{name: "Empty", value: "", expected: 0},
+		{name: "Seconds", value: "3", expected: 3 * time.Second},
+		{name: "Negative", value: "-1", expected: 0},
+		{name: "HTTP date", value: now.Add(90 * time.Second).Format(http.TimeFormat), expected: 90 * time.Second},
+		{name: "Past date", value: now.Add(-time.Minute).Format(http.TimeFormat), expected: 0},
+		{name: "Garbage", value: "soon", expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value, now); got != tt.expected {
+				t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     300 * time.Millisecond,
+		Multiplier:     2,
+	}
+
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+	for i, want := range expected {
+		if got := policy.backoff(i + 1); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, want)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := policy.backoff(1); got < 50*time.Millisecond || got > 100*time.Millisecond {
+			t.Fatalf("backoff(1) with jitter = %v, want between 50ms and 100ms", got)
+		}
+	}
+}
+
+func TestRetryBackoffOverflow(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Multiplier: 10}
+	for _, attempt := range []int{20, 100, 2000} {
+		if got := policy.backoff(attempt); got != 5*time.Second {
+			t.Errorf("backoff(%d) = %v, want the 5s MaxBackoff", attempt, got)
+		}
+	}
+
+	policy.MaxBackoff = 0
+	if got := policy.backoff(2000); got != time.Duration(math.MaxInt64) {
+		t.Errorf("backoff(2000) without MaxBackoff = %v, want the largest Duration", got)
+	}
+}
+
+func TestRetryAfterIsCapped(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClientWithURL("test-api-key", server.URL)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxRetryAfter: 10 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.SendUsageContext(ctx, "agent", "customer", "indicator", UsageData{Model: GPT4O, PromptTokens: 10}); err != nil {
+		t.Fatalf("Expected the retry to wait MaxRetryAfter rather than an hour, got %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		expected time.Duration
+	}{
+		{name: "MaxRetryAfter", policy: RetryPolicy{MaxRetryAfter: 2 * time.Second, MaxBackoff: time.Second}, expected: 2 * time.Second},
+		{name: "MaxBackoff", policy: RetryPolicy{MaxBackoff: time.Second}, expected: time.Second},
+		{name: "Default", policy: RetryPolicy{}, expected: defaultMaxRetryAfter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.retryAfter(time.Hour); got != tt.expected {
+				t.Errorf("retryAfter(1h) = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package paygent
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// SentencePiece piece types
+const (
+	spPieceNormal      = 1
+	spPieceUnknown     = 2
+	spPieceControl     = 3
+	spPieceUserDefined = 4
+	spPieceUnused      = 5
+	spPieceByte        = 6
+)
+
+// SentencePiece model types
+const (
+	spModelUnigram = 1
+	spModelBPE     = 2
+)
+
+// spWhitespace is the character SentencePiece replaces spaces with
+const spWhitespace = "▁"
+
+// sentencePieceTokenizer counts tokens with a SentencePiece model, as used
+// by Llama 2, Mistral, Gemma and T5 models
+type sentencePieceTokenizer struct {
+	modelType    int
+	byteFallback bool
+	// scores holds the score of every piece that can be produced from text
+	scores map[string]float32
+	// userDefined pieces are always a single token
+	userDefined []string
+	maxPieceLen int
+	unkScore    float64
+
+	addDummyPrefix   bool
+	removeWhitespace bool
+	escapeWhitespace bool
+}
+
+// LoadSentencePieceFile loads a SentencePiece tokenizer.model for offline
+// token counting. BPE and Unigram models are supported.
+func LoadSentencePieceFile(path string) (Tokenizer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SentencePiece model: %w", err)
+	}
+	return ParseSentencePieceModel(data)
+}
+
+// ParseSentencePieceModel reads a serialized SentencePiece ModelProto
+func ParseSentencePieceModel(data []byte) (Tokenizer, error) {
+	t := &sentencePieceTokenizer{
+		modelType:        spModelUnigram,
+		scores:           make(map[string]float32),
+		addDummyPrefix:   true,
+		removeWhitespace: true,
+		escapeWhitespace: true,
+	}
+
+	minScore := math.Inf(1)
+	err := walkProto(data, func(field int, value uint64, bytes []byte) error {
+		switch field {
+		case 1:
+			piece, score, pieceType, err := parseSentencePiece(bytes)
+			if err != nil {
+				return err
+			}
+			switch pieceType {
+			case spPieceNormal:
+				t.scores[piece] = score
+				minScore = math.Min(minScore, float64(score))
+			case spPieceUserDefined:
+				t.userDefined = append(t.userDefined, piece)
+			}
+			t.maxPieceLen = max(t.maxPieceLen, utf8.RuneCountInString(piece))
+		case 2:
+			return walkProto(bytes, func(field int, value uint64, _ []byte) error {
+				switch field {
+				case 3:
+					t.modelType = int(value)
+				case 35:
+					t.byteFallback = value != 0
+				}
+				return nil
+			})
+		case 3:
+			return walkProto(bytes, func(field int, value uint64, _ []byte) error {
+				switch field {
+				case 3:
+					t.addDummyPrefix = value != 0
+				case 4:
+					t.removeWhitespace = value != 0
+				case 5:
+					t.escapeWhitespace = value != 0
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode SentencePiece model: %w", err)
+	}
+	if len(t.scores) == 0 {
+		return nil, errors.New("SentencePiece model has no pieces")
+	}
+	if t.modelType != spModelUnigram && t.modelType != spModelBPE {
+		return nil, fmt.Errorf("unsupported SentencePiece model type %d", t.modelType)
+	}
+	// Unknown characters cost more than any known piece, as in SentencePiece
+	t.unkScore = minScore - 10
+	return t, nil
+}
+
+// parseSentencePiece reads a ModelProto.SentencePiece message
+func parseSentencePiece(data []byte) (piece string, score float32, pieceType int, err error) {
+	pieceType = spPieceNormal
+	err = walkProto(data, func(field int, value uint64, bytes []byte) error {
+		switch field {
+		case 1:
+			piece = string(bytes)
+		case 2:
+			score = math.Float32frombits(uint32(value))
+		case 3:
+			pieceType = int(value)
+		}
+		return nil
+	})
+	return piece, score, pieceType, err
+}
+
+// walkProto calls fn for every field of a protobuf message. Varint and
+// fixed-width values are passed as value, length-delimited ones as bytes.
+func walkProto(data []byte, fn func(field int, value uint64, bytes []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errors.New("invalid field key")
+		}
+		data = data[n:]
+		field := int(key >> 3)
+
+		var value uint64
+		var bytes []byte
+		switch key & 7 {
+		case 0:
+			value, n = binary.Uvarint(data)
+			if n <= 0 {
+				return fmt.Errorf("invalid varint in field %d", field)
+			}
+			data = data[n:]
+		case 1:
+			if len(data) < 8 {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			value, data = binary.LittleEndian.Uint64(data), data[8:]
+		case 2:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			bytes, data = data[n:n+int(length)], data[n+int(length):]
+		case 5:
+			if len(data) < 4 {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			value, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d in field %d", key&7, field)
+		}
+		if err := fn(field, value, bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Count returns the number of tokens in text
+func (t *sentencePieceTokenizer) Count(text string) int {
+	count := 0
+	for _, segment := range splitAddedTokens(text, t.userDefined) {
+		if segment.isMatch {
+			count++
+			continue
+		}
+		for _, word := range splitBefore(t.normalize(segment.text), spWhitespace) {
+			count += t.countWord(word)
+		}
+	}
+	return count
+}
+
+// normalize applies the model's whitespace normalization
+func (t *sentencePieceTokenizer) normalize(text string) string {
+	if t.removeWhitespace {
+		text = strings.Join(strings.Fields(text), " ")
+	}
+	if text == "" {
+		return ""
+	}
+	if t.addDummyPrefix {
+		text = " " + text
+	}
+	if t.escapeWhitespace {
+		text = strings.ReplaceAll(text, " ", spWhitespace)
+	}
+	return text
+}
+
+// countWord returns the number of tokens of a word
+func (t *sentencePieceTokenizer) countWord(word string) int {
+	if word == "" {
+		return 0
+	}
+	var pieces []string
+	if t.modelType == spModelBPE {
+		pieces = bpeMerge(runeStrings(word), func(left, right string) (float64, bool) {
+			score, ok := t.scores[left+right]
+			// Higher scoring pieces merge first
+			return -float64(score), ok
+		})
+	} else {
+		pieces = t.viterbi(word)
+	}
+
+	count := 0
+	for _, piece := range pieces {
+		if _, ok := t.scores[piece]; !ok && t.byteFallback {
+			// Unknown characters are encoded as one <0xNN> piece per byte
+			count += len(piece)
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+// viterbi segments a word into the pieces with the highest total score, as
+// the Unigram model does. Characters without a piece are unknown.
+func (t *sentencePieceTokenizer) viterbi(word string) []string {
+	runes := []rune(word)
+	best := make([]float64, len(runes)+1)
+	from := make([]int, len(runes)+1)
+	for i := 1; i <= len(runes); i++ {
+		best[i] = math.Inf(-1)
+	}
+
+	for end := 1; end <= len(runes); end++ {
+		for start := max(0, end-t.maxPieceLen); start < end; start++ {
+			if math.IsInf(best[start], -1) {
+				continue
+			}
+			score, ok := t.scores[string(runes[start:end])]
+			if !ok {
+				if end-start > 1 {
+					continue
+				}
+				score = float32(t.unkScore)
+			}
+			if total := best[start] + float64(score); total > best[end] {
+				best[end], from[end] = total, start
+			}
+		}
+	}
+
+	var pieces []string
+	for end := len(runes); end > 0; end = from[end] {
+		pieces = append(pieces, string(runes[from[end]:end]))
+	}
+	for i, j := 0, len(pieces)-1; i < j; i, j = i+1, j-1 {
+		pieces[i], pieces[j] = pieces[j], pieces[i]
+	}
+	return pieces
+}
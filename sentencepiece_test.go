@@ -0,0 +1,141 @@
+package paygent
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// protoField appends a length-delimited protobuf field
+func protoField(data []byte, field int, value []byte) []byte {
+	data = binary.AppendUvarint(data, uint64(field<<3|2))
+	data = binary.AppendUvarint(data, uint64(len(value)))
+	return append(data, value...)
+}
+
+// protoVarint appends a varint protobuf field
+func protoVarint(data []byte, field int, value uint64) []byte {
+	data = binary.AppendUvarint(data, uint64(field<<3))
+	return binary.AppendUvarint(data, value)
+}
+
+// testPiece is a ModelProto.SentencePiece
+type testPiece struct {
+	piece     string
+	score     float32
+	pieceType int
+}
+
+// sentencePieceModel serializes a ModelProto with the given pieces and trainer spec
+func sentencePieceModel(pieces []testPiece, trainerSpec []byte) []byte {
+	var model []byte
+	for _, p := range pieces {
+		var piece []byte
+		piece = protoField(piece, 1, []byte(p.piece))
+		piece = binary.AppendUvarint(piece, 2<<3|5)
+		piece = binary.LittleEndian.AppendUint32(piece, math.Float32bits(p.score))
+		if p.pieceType != 0 {
+			piece = protoVarint(piece, 3, uint64(p.pieceType))
+		}
+		model = protoField(model, 1, piece)
+	}
+	if trainerSpec != nil {
+		model = protoField(model, 2, trainerSpec)
+	}
+	return model
+}
+
+func TestSentencePieceUnigram(t *testing.T) {
+	data := sentencePieceModel([]testPiece{
+		{piece: "<unk>", pieceType: spPieceUnknown},
+		{piece: "<s>", pieceType: spPieceControl},
+		{piece: "<tool>", pieceType: spPieceUserDefined},
+		{piece: "▁", score: -2},
+		{piece: "▁hello", score: -1},
+		{piece: "▁world", score: -1.5},
+		{piece: "▁he", score: -2},
+		{piece: "llo", score: -2},
+		{piece: "h", score: -3},
+		{piece: "e", score: -3},
+		{piece: "l", score: -3},
+		{piece: "o", score: -3},
+	}, nil)
+
+	tokenizer, err := ParseSentencePieceModel(data)
+	if err != nil {
+		t.Fatalf("Failed to parse model: %v", err)
+	}
+
+	tests := []struct {
+		text     string
+		expected int
+	}{
+		{text: "hello world", expected: 2},
+		{text: "  hello   world ", expected: 2},
+		{text: "hellx", expected: 4},
+		{text: "hello<tool>", expected: 2},
+		{text: "", expected: 0},
+	}
+
+	for _, tt := range tests {
+		if got := tokenizer.Count(tt.text); got != tt.expected {
+			t.Errorf("Count(%q) = %d, want %d", tt.text, got, tt.expected)
+		}
+	}
+}
+
+func TestSentencePieceBPE(t *testing.T) {
+	var trainerSpec []byte
+	trainerSpec = protoVarint(trainerSpec, 3, spModelBPE)
+	trainerSpec = protoVarint(trainerSpec, 35, 1)
+	data := sentencePieceModel([]testPiece{
+		{piece: "<unk>", pieceType: spPieceUnknown},
+		{piece: "<0xC3>", pieceType: spPieceByte},
+		{piece: "▁h", score: -1},
+		{piece: "hi", score: -2},
+		{piece: "▁hi", score: -3},
+		{piece: "▁", score: -4},
+		{piece: "h", score: -5},
+		{piece: "i", score: -6},
+	}, trainerSpec)
+
+	path := filepath.Join(t.TempDir(), "tokenizer.model")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("Failed to write model: %v", err)
+	}
+	tokenizer, err := LoadSentencePieceFile(path)
+	if err != nil {
+		t.Fatalf("Failed to load model: %v", err)
+	}
+
+	if got := tokenizer.Count("hi"); got != 1 {
+		t.Errorf("Expected 1 token, got %d", got)
+	}
+	// é is not a piece, so it falls back to its two bytes
+	if got := tokenizer.Count("hié"); got != 3 {
+		t.Errorf("Expected 3 tokens, got %d", got)
+	}
+}
+
+func TestParseSentencePieceModelErrors(t *testing.T) {
+	unsupported := sentencePieceModel([]testPiece{{piece: "a", score: -1}}, protoVarint(nil, 3, 3))
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "Truncated", data: []byte{0x0a, 0x05, 'a'}},
+		{name: "No pieces", data: nil},
+		{name: "Unsupported model type", data: unsupported},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseSentencePieceModel(tt.data); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
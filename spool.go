@@ -0,0 +1,584 @@
+package paygent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrSpoolFull is returned when appending to a spool that has reached its size limit
+var ErrSpoolFull = errors.New("paygent: spool is full")
+
+// ErrSpoolClosed is returned when using a spool after Close
+var ErrSpoolClosed = errors.New("paygent: spool is closed")
+
+// SpoolOverflowPolicy decides what happens when a spool reaches MaxTotalBytes
+type SpoolOverflowPolicy int
+
+const (
+	// SpoolRejectNew refuses new events with ErrSpoolFull
+	SpoolRejectNew SpoolOverflowPolicy = iota
+	// SpoolDropOldest discards the oldest pending events to make room
+	SpoolDropOldest
+)
+
+const (
+	spoolSegmentPrefix = "segment-"
+	spoolSegmentSuffix = ".log"
+
+	// defaultSpoolSegmentBytes is the default size at which a new segment is started
+	defaultSpoolSegmentBytes = 4 << 20
+	// defaultSpoolTotalBytes is the default disk budget of a spool
+	defaultSpoolTotalBytes = 256 << 20
+)
+
+// SpoolConfig configures an on-disk Spool.
+// Zero values are replaced with the defaults noted on each field.
+type SpoolConfig struct {
+	// Dir is the directory holding the segment files. It is created if missing.
+	Dir string
+	// MaxSegmentBytes is the size at which a new segment file is started (default 4 MiB)
+	MaxSegmentBytes int64
+	// MaxTotalBytes caps the disk usage of all segments (default 256 MiB)
+	MaxTotalBytes int64
+	// OverflowPolicy decides what happens when MaxTotalBytes is reached (default SpoolRejectNew)
+	OverflowPolicy SpoolOverflowPolicy
+	// Fsync syncs every write to stable storage. Without it events survive
+	// process crashes but not necessarily operating system crashes.
+	Fsync bool
+	// Logger receives spool warnings (default: a new logrus logger)
+	Logger *logrus.Logger
+}
+
+// spoolEntry is one line of a segment file
+type spoolEntry struct {
+	Op    string      `json:"op"`
+	ID    string      `json:"id,omitempty"`
+	Event *APIRequest `json:"event,omitempty"`
+}
+
+// spoolRecord is a pending event and the segment holding it
+type spoolRecord struct {
+	seq     uint64
+	segment uint64
+	size    int64 // size of the event's line in its segment
+	event   APIRequest
+}
+
+// spoolSegment tracks the size and number of pending events of a segment file
+type spoolSegment struct {
+	size int64
+	live int
+}
+
+// Spool is an append-only write-ahead log of usage events. Events are
+// appended before they are sent and acknowledged once delivered, so events
+// that were in flight when the process stopped can be replayed on the next
+// start. Segments whose events have all been acknowledged are deleted, and
+// Compact rewrites the remaining events into a single fresh segment.
+// A Spool is safe for concurrent use.
+type Spool struct {
+	config SpoolConfig
+
+	mu        sync.Mutex
+	closed    bool
+	pending   map[string]*spoolRecord
+	segments  map[uint64]*spoolSegment
+	active    uint64
+	file      *os.File
+	writer    *bufio.Writer
+	nextSeq   uint64
+	totalSize int64
+}
+
+// OpenSpool opens the spool in config.Dir, loading the events that were never
+// acknowledged and compacting the existing segments
+func OpenSpool(config SpoolConfig) (*Spool, error) {
+	if config.Dir == "" {
+		return nil, errors.New("spool directory is required")
+	}
+	if config.MaxSegmentBytes <= 0 {
+		config.MaxSegmentBytes = defaultSpoolSegmentBytes
+	}
+	if config.MaxTotalBytes <= 0 {
+		config.MaxTotalBytes = defaultSpoolTotalBytes
+	}
+	if config.Logger == nil {
+		config.Logger = logrus.New()
+	}
+
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	s := &Spool{
+		config:   config,
+		pending:  make(map[string]*spoolRecord),
+		segments: make(map[uint64]*spoolSegment),
+	}
+
+	ids, err := s.segmentIDs()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if err := s.load(id); err != nil {
+			return nil, err
+		}
+		s.active = id
+	}
+
+	if err := s.compactLocked(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Append persists an event. Appending an event whose ID is already pending is a no-op.
+func (s *Spool) Append(event APIRequest) error {
+	if event.EventID == "" {
+		return errors.New("spooled events require an event ID")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrSpoolClosed
+	}
+	if _, exists := s.pending[event.EventID]; exists {
+		return nil
+	}
+
+	line, err := json.Marshal(spoolEntry{Op: "add", Event: &event})
+	if err != nil {
+		return fmt.Errorf("failed to marshal spool entry: %w", err)
+	}
+
+	if err := s.reserve(int64(len(line)) + 1); err != nil {
+		return err
+	}
+	if err := s.write(line); err != nil {
+		return err
+	}
+
+	s.pending[event.EventID] = &spoolRecord{seq: s.nextSeq, segment: s.active, size: int64(len(line)) + 1, event: event}
+	s.nextSeq++
+	s.segments[s.active].live++
+	return nil
+}
+
+// Ack marks events as delivered so they are not replayed. Unknown IDs are ignored.
+func (s *Spool) Ack(eventIDs ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrSpoolClosed
+	}
+
+	for _, id := range eventIDs {
+		record, exists := s.pending[id]
+		if !exists {
+			continue
+		}
+
+		line, err := json.Marshal(spoolEntry{Op: "ack", ID: id})
+		if err != nil {
+			return fmt.Errorf("failed to marshal spool entry: %w", err)
+		}
+		// Acks only ever free space, so they are not subject to the size limit
+		if err := s.rotateIfNeeded(); err != nil {
+			return err
+		}
+		if err := s.write(line); err != nil {
+			return err
+		}
+
+		delete(s.pending, id)
+		s.segments[record.segment].live--
+	}
+
+	return s.reclaim()
+}
+
+// Pending returns the events that have not been acknowledged, oldest first
+func (s *Spool) Pending() []APIRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pendingLocked()
+}
+
+// Len returns the number of events that have not been acknowledged
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.pending)
+}
+
+// Size returns the number of bytes used by the spool's segment files
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.totalSize
+}
+
+// Compact rewrites the pending events into a fresh segment and deletes every older segment
+func (s *Spool) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrSpoolClosed
+	}
+	return s.compactLocked()
+}
+
+// Close flushes and closes the active segment
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.closeActive()
+}
+
+// pendingLocked returns the pending events in append order
+func (s *Spool) pendingLocked() []APIRequest {
+	records := make([]*spoolRecord, 0, len(s.pending))
+	for _, record := range s.pending {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].seq < records[j].seq })
+
+	events := make([]APIRequest, len(records))
+	for i, record := range records {
+		events[i] = record.event
+	}
+	return events
+}
+
+// reserve makes room for n more bytes, applying the overflow policy
+func (s *Spool) reserve(n int64) error {
+	if err := s.rotateIfNeeded(); err != nil {
+		return err
+	}
+	if s.totalSize+n <= s.config.MaxTotalBytes {
+		return nil
+	}
+
+	// Try to get back under budget without losing events first
+	if err := s.compactLocked(); err != nil {
+		return err
+	}
+	if s.totalSize+n <= s.config.MaxTotalBytes {
+		return nil
+	}
+
+	if s.config.OverflowPolicy != SpoolDropOldest || n > s.config.MaxTotalBytes {
+		return ErrSpoolFull
+	}
+
+	// After compaction the spool holds exactly one line per pending event,
+	// so dropping the oldest events frees their line sizes
+	excess := s.totalSize + n - s.config.MaxTotalBytes
+	dropped := 0
+	for _, event := range s.pendingLocked() {
+		if excess <= 0 {
+			break
+		}
+		excess -= s.pending[event.EventID].size
+		delete(s.pending, event.EventID)
+		dropped++
+	}
+	s.config.Logger.Warnf("Spool over its %d byte limit, dropped %d oldest pending events",
+		s.config.MaxTotalBytes, dropped)
+
+	return s.compactLocked()
+}
+
+// write appends one line to the active segment
+func (s *Spool) write(line []byte) error {
+	if _, err := s.writer.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write spool entry: %w", err)
+	}
+	if err := s.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write spool entry: %w", err)
+	}
+	if s.config.Fsync {
+		if err := s.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync spool segment: %w", err)
+		}
+	}
+
+	size := int64(len(line)) + 1
+	s.segments[s.active].size += size
+	s.totalSize += size
+	return nil
+}
+
+// rotateIfNeeded starts a new segment when the active one is full
+func (s *Spool) rotateIfNeeded() error {
+	if s.file != nil && s.segments[s.active].size < s.config.MaxSegmentBytes {
+		return nil
+	}
+	return s.rotate()
+}
+
+// rotate seals the active segment and opens the next one
+func (s *Spool) rotate() error {
+	if err := s.closeActive(); err != nil {
+		return err
+	}
+
+	next := s.active + 1
+	file, err := os.OpenFile(s.segmentPath(next), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create spool segment: %w", err)
+	}
+
+	s.active = next
+	s.file = file
+	s.writer = bufio.NewWriter(file)
+	s.segments[next] = &spoolSegment{}
+	return s.reclaim()
+}
+
+// closeActive closes the active segment file, if any
+func (s *Spool) closeActive() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.writer.Flush()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	s.file, s.writer = nil, nil
+	if err != nil {
+		return fmt.Errorf("failed to close spool segment: %w", err)
+	}
+	return nil
+}
+
+// reclaim deletes fully acknowledged sealed segments, oldest first. Segments are
+// only deleted in order because acks for a segment's events live in later segments.
+func (s *Spool) reclaim() error {
+	for {
+		oldest, ok := s.oldestSealed()
+		if !ok || s.segments[oldest].live > 0 {
+			return nil
+		}
+		if err := s.removeSegment(oldest); err != nil {
+			return err
+		}
+	}
+}
+
+// oldestSealed returns the oldest segment that is not the active one
+func (s *Spool) oldestSealed() (uint64, bool) {
+	var oldest uint64
+	found := false
+	for id := range s.segments {
+		if id == s.active && s.file != nil {
+			continue
+		}
+		if !found || id < oldest {
+			oldest, found = id, true
+		}
+	}
+	return oldest, found
+}
+
+// removeSegment deletes a segment file and forgets about it
+func (s *Spool) removeSegment(id uint64) error {
+	if err := os.Remove(s.segmentPath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove spool segment: %w", err)
+	}
+	s.totalSize -= s.segments[id].size
+	delete(s.segments, id)
+	return nil
+}
+
+// compactLocked writes every pending event into a new segment and removes all older segments
+func (s *Spool) compactLocked() error {
+	events := s.pendingLocked()
+
+	old := make([]uint64, 0, len(s.segments))
+	for id := range s.segments {
+		old = append(old, id)
+	}
+
+	if err := s.rotate(); err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		event := event
+		line, err := json.Marshal(spoolEntry{Op: "add", Event: &event})
+		if err != nil {
+			return fmt.Errorf("failed to marshal spool entry: %w", err)
+		}
+		if err := s.write(line); err != nil {
+			return err
+		}
+		record := s.pending[event.EventID]
+		record.segment = s.active
+		record.size = int64(len(line)) + 1
+		s.segments[s.active].live++
+	}
+	if s.config.Fsync {
+		if err := s.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync spool segment: %w", err)
+		}
+	}
+
+	for _, id := range old {
+		if _, exists := s.segments[id]; exists {
+			if err := s.removeSegment(id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// load replays a segment file into the in-memory index
+func (s *Spool) load(id uint64) error {
+	file, err := os.Open(s.segmentPath(id))
+	if err != nil {
+		return fmt.Errorf("failed to open spool segment: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat spool segment: %w", err)
+	}
+	s.segments[id] = &spoolSegment{size: info.Size()}
+	s.totalSize += info.Size()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var entry spoolEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// Most likely a partial write from a crash; skip it
+			s.config.Logger.Warnf("Skipping corrupt entry in spool segment %d: %v", id, err)
+			continue
+		}
+
+		switch entry.Op {
+		case "add":
+			if entry.Event == nil || entry.Event.EventID == "" {
+				continue
+			}
+			if _, exists := s.pending[entry.Event.EventID]; exists {
+				continue
+			}
+			s.pending[entry.Event.EventID] = &spoolRecord{seq: s.nextSeq, segment: id, size: int64(len(scanner.Bytes())) + 1, event: *entry.Event}
+			s.nextSeq++
+			s.segments[id].live++
+		case "ack":
+			if record, exists := s.pending[entry.ID]; exists {
+				delete(s.pending, entry.ID)
+				s.segments[record.segment].live--
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read spool segment: %w", err)
+	}
+	return nil
+}
+
+// segmentIDs lists the segment files in the spool directory in ascending order
+func (s *Spool) segmentIDs() ([]uint64, error) {
+	entries, err := os.ReadDir(s.config.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	var ids []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, spoolSegmentPrefix) || !strings.HasSuffix(name, spoolSegmentSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, spoolSegmentPrefix), spoolSegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// segmentPath returns the file path of a segment
+func (s *Spool) segmentPath(id uint64) string {
+	return filepath.Join(s.config.Dir, fmt.Sprintf("%s%020d%s", spoolSegmentPrefix, id, spoolSegmentSuffix))
+}
+
+// SetSpool makes the client persist every event to spool before sending it and
+// acknowledge it once the API has accepted or permanently rejected it. Events
+// left in the spool by a previous run are sent with ReplaySpool.
+func (c *Client) SetSpool(spool *Spool) {
+	c.spool = spool
+}
+
+// ReplaySpool sends every event still pending in the client's spool, typically
+// on startup. Delivered events are acknowledged; the rest stay in the spool.
+func (c *Client) ReplaySpool(ctx context.Context) (*BatchResult, error) {
+	if c.spool == nil {
+		return &BatchResult{}, nil
+	}
+
+	records := c.spool.Pending()
+	if len(records) == 0 {
+		return &BatchResult{}, nil
+	}
+
+	c.logger.Infof("Replaying %d spooled usage events", len(records))
+	return c.SendUsageBatch(ctx, records)
+}
+
+// spoolAppend persists an event if the client has a spool and reports whether
+// it is in the spool. Spool failures are logged rather than returned so that
+// the event is still sent.
+func (c *Client) spoolAppend(apiRequest APIRequest) bool {
+	if c.spool == nil {
+		return false
+	}
+	if err := c.spool.Append(apiRequest); err != nil {
+		c.logger.Errorf("Failed to spool usage event %s: %v", apiRequest.EventID, err)
+		return false
+	}
+	return true
+}
+
+// spoolAck acknowledges delivered events if the client has a spool
+func (c *Client) spoolAck(eventIDs ...string) {
+	if c.spool == nil || len(eventIDs) == 0 {
+		return
+	}
+	if err := c.spool.Ack(eventIDs...); err != nil {
+		c.logger.Errorf("Failed to acknowledge spooled usage events: %v", err)
+	}
+}
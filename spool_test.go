@@ -0,0 +1,287 @@
+package paygent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testEvent(i int) APIRequest {
+	return APIRequest{
+		EventID:    fmt.Sprintf("evt-%04d", i),
+		AgentID:    "agent",
+		CustomerID: "customer",
+		Indicator:  "indicator",
+		Amount:     MoneyFromFloat(0.001),
+		Model:      GPT4O,
+	}
+}
+
+func TestSpoolReplayAfterReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	spool, err := OpenSpool(SpoolConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("OpenSpool() error = %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := spool.Append(testEvent(i)); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+	// Appending a pending event again is a no-op
+	if err := spool.Append(testEvent(0)); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if err := spool.Ack("evt-0001", "evt-0003", "unknown"); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+	if err := spool.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// Simulate a crash in the middle of a write
+	ids, _ := (&Spool{config: SpoolConfig{Dir: dir}}).segmentIDs()
+	last := filepath.Join(dir, fmt.Sprintf("segment-%020d.log", ids[len(ids)-1]))
+	file, _ := os.OpenFile(last, os.O_APPEND|os.O_WRONLY, 0o644)
+	file.WriteString(`{"op":"add","event":{"eventId":"evt-9`)
+	file.Close()
+
+	spool, err = OpenSpool(SpoolConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("OpenSpool() after crash error = %v", err)
+	}
+	defer spool.Close()
+
+	pending := spool.Pending()
+	want := []string{"evt-0000", "evt-0002", "evt-0004"}
+	if len(pending) != len(want) {
+		t.Fatalf("Expected %d pending events, got %d", len(want), len(pending))
+	}
+	for i, event := range pending {
+		if event.EventID != want[i] {
+			t.Errorf("Expected pending event %d to be %s, got %s", i, want[i], event.EventID)
+		}
+	}
+
+	// Opening compacts everything into a single segment
+	if ids, _ := spool.segmentIDs(); len(ids) != 1 {
+		t.Errorf("Expected 1 segment after reopen, got %d", len(ids))
+	}
+}
+
+func TestSpoolReclaimsAcknowledgedSegments(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := OpenSpool(SpoolConfig{Dir: dir, MaxSegmentBytes: 256})
+	if err != nil {
+		t.Fatalf("OpenSpool() error = %v", err)
+	}
+	defer spool.Close()
+
+	for i := 0; i < 20; i++ {
+		event := testEvent(i)
+		if err := spool.Append(event); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+		if err := spool.Ack(event.EventID); err != nil {
+			t.Fatalf("Ack() error = %v", err)
+		}
+	}
+
+	ids, _ := spool.segmentIDs()
+	if len(ids) != 1 {
+		t.Errorf("Expected fully acknowledged segments to be deleted, %d segments remain", len(ids))
+	}
+	if spool.Len() != 0 {
+		t.Errorf("Expected no pending events, got %d", spool.Len())
+	}
+}
+
+func TestSpoolOverflowPolicy(t *testing.T) {
+	t.Run("Reject new", func(t *testing.T) {
+		spool, err := OpenSpool(SpoolConfig{Dir: t.TempDir(), MaxTotalBytes: 1024})
+		if err != nil {
+			t.Fatalf("OpenSpool() error = %v", err)
+		}
+		defer spool.Close()
+
+		var appendErr error
+		for i := 0; i < 100 && appendErr == nil; i++ {
+			appendErr = spool.Append(testEvent(i))
+		}
+		if !errors.Is(appendErr, ErrSpoolFull) {
+			t.Errorf("Expected ErrSpoolFull, got %v", appendErr)
+		}
+		if spool.Size() > 1024 {
+			t.Errorf("Expected spool to stay within 1024 bytes, got %d", spool.Size())
+		}
+	})
+
+	t.Run("Drop oldest", func(t *testing.T) {
+		spool, err := OpenSpool(SpoolConfig{Dir: t.TempDir(), MaxTotalBytes: 1024, OverflowPolicy: SpoolDropOldest})
+		if err != nil {
+			t.Fatalf("OpenSpool() error = %v", err)
+		}
+		defer spool.Close()
+
+		for i := 0; i < 100; i++ {
+			if err := spool.Append(testEvent(i)); err != nil {
+				t.Fatalf("Append() error = %v", err)
+			}
+		}
+		if spool.Size() > 1024 {
+			t.Errorf("Expected spool to stay within 1024 bytes, got %d", spool.Size())
+		}
+		pending := spool.Pending()
+		if len(pending) == 0 || pending[len(pending)-1].EventID != "evt-0099" {
+			t.Errorf("Expected the newest event to be kept, got %v", pending)
+		}
+		if pending[0].EventID == "evt-0000" {
+			t.Error("Expected the oldest events to be dropped")
+		}
+	})
+}
+
+func TestClientSpool(t *testing.T) {
+	healthy := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	spool, err := OpenSpool(SpoolConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("OpenSpool() error = %v", err)
+	}
+
+	client := NewClientWithURL("test-api-key", server.URL)
+	client.SetSpool(spool)
+
+	usageData := UsageData{Model: GPT4O, PromptTokens: 10}
+	if err := client.SendUsage("agent", "customer", "indicator", usageData); err == nil {
+		t.Fatal("Expected SendUsage() to fail while the API is down")
+	}
+	if spool.Len() != 1 {
+		t.Fatalf("Expected failed event to stay in the spool, got %d pending", spool.Len())
+	}
+	spool.Close()
+
+	// Restart with the API back up
+	healthy = true
+	spool, err = OpenSpool(SpoolConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("OpenSpool() error = %v", err)
+	}
+	defer spool.Close()
+	client = NewClientWithURL("test-api-key", server.URL)
+	client.SetSpool(spool)
+
+	result, err := client.ReplaySpool(context.Background())
+	if err != nil {
+		t.Fatalf("ReplaySpool() error = %v", err)
+	}
+	if result.Succeeded != 1 {
+		t.Errorf("Expected 1 replayed event, got %d", result.Succeeded)
+	}
+	if spool.Len() != 0 {
+		t.Errorf("Expected spool to be empty after replay, got %d pending", spool.Len())
+	}
+}
+
+func TestClientSpoolAppendFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// Too small to hold any event
+	spool, err := OpenSpool(SpoolConfig{Dir: t.TempDir(), MaxTotalBytes: 16})
+	if err != nil {
+		t.Fatalf("OpenSpool() error = %v", err)
+	}
+	defer spool.Close()
+
+	client := NewClientWithURL("test-api-key", server.URL)
+	client.SetSpool(spool)
+	client.RegisterModelPricing("flat-model", ModelPricing{PromptTokensCost: 1, CompletionTokensCost: 1})
+	if err := client.SetBillingRule(BillingRule{Name: "trial", FreeAllowance: MoneyFromFloat(1)}); err != nil {
+		t.Fatalf("SetBillingRule() error = %v", err)
+	}
+
+	usageData := UsageData{Model: "flat-model", PromptTokens: 1000}
+	if err := client.SendUsage("agent", "customer", "indicator", usageData); err == nil {
+		t.Fatal("Expected SendUsage() to fail while the API is down")
+	}
+	record, err := client.BuildUsageRequest("agent", "customer", "indicator", usageData)
+	if err != nil {
+		t.Fatalf("BuildUsageRequest() error = %v", err)
+	}
+	if _, err := client.SendUsageBatch(context.Background(), []APIRequest{record}); err == nil {
+		t.Fatal("Expected SendUsageBatch() to fail while the API is down")
+	}
+	if spool.Len() != 0 {
+		t.Fatalf("Expected the events not to fit in the spool, got %d pending", spool.Len())
+	}
+
+	// Neither event will be replayed, so both gave their allowance back
+	request, err := client.BuildUsageRequest("agent", "customer", "indicator", usageData)
+	if err != nil {
+		t.Fatalf("BuildUsageRequest() error = %v", err)
+	}
+	if request.FreeAllowance == nil || *request.FreeAllowance != MoneyFromFloat(1) {
+		t.Errorf("Expected the whole allowance to be left, got %v covered", request.FreeAllowance)
+	}
+}
+
+func TestSpoolReplayKeepsEventIdempotencyKey(t *testing.T) {
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/usage":
+			keys = append(keys, r.Header.Get("Idempotency-Key"))
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/api/v1/usage/batch":
+			var body struct {
+				Events []batchEvent `json:"events"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			for _, event := range body.Events {
+				keys = append(keys, event.IdempotencyKey)
+			}
+		}
+	}))
+	defer server.Close()
+
+	spool, err := OpenSpool(SpoolConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("OpenSpool() error = %v", err)
+	}
+	defer spool.Close()
+
+	client := NewClientWithURL("test-api-key", server.URL)
+	client.SetSpool(spool)
+
+	usageData := UsageData{EventID: "evt-replayed", Model: GPT4O, PromptTokens: 10}
+	if err := client.SendUsage("agent", "customer", "indicator", usageData); err == nil {
+		t.Fatal("Expected SendUsage() to fail while the API is down")
+	}
+	if _, err := client.ReplaySpool(context.Background()); err != nil {
+		t.Fatalf("ReplaySpool() error = %v", err)
+	}
+
+	if len(keys) != 2 || keys[0] != "evt-replayed" || keys[1] != keys[0] {
+		t.Errorf("Expected the replay to carry the key of the failed send, got %v", keys)
+	}
+}
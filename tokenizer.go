@@ -0,0 +1,242 @@
+package paygent
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Encodings used to count tokens
+const (
+	// EncodingO200kBase is the tiktoken encoding of GPT-4o, GPT-4.1, GPT-5 and o-series models
+	EncodingO200kBase = "o200k_base"
+	// EncodingCL100kBase is the tiktoken encoding of GPT-4 and GPT-3.5 models
+	EncodingCL100kBase = "cl100k_base"
+	// EncodingP50kBase is the tiktoken encoding of older completion models
+	EncodingP50kBase = "p50k_base"
+	// EncodingR50kBase is the tiktoken encoding of GPT-3 models
+	EncodingR50kBase = "r50k_base"
+	// EncodingWordEstimate estimates 1.3 tokens per word, for models without a known tokenizer
+	EncodingWordEstimate = "word_estimate"
+	// EncodingCustom counts tokens with a Tokenizer set on the client
+	EncodingCustom = "custom"
+)
+
+// Tokenizer counts the tokens of text. Implementations must be safe for
+// concurrent use.
+type Tokenizer interface {
+	Count(text string) int
+}
+
+// tiktokenTokenizer counts tokens with a tiktoken encoding
+type tiktokenTokenizer struct {
+	encoding string
+}
+
+// NewTiktokenTokenizer returns a Tokenizer for a tiktoken encoding, such as
+// EncodingO200kBase or EncodingCL100kBase
+func NewTiktokenTokenizer(encoding string) (Tokenizer, error) {
+	if _, err := getEncoding(encoding); err != nil {
+		return nil, fmt.Errorf("failed to load %s encoding: %w", encoding, err)
+	}
+	return tiktokenTokenizer{encoding: encoding}, nil
+}
+
+// Count returns the number of tokens in text
+func (t tiktokenTokenizer) Count(text string) int {
+	encoding, err := getEncoding(t.encoding)
+	if err != nil {
+		return 0
+	}
+	return len(encoding.Encode(text, nil, nil))
+}
+
+// TokenizerInfo describes how the client counts tokens for a model
+type TokenizerInfo struct {
+	// Model is the catalog model the tokenizer was chosen for
+	Model string
+	// Provider is the model's service provider, empty when unknown
+	Provider string
+	// Encoding is the encoding tokens are counted with
+	Encoding string
+	// Exact is true when Encoding is the model's own tokenizer, and false when
+	// it approximates a tokenizer the SDK does not ship
+	Exact bool
+
+	tokenizer Tokenizer
+}
+
+// tokenizerRegistry holds the tokenizers set on a client by model and provider
+type tokenizerRegistry struct {
+	mu        sync.RWMutex
+	models    map[string]Tokenizer
+	providers map[string]Tokenizer
+}
+
+func newTokenizerRegistry() *tokenizerRegistry {
+	return &tokenizerRegistry{models: make(map[string]Tokenizer), providers: make(map[string]Tokenizer)}
+}
+
+// set adds, replaces or, for a nil tokenizer, removes a tokenizer
+func (r *tokenizerRegistry) set(tokenizers map[string]Tokenizer, key string, tokenizer Tokenizer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if tokenizer == nil {
+		delete(tokenizers, key)
+		return
+	}
+	tokenizers[key] = tokenizer
+}
+
+// lookup returns the tokenizer of the first model with one, or of the provider
+func (r *tokenizerRegistry) lookup(models []string, provider string) (Tokenizer, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, model := range models {
+		if tokenizer, ok := r.models[model]; ok {
+			return tokenizer, true
+		}
+	}
+	tokenizer, ok := r.providers[provider]
+	return tokenizer, ok
+}
+
+// SetModelTokenizer counts the tokens of model with tokenizer, such as one
+// loaded with LoadHFTokenizerFile or LoadSentencePieceFile. The model is
+// matched as given and after resolving its aliases. A nil tokenizer restores
+// the default.
+func (c *Client) SetModelTokenizer(model string, tokenizer Tokenizer) {
+	c.tokenizers.set(c.tokenizers.models, model, tokenizer)
+}
+
+// SetProviderTokenizer counts the tokens of every model of provider without
+// a model tokenizer with tokenizer. A nil tokenizer restores the default.
+func (c *Client) SetProviderTokenizer(provider string, tokenizer Tokenizer) {
+	c.tokenizers.set(c.tokenizers.providers, provider, tokenizer)
+}
+
+// modelProviders is the model catalog: the service provider of every model constant
+var modelProviders = func() map[string]string {
+	catalog := map[string][]string{
+		OpenAI: {
+			GPT5, GPT5Mini, GPT5Nano, GPT5ChatLatest, GPT5Codex, GPT5Pro, GPT5SearchAPI,
+			GPT41, GPT41Mini, GPT41Nano, GPT4O, GPT4O20240513, GPT4OMini,
+			GPTRealtime, GPTRealtimeMini, GPT4ORealtimePreview, GPT4OMiniRealtimePreview,
+			GPTAudio, GPTAudioMini, GPT4OAudioPreview, GPT4OMiniAudioPreview,
+			O1, O1Pro, O3Pro, O3, O3DeepResearch, O4Mini, O4MiniDeepResearch, O3Mini, O1Mini,
+			CodexMiniLatest, GPT4OMiniSearchPreview, GPT4OSearchPreview, ComputerUsePreview, ChatGPT4OLatest,
+			GPT4Turbo20240409, GPT40125Preview, GPT41106Preview, GPT41106VisionPreview, GPT40613, GPT40314, GPT432K,
+			GPT35Turbo, GPT35Turbo0125, GPT35Turbo1106, GPT35Turbo0613, GPT350301, GPT35TurboInstruct, GPT35Turbo16K0613,
+			Davinci002, Babbage002,
+		},
+		Anthropic: {Sonnet45, Haiku45, Opus41, Sonnet4, Opus4, Sonnet37, Haiku35, Opus3, Haiku3},
+		GoogleDeepMind: {
+			Gemini25Pro, Gemini25Flash, Gemini25FlashPreview, Gemini25FlashLite, Gemini25FlashLitePreview,
+			Gemini25FlashNativeAudio, Gemini25FlashImage, Gemini25FlashPreviewTTS, Gemini25ProPreviewTTS, Gemini25ComputerUsePreview,
+		},
+		Meta: {
+			Llama4Maverick, Llama4Scout, Llama3370BInstructTurbo, Llama323BInstructTurbo, Llama31405BInstructTurbo,
+			Llama3170BInstructTurbo, Llama318BInstructTurbo, Llama370BInstructTurbo, Llama370BInstructReference,
+			Llama38BInstructLite, LLaMA2, LlamaGuard412B, LlamaGuard311BVisionTurbo, LlamaGuard38B, LlamaGuard28B,
+			SalesforceLlamaRankV18B,
+		},
+		AWS:       {AmazonNovaMicro, AmazonNovaLite, AmazonNovaPro},
+		MistralAI: {Mistral7BInstruct, MistralLarge, MistralSmall, MistralMedium},
+		Cohere:    {CommandR7B, CommandR, CommandRPlus, CommandA, AyaExpanse8B32B},
+		DeepSeek:  {DeepSeekChat, DeepSeekReasoner, DeepSeekR1Global, DeepSeekR1DataZone, DeepSeekV32Exp},
+	}
+
+	providers := make(map[string]string)
+	for provider, models := range catalog {
+		for _, model := range models {
+			providers[model] = provider
+		}
+	}
+	return providers
+}()
+
+// providerEncodings is the encoding tokens are counted with for each provider.
+// Only OpenAI publishes its tokenizers as tiktoken encodings; the others are
+// approximated with cl100k_base. OpenAI models outside openAIModelEncodings
+// are assumed to use the encoding of current models.
+var providerEncodings = map[string]string{
+	OpenAI:         EncodingO200kBase,
+	Anthropic:      EncodingCL100kBase,
+	GoogleDeepMind: EncodingCL100kBase,
+	Meta:           EncodingCL100kBase,
+	AWS:            EncodingCL100kBase,
+	MistralAI:      EncodingCL100kBase,
+	Cohere:         EncodingCL100kBase,
+	DeepSeek:       EncodingCL100kBase,
+	Custom:         EncodingWordEstimate,
+}
+
+// TokenizerForModel returns how the client counts tokens for model. The model
+// is looked up in the model catalog, directly or through the model aliases,
+// then by the provider of its registered price. Tokenizers set with
+// SetModelTokenizer and SetProviderTokenizer take precedence. Models that are
+// not found are counted with EncodingWordEstimate.
+func (c *Client) TokenizerForModel(model string) TokenizerInfo {
+	return c.tokenizerFor(model, "")
+}
+
+// tokenizerFor returns the tokenizer of model, falling back to the tokenizer
+// of serviceProvider when the model's provider is not known
+func (c *Client) tokenizerFor(model, serviceProvider string) TokenizerInfo {
+	catalogModel := model
+	provider, ok := modelProviders[model]
+	if !ok {
+		if canonical, found := c.canonicalModel(model, time.Now()); found {
+			catalogModel = canonical
+			provider, ok = modelProviders[canonical]
+		}
+	}
+	if !ok {
+		if pricing, _, priced := c.pricing.Lookup(catalogModel, time.Now()); priced && pricing.Provider != "" {
+			provider = pricing.Provider
+		} else {
+			provider = serviceProvider
+		}
+	}
+
+	info := TokenizerInfo{Model: catalogModel, Provider: provider, Encoding: EncodingWordEstimate}
+	if tokenizer, ok := c.tokenizers.lookup([]string{model, catalogModel}, provider); ok {
+		info.Encoding, info.Exact, info.tokenizer = EncodingCustom, true, tokenizer
+		return info
+	}
+	if encoding, known := providerEncodings[provider]; known {
+		info.Encoding = encoding
+	}
+	if encoding, known := openAIModelEncodings[catalogModel]; known {
+		info.Encoding, info.Exact = encoding, true
+	}
+	return info
+}
+
+// getTokenCount counts the tokens of text with the tokenizer of model
+func (c *Client) getTokenCount(model, text string) int {
+	return c.countTokens(c.TokenizerForModel(model), text)
+}
+
+// countTokens counts the tokens of text with a tokenizer
+func (c *Client) countTokens(tokenizer TokenizerInfo, text string) int {
+	if len(text) == 0 {
+		return 0
+	}
+	if tokenizer.tokenizer != nil {
+		return tokenizer.tokenizer.Count(text)
+	}
+	if tokenizer.Encoding == EncodingWordEstimate {
+		c.logger.Warnf("No tokenizer for model '%s', using fallback token counting", tokenizer.Model)
+		return c.fallbackTokenCount(text)
+	}
+
+	encoding, err := getEncoding(tokenizer.Encoding)
+	if err != nil {
+		c.logger.Errorf("Failed to get %s encoding for model %s: %v", tokenizer.Encoding, tokenizer.Model, err)
+		return c.fallbackTokenCount(text)
+	}
+	return len(encoding.Encode(text, nil, nil))
+}
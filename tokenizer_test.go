@@ -0,0 +1,117 @@
+package paygent
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestModelCatalogCoversDefaultModels(t *testing.T) {
+	client := NewClient("test-api-key")
+	for model := range defaultModelPricing {
+		info := client.TokenizerForModel(model)
+		if info.Provider == "" || info.Encoding == EncodingWordEstimate {
+			t.Errorf("Model %q has no tokenizer in the catalog: %+v", model, info)
+		}
+	}
+}
+
+func TestTokenizerForModel(t *testing.T) {
+	client := NewClient("test-api-key")
+	client.RegisterModelPricing("my-finetune", ModelPricing{PromptTokensCost: 1, CompletionTokensCost: 1, Provider: MistralAI})
+
+	tests := []struct {
+		model    string
+		expected TokenizerInfo
+	}{
+		{model: GPT35Turbo, expected: TokenizerInfo{Model: GPT35Turbo, Provider: OpenAI, Encoding: EncodingCL100kBase, Exact: true}},
+		{model: Sonnet45, expected: TokenizerInfo{Model: Sonnet45, Provider: Anthropic, Encoding: EncodingCL100kBase}},
+		{model: CommandRPlus, expected: TokenizerInfo{Model: CommandRPlus, Provider: Cohere, Encoding: EncodingCL100kBase}},
+		{model: "claude-sonnet-4-5-20250929", expected: TokenizerInfo{Model: Sonnet45, Provider: Anthropic, Encoding: EncodingCL100kBase}},
+		{model: "my-finetune", expected: TokenizerInfo{Model: "my-finetune", Provider: MistralAI, Encoding: EncodingCL100kBase}},
+		{model: "unknown-model", expected: TokenizerInfo{Model: "unknown-model", Encoding: EncodingWordEstimate}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			if got := client.TokenizerForModel(tt.model); got != tt.expected {
+				t.Errorf("TokenizerForModel(%q) = %+v, want %+v", tt.model, got, tt.expected)
+			}
+		})
+	}
+
+	// The usage's service provider is used for models outside the catalog
+	if info := client.tokenizerFor("unknown-model", Anthropic); info.Encoding != EncodingCL100kBase {
+		t.Errorf("Expected the service provider's tokenizer, got %+v", info)
+	}
+}
+
+// fixedTokenizer counts every text as the same number of tokens
+type fixedTokenizer int
+
+func (t fixedTokenizer) Count(string) int { return int(t) }
+
+func TestSetModelTokenizer(t *testing.T) {
+	client := NewClient("test-api-key")
+	client.SetProviderTokenizer(Meta, fixedTokenizer(7))
+	client.SetModelTokenizer(Llama4Scout, fixedTokenizer(3))
+
+	tests := []struct {
+		model    string
+		expected int
+	}{
+		{model: Llama4Scout, expected: 3},
+		{model: "meta-llama/Llama-4-Scout-17B-16E-Instruct", expected: 3},
+		{model: Llama4Maverick, expected: 7},
+	}
+
+	for _, tt := range tests {
+		info := client.TokenizerForModel(tt.model)
+		if info.Encoding != EncodingCustom || !info.Exact {
+			t.Errorf("Expected a custom tokenizer for %s, got %+v", tt.model, info)
+		}
+		if got := client.countTokens(info, "some text"); got != tt.expected {
+			t.Errorf("Expected %d tokens for %s, got %d", tt.expected, tt.model, got)
+		}
+	}
+
+	client.SetModelTokenizer(Llama4Scout, nil)
+	client.SetProviderTokenizer(Meta, nil)
+	if info := client.TokenizerForModel(Llama4Scout); info.Encoding != EncodingCL100kBase {
+		t.Errorf("Expected the default tokenizer after removal, got %+v", info)
+	}
+}
+
+// countingTokenizer records the texts it counts
+type countingTokenizer struct {
+	mu    sync.Mutex
+	texts []string
+}
+
+func (t *countingTokenizer) Count(text string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.texts = append(t.texts, text)
+	return len(strings.Fields(text))
+}
+
+func TestStringsTokenizedOnce(t *testing.T) {
+	tokenizer := &countingTokenizer{}
+	client := NewClient("test-api-key")
+	client.SetModelTokenizer(GPT4O, tokenizer)
+
+	apiRequest, err := client.BuildUsageRequestFromStrings("agent", "customer", "indicator", UsageDataWithStrings{
+		Model:        GPT4O,
+		PromptString: "What is the capital of France?",
+		OutputString: "Paris.",
+	})
+	if err != nil {
+		t.Fatalf("BuildUsageRequestFromStrings() error = %v", err)
+	}
+	if len(tokenizer.texts) != 2 {
+		t.Errorf("Expected the prompt and output to be tokenized once each, got %q", tokenizer.texts)
+	}
+	if apiRequest.InputToken != 6 || apiRequest.OutputToken != 1 {
+		t.Errorf("Expected 6 input and 1 output tokens, got %d and %d", apiRequest.InputToken, apiRequest.OutputToken)
+	}
+}
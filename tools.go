@@ -0,0 +1,296 @@
+package paygent
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Tool choices of a request. Any other value names the tool the model must call.
+const (
+	// ToolChoiceAuto lets the model decide whether to call a tool. It is the default.
+	ToolChoiceAuto = "auto"
+	// ToolChoiceNone prevents the model from calling tools
+	ToolChoiceNone = "none"
+	// ToolChoiceRequired makes the model call at least one tool, "any" in Anthropic's API
+	ToolChoiceRequired = "required"
+)
+
+// ToolDefinition is a tool or function offered to the model
+type ToolDefinition struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Parameters is the JSON Schema of the tool's arguments
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+}
+
+// toolSerialization is how a provider writes tool definitions into the prompt
+type toolSerialization int
+
+const (
+	// toolsJSON writes definitions as JSON
+	toolsJSON toolSerialization = iota
+	// toolsTypeScript writes definitions as a TypeScript namespace, as OpenAI does
+	toolsTypeScript
+	// toolsAnthropic adds a tool use system prompt and writes definitions and calls as JSON
+	toolsAnthropic
+)
+
+// openAIToolsOverhead is the tokens OpenAI adds around tool definitions
+const openAIToolsOverhead = 9
+
+// openAIToolsSystemDiscount is the tokens saved when OpenAI merges tool
+// definitions into an existing system message
+const openAIToolsSystemDiscount = 4
+
+// anthropicToolPromptTokens is the size of the system prompt Anthropic adds
+// to requests with tools, for tool choice auto or none and for any or a named
+// tool. Models not listed use defaultAnthropicToolPromptTokens.
+var anthropicToolPromptTokens = map[string][2]int{
+	Haiku35: {264, 340},
+	Opus3:   {530, 281},
+	Haiku3:  {264, 340},
+}
+
+var defaultAnthropicToolPromptTokens = [2]int{346, 313}
+
+// CountToolDefinitionTokens returns the prompt tokens of the tool definitions
+// of a request to model, written the way the model's provider writes them.
+// toolChoice is one of the ToolChoice constants or the name of a tool.
+func (c *Client) CountToolDefinitionTokens(model string, tools []ToolDefinition, toolChoice string) int {
+	return c.countToolDefinitionTokens(c.TokenizerForModel(model), tools, toolChoice)
+}
+
+// CountToolCallTokens returns the tokens of tool calls made by model
+func (c *Client) CountToolCallTokens(model string, calls []ToolCall) int {
+	return c.countToolCallTokens(c.TokenizerForModel(model), calls)
+}
+
+// countToolDefinitionTokens counts the prompt tokens of tool definitions with a tokenizer
+func (c *Client) countToolDefinitionTokens(tokenizer TokenizerInfo, tools []ToolDefinition, toolChoice string) int {
+	if len(tools) == 0 {
+		return 0
+	}
+
+	switch chatFormatFor(tokenizer).tools {
+	case toolsTypeScript:
+		tokens := c.countTokens(tokenizer, formatTypeScriptTools(tools)) + openAIToolsOverhead
+		switch toolChoice {
+		case "", ToolChoiceAuto, ToolChoiceRequired:
+		case ToolChoiceNone:
+			tokens++
+		default:
+			tokens += c.countTokens(tokenizer, toolChoice) + 4
+		}
+		return tokens
+	case toolsAnthropic:
+		prompt, ok := anthropicToolPromptTokens[tokenizer.Model]
+		if !ok {
+			prompt = defaultAnthropicToolPromptTokens
+		}
+		tokens := prompt[0]
+		if forcesToolCall(toolChoice) {
+			tokens = prompt[1]
+		}
+		for _, tool := range tools {
+			tokens += c.countTokens(tokenizer, toolJSON(tool, "input_schema"))
+		}
+		return tokens
+	default:
+		tokens := 0
+		for _, tool := range tools {
+			tokens += c.countTokens(tokenizer, toolJSON(tool, "parameters"))
+		}
+		return tokens
+	}
+}
+
+// countRequestToolTokens counts the tool definitions of a chat request
+func (c *Client) countRequestToolTokens(tokenizer TokenizerInfo, messages []ChatMessage, tools []ToolDefinition, toolChoice string) int {
+	tokens := c.countToolDefinitionTokens(tokenizer, tools, toolChoice)
+	if tokens > 0 && chatFormatFor(tokenizer).tools == toolsTypeScript && hasSystemMessage(messages) {
+		tokens -= openAIToolsSystemDiscount
+	}
+	return tokens
+}
+
+// countToolCallTokens counts the tokens of tool calls with a tokenizer
+func (c *Client) countToolCallTokens(tokenizer TokenizerInfo, calls []ToolCall) int {
+	format := chatFormatFor(tokenizer)
+
+	tokens := 0
+	for _, call := range calls {
+		if format.tools == toolsAnthropic {
+			// Calls are tool_use content blocks
+			tokens += c.countTokens(tokenizer, toolUseJSON(call))
+			continue
+		}
+		tokens += format.perToolCall + c.countTokens(tokenizer, call.Name) + c.countTokens(tokenizer, call.Arguments)
+	}
+	return tokens
+}
+
+// forcesToolCall reports whether a tool choice makes the model call a tool
+func forcesToolCall(toolChoice string) bool {
+	switch toolChoice {
+	case "", ToolChoiceAuto, ToolChoiceNone:
+		return false
+	default:
+		return true
+	}
+}
+
+// hasSystemMessage reports whether messages include a system message
+func hasSystemMessage(messages []ChatMessage) bool {
+	for _, message := range messages {
+		if message.Role == RoleSystem {
+			return true
+		}
+	}
+	return false
+}
+
+// toolJSON serializes a tool definition with its schema under schemaKey
+func toolJSON(tool ToolDefinition, schemaKey string) string {
+	definition := map[string]any{"name": tool.Name}
+	if tool.Description != "" {
+		definition["description"] = tool.Description
+	}
+	if len(tool.Parameters) > 0 {
+		definition[schemaKey] = tool.Parameters
+	}
+	data, err := json.Marshal(definition)
+	if err != nil {
+		// Parameters are not valid JSON; count them as given
+		return tool.Name + " " + tool.Description + " " + string(tool.Parameters)
+	}
+	return string(data)
+}
+
+// toolUseJSON serializes a tool call as an Anthropic tool_use block
+func toolUseJSON(call ToolCall) string {
+	input := json.RawMessage(call.Arguments)
+	if !json.Valid(input) {
+		input = json.RawMessage("{}")
+	}
+	data, err := json.Marshal(map[string]any{"type": "tool_use", "id": call.ID, "name": call.Name, "input": input})
+	if err != nil {
+		return call.Name + " " + call.Arguments
+	}
+	return string(data)
+}
+
+// toolSchema is the part of a JSON Schema written into OpenAI's tool namespace
+type toolSchema struct {
+	Type        any                    `json:"type"`
+	Description string                 `json:"description"`
+	Enum        []any                  `json:"enum"`
+	Properties  map[string]*toolSchema `json:"properties"`
+	Required    []string               `json:"required"`
+	Items       *toolSchema            `json:"items"`
+}
+
+// formatTypeScriptTools writes tool definitions the way OpenAI adds them to
+// the prompt: as TypeScript function types in a "functions" namespace
+func formatTypeScriptTools(tools []ToolDefinition) string {
+	lines := []string{"namespace functions {", ""}
+	for _, tool := range tools {
+		if tool.Description != "" {
+			lines = append(lines, "// "+tool.Description)
+		}
+		var schema toolSchema
+		if len(tool.Parameters) > 0 && json.Unmarshal(tool.Parameters, &schema) == nil && len(schema.Properties) > 0 {
+			lines = append(lines, fmt.Sprintf("type %s = (_: {", tool.Name), formatTypeScriptProperties(&schema, 0), "}) => any;")
+		} else {
+			lines = append(lines, fmt.Sprintf("type %s = () => any;", tool.Name))
+		}
+		lines = append(lines, "")
+	}
+	lines = append(lines, "} // namespace functions")
+	return strings.Join(lines, "\n")
+}
+
+// formatTypeScriptProperties writes the properties of an object schema as
+// TypeScript fields, in name order
+func formatTypeScriptProperties(schema *toolSchema, indent int) string {
+	required := make(map[string]bool, len(schema.Required))
+	for _, name := range schema.Required {
+		required[name] = true
+	}
+	names := make([]string, 0, len(schema.Properties))
+	for name := range schema.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		property := schema.Properties[name]
+		if property == nil {
+			property = &toolSchema{}
+		}
+		if property.Description != "" && indent < 2 {
+			lines = append(lines, "// "+property.Description)
+		}
+		optional := "?"
+		if required[name] {
+			optional = ""
+		}
+		lines = append(lines, fmt.Sprintf("%s%s: %s,", name, optional, formatTypeScriptType(property, indent)))
+	}
+
+	prefix := strings.Repeat(" ", indent)
+	for i := range lines {
+		lines[i] = prefix + lines[i]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatTypeScriptType writes the TypeScript type of a schema
+func formatTypeScriptType(schema *toolSchema, indent int) string {
+	var types []string
+	switch t := schema.Type.(type) {
+	case string:
+		types = []string{t}
+	case []any:
+		for _, v := range t {
+			if s, ok := v.(string); ok {
+				types = append(types, s)
+			}
+		}
+	}
+
+	var formatted []string
+	for _, t := range types {
+		switch t {
+		case "string", "number", "integer":
+			if len(schema.Enum) > 0 {
+				values := make([]string, 0, len(schema.Enum))
+				for _, value := range schema.Enum {
+					data, _ := json.Marshal(value)
+					values = append(values, string(data))
+				}
+				formatted = append(formatted, strings.Join(values, " | "))
+			} else if t == "string" {
+				formatted = append(formatted, "string")
+			} else {
+				formatted = append(formatted, "number")
+			}
+		case "boolean", "null":
+			formatted = append(formatted, t)
+		case "object":
+			formatted = append(formatted, "{\n"+formatTypeScriptProperties(schema, indent+2)+"\n}")
+		case "array":
+			if schema.Items != nil {
+				formatted = append(formatted, formatTypeScriptType(schema.Items, indent)+"[]")
+			} else {
+				formatted = append(formatted, "any[]")
+			}
+		}
+	}
+	if len(formatted) == 0 {
+		return "any"
+	}
+	return strings.Join(formatted, " | ")
+}
@@ -0,0 +1,138 @@
+package paygent
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// weatherTool is a tool definition with nested, optional and enum parameters
+var weatherTool = ToolDefinition{
+	Name:        "get_weather",
+	Description: "Get the current weather",
+	Parameters: json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"location": {"type": "string", "description": "City and country"},
+			"unit": {"type": "string", "enum": ["celsius", "fahrenheit"]},
+			"days": {"type": ["integer", "null"]},
+			"options": {"type": "object", "properties": {"hourly": {"type": "boolean", "description": "Hourly forecast"}}},
+			"tags": {"type": "array", "items": {"type": "string"}}
+		},
+		"required": ["location"]
+	}`),
+}
+
+func TestFormatTypeScriptTools(t *testing.T) {
+	expected := `namespace functions {
+
+// Get the current weather
+type get_weather = (_: {
+days?: number | null,
+// City and country
+location: string,
+options?: {
+  hourly?: boolean,
+},
+tags?: string[],
+unit?: "celsius" | "fahrenheit",
+}) => any;
+
+type ping = () => any;
+
+} // namespace functions`
+
+	if got := formatTypeScriptTools([]ToolDefinition{weatherTool, {Name: "ping"}}); got != expected {
+		t.Errorf("formatTypeScriptTools() =\n%s\nwant\n%s", got, expected)
+	}
+}
+
+func TestCountToolDefinitionTokens(t *testing.T) {
+	client := NewClient("test-api-key")
+	for _, provider := range []string{OpenAI, Anthropic, GoogleDeepMind} {
+		client.SetProviderTokenizer(provider, wordTokenizer{})
+	}
+	tools := []ToolDefinition{weatherTool}
+	openAITokens := wordTokenizer{}.Count(formatTypeScriptTools(tools)) + openAIToolsOverhead
+	anthropicTokens := wordTokenizer{}.Count(toolJSON(weatherTool, "input_schema"))
+
+	tests := []struct {
+		name       string
+		model      string
+		toolChoice string
+		expected   int
+	}{
+		{name: "OpenAI auto", model: GPT41, expected: openAITokens},
+		{name: "OpenAI none", model: GPT41, toolChoice: ToolChoiceNone, expected: openAITokens + 1},
+		{name: "OpenAI named tool", model: GPT41, toolChoice: "get_weather", expected: openAITokens + 5},
+		{name: "Anthropic auto", model: Sonnet45, expected: 346 + anthropicTokens},
+		{name: "Anthropic any", model: Sonnet45, toolChoice: "any", expected: 313 + anthropicTokens},
+		{name: "Anthropic Haiku 3.5", model: Haiku35, toolChoice: ToolChoiceAuto, expected: 264 + anthropicTokens},
+		{name: "Gemini", model: Gemini25Pro, expected: wordTokenizer{}.Count(toolJSON(weatherTool, "parameters"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := client.CountToolDefinitionTokens(tt.model, tools, tt.toolChoice); got != tt.expected {
+				t.Errorf("CountToolDefinitionTokens() = %d, want %d", got, tt.expected)
+			}
+		})
+	}
+
+	if got := client.CountToolDefinitionTokens(GPT41, nil, ToolChoiceAuto); got != 0 {
+		t.Errorf("Expected no tokens without tools, got %d", got)
+	}
+}
+
+func TestCountToolCallTokens(t *testing.T) {
+	client := NewClient("test-api-key")
+	client.SetProviderTokenizer(OpenAI, wordTokenizer{})
+	client.SetProviderTokenizer(Anthropic, wordTokenizer{})
+	calls := []ToolCall{{ID: "call_1", Name: "get_weather", Arguments: `{"location": "Paris"}`}}
+
+	// 3 per call + 1 name + 2 arguments
+	if got := client.CountToolCallTokens(GPT4O, calls); got != 6 {
+		t.Errorf("Expected 6 OpenAI tokens, got %d", got)
+	}
+	// {"id":"call_1","input":{"location": "Paris"},"name":"get_weather","type":"tool_use"}
+	if got := client.CountToolCallTokens(Sonnet45, calls); got != 1 {
+		t.Errorf("Expected 1 Anthropic token, got %d", got)
+	}
+}
+
+func TestToolsInUsageRequests(t *testing.T) {
+	client := NewClient("test-api-key")
+	client.SetProviderTokenizer(OpenAI, wordTokenizer{})
+	tools := []ToolDefinition{weatherTool}
+	toolTokens := client.CountToolDefinitionTokens(GPT4O, tools, ToolChoiceAuto)
+
+	fromStrings, err := client.BuildUsageRequestFromStrings("agent", "customer", "chat", UsageDataWithStrings{
+		Model:           GPT4O,
+		PromptString:    "Weather in Paris?",
+		OutputString:    "",
+		Tools:           tools,
+		OutputToolCalls: []ToolCall{{Name: "get_weather", Arguments: `{"location": "Paris"}`}},
+	})
+	if err != nil {
+		t.Fatalf("BuildUsageRequestFromStrings() error = %v", err)
+	}
+	if fromStrings.InputToken != 3+toolTokens || fromStrings.OutputToken != 6 {
+		t.Errorf("Unexpected tokens from strings: %d in, %d out", fromStrings.InputToken, fromStrings.OutputToken)
+	}
+
+	fromMessages, err := client.BuildUsageRequestFromMessages("agent", "customer", "chat", UsageDataWithMessages{
+		Model: GPT4O,
+		Messages: []ChatMessage{
+			{Role: RoleSystem, Content: "Be brief."},
+			{Role: RoleUser, Content: "Weather in Paris?"},
+		},
+		Tools: tools,
+	})
+	if err != nil {
+		t.Fatalf("BuildUsageRequestFromMessages() error = %v", err)
+	}
+	// The definitions are merged into the system message
+	messageTokens := client.CountMessageTokens(GPT4O, []ChatMessage{{Role: RoleSystem, Content: "Be brief."}, {Role: RoleUser, Content: "Weather in Paris?"}})
+	if fromMessages.InputToken != messageTokens+toolTokens-openAIToolsSystemDiscount {
+		t.Errorf("Expected %d input tokens, got %d", messageTokens+toolTokens-openAIToolsSystemDiscount, fromMessages.InputToken)
+	}
+}
@@ -0,0 +1,145 @@
+package paygent
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrUnknownModel is returned when usage is for a model without a price and
+// the client's unknown-model policy is UnknownModelReject
+var ErrUnknownModel = errors.New("unknown model")
+
+// UnknownModelPolicy controls how usage for a model without a price is billed
+type UnknownModelPolicy int
+
+const (
+	// UnknownModelFallback bills at the fallback price, $0.10 per 1000 tokens
+	// unless changed with SetFallbackPricing
+	UnknownModelFallback UnknownModelPolicy = iota
+	// UnknownModelReject fails with ErrUnknownModel
+	UnknownModelReject
+	// UnknownModelMatch bills at the price of the closest known model, e.g.
+	// "openai/gpt-4o-mini-acme-support" at the price of "gpt-4o-mini", and fails
+	// with ErrUnknownModel when no model is close
+	UnknownModelMatch
+	// UnknownModelServerPriced sends the usage with a zero amount and the
+	// serverPricing flag set, leaving the price to the Paygent server
+	UnknownModelServerPriced
+)
+
+// String returns the name of the policy
+func (p UnknownModelPolicy) String() string {
+	switch p {
+	case UnknownModelFallback:
+		return "fallback"
+	case UnknownModelReject:
+		return "reject"
+	case UnknownModelMatch:
+		return "match"
+	case UnknownModelServerPriced:
+		return "server_priced"
+	default:
+		return "unknown"
+	}
+}
+
+// SetUnknownModelPolicy sets how usage for models without a price is billed.
+// The default is UnknownModelFallback.
+func (c *Client) SetUnknownModelPolicy(policy UnknownModelPolicy) {
+	c.unknownModelPolicy = policy
+}
+
+// SetFallbackPricing sets the price used for models without a price under the
+// UnknownModelFallback policy
+func (c *Client) SetFallbackPricing(pricing ModelPricing) {
+	c.fallbackPricing = pricing
+}
+
+// resolvePricing looks up the price of model at the given time for cost
+// calculation. Models without a price are resolved through the model aliases,
+// then with the unknown-model policy. pricedAs is the model whose price is used.
+func (c *Client) resolvePricing(model string, at time.Time) (pricing ModelPricing, source PricingSource, pricedAs string, err error) {
+	if pricing, source, ok := c.pricing.Lookup(model, at); ok {
+		return pricing, source, model, nil
+	}
+	if canonical, ok := c.canonicalModel(model, at); ok {
+		pricing, source, _ := c.pricing.Lookup(canonical, at)
+		c.logger.Debugf("Model '%s' resolved to '%s'", model, canonical)
+		return pricing, source, canonical, nil
+	}
+
+	switch c.unknownModelPolicy {
+	case UnknownModelReject:
+		return ModelPricing{}, 0, "", fmt.Errorf("%w %q: no price registered", ErrUnknownModel, model)
+	case UnknownModelMatch:
+		if match, ok := c.closestModel(model, at); ok {
+			pricing, source, _ := c.pricing.Lookup(match, at)
+			c.logger.Warnf("Unknown model '%s', using pricing of '%s'", model, match)
+			return pricing, source, match, nil
+		}
+		return ModelPricing{}, 0, "", fmt.Errorf("%w %q: no price registered and no similar model", ErrUnknownModel, model)
+	case UnknownModelServerPriced:
+		c.logger.Warnf("Unknown model '%s', leaving pricing to the server", model)
+		return ModelPricing{}, PricingSourceServer, model, nil
+	default:
+		c.logger.Warnf("Unknown model '%s', using default pricing", model)
+		return c.fallbackPricing, PricingSourceFallback, model, nil
+	}
+}
+
+// modelLister is implemented by registries that can list their models
+type modelLister interface {
+	Models() []string
+}
+
+// closestModel finds the known model an unknown model name most likely refers
+// to: the same name ignoring case and any "provider/" prefix, or else the
+// longest known model the name extends with a version or date suffix
+func (c *Client) closestModel(model string, at time.Time) (string, bool) {
+	lister, ok := c.pricing.(modelLister)
+	if !ok {
+		return "", false
+	}
+
+	name := strings.ToLower(strings.TrimSpace(model))
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	var best string
+	for _, known := range lister.Models() {
+		if _, _, ok := c.pricing.Lookup(known, at); !ok {
+			continue
+		}
+		candidate := strings.ToLower(known)
+		if candidate == name {
+			return known, true
+		}
+		if len(candidate) > len(best) && hasVersionSuffix(name, candidate) {
+			best = known
+		}
+	}
+	return best, best != ""
+}
+
+// hasVersionSuffix reports whether name is base followed by a separator and a suffix
+func hasVersionSuffix(name, base string) bool {
+	if len(name) <= len(base) || !strings.HasPrefix(name, base) {
+		return false
+	}
+	switch name[len(base)] {
+	case '-', '@', ':', '.', '_':
+		return true
+	}
+	return false
+}
+
+// pricedAsModel returns the model the usage was priced as when it differs from model
+func (b costBreakdown) pricedAsModel(model string) string {
+	if b.PricedAs == model {
+		return ""
+	}
+	return b.PricedAs
+}
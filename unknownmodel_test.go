@@ -0,0 +1,77 @@
+package paygent
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUnknownModelPolicy(t *testing.T) {
+	usageData := UsageData{Model: "openai/gpt-4o-mini-acme-support", PromptTokens: 1000, CompletionTokens: 1000}
+
+	tests := []struct {
+		name            string
+		policy          UnknownModelPolicy
+		expected        float64
+		expectedErr     error
+		expectedPriced  string
+		expectedServer  bool
+		expectedVersion string
+	}{
+		{name: "Fallback", policy: UnknownModelFallback, expected: 0.2, expectedVersion: "fallback"},
+		{name: "Reject", policy: UnknownModelReject, expectedErr: ErrUnknownModel},
+		{name: "Match", policy: UnknownModelMatch, expected: 0.00015 + 0.0006, expectedPriced: GPT4OMini, expectedVersion: "default:" + GPT4OMini},
+		{name: "Server priced", policy: UnknownModelServerPriced, expectedServer: true, expectedVersion: "server"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient("test-api-key")
+			client.SetUnknownModelPolicy(tt.policy)
+
+			request, err := client.BuildUsageRequest("agent", "customer", "indicator", usageData)
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Errorf("Expected %v, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BuildUsageRequest() error = %v", err)
+			}
+			if request.Amount != MoneyFromFloat(tt.expected) {
+				t.Errorf("Expected amount %v, got %s", tt.expected, request.Amount)
+			}
+			if request.PricedAsModel != tt.expectedPriced || request.ServerPricing != tt.expectedServer || request.PricingVersion != tt.expectedVersion {
+				t.Errorf("Expected pricedAsModel %q, serverPricing %v, version %q, got %q, %v, %q",
+					tt.expectedPriced, tt.expectedServer, tt.expectedVersion, request.PricedAsModel, request.ServerPricing, request.PricingVersion)
+			}
+		})
+	}
+}
+
+func TestUnknownModelMatchWithoutSimilarModel(t *testing.T) {
+	client := NewClient("test-api-key")
+	client.SetUnknownModelPolicy(UnknownModelMatch)
+
+	for _, model := range []string{"totally-new-model", "gpt-4oo"} {
+		if _, err := client.calculateCost(model, UsageData{PromptTokens: 10}); !errors.Is(err, ErrUnknownModel) {
+			t.Errorf("Expected ErrUnknownModel for %q, got %v", model, err)
+		}
+	}
+}
+
+func TestSetFallbackPricing(t *testing.T) {
+	client := NewClient("test-api-key")
+	client.SetFallbackPricing(ModelPricing{PromptTokensCost: 0.001, CompletionTokensCost: 0.002})
+
+	cost, err := client.calculateCost("unknown-model", UsageData{PromptTokens: 1000, CompletionTokens: 1000})
+	if err != nil {
+		t.Fatalf("calculateCost() error = %v", err)
+	}
+	if cost != 0.003 {
+		t.Errorf("Expected 0.003, got %v", cost)
+	}
+	if pricing, source, _, _ := client.LookupPricing("unknown-model"); source != PricingSourceFallback || pricing.PromptTokensCost != 0.001 {
+		t.Errorf("Expected caller-provided fallback pricing, got %+v from %v", pricing, source)
+	}
+}